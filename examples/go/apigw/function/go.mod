@@ -1 +1,3 @@
 module sls-api-gw-handler
+
+go 1.27.1
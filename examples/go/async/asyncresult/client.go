@@ -0,0 +1,238 @@
+package asyncresult
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+)
+
+const (
+	// DefaultEndpoint is the URL functions are invoked at.
+	DefaultEndpoint = "https://functions.yandexcloud.net"
+	// RequestIDHeader is the response header with the request ID of an invocation.
+	RequestIDHeader = "X-Request-Id"
+
+	// longPollWait is how long a receive waits for results, the longest the queue supports.
+	longPollWait = 20 * time.Second
+	// receiveRetryDelay is how long a queue is not received from after a failed receive.
+	receiveRetryDelay = time.Second
+	// otherResultVisibility is how long a result nobody here awaits stays hidden, so it is not received again
+	// and again while it is awaited elsewhere.
+	otherResultVisibility = time.Second
+)
+
+// queueAPI is the part of the SQS client the results are received with.
+type queueAPI interface {
+	ReceiveMessage(ctx context.Context, params *sqs.ReceiveMessageInput, optFns ...func(*sqs.Options)) (*sqs.ReceiveMessageOutput, error)
+	DeleteMessage(ctx context.Context, params *sqs.DeleteMessageInput, optFns ...func(*sqs.Options)) (*sqs.DeleteMessageOutput, error)
+	ChangeMessageVisibility(ctx context.Context, params *sqs.ChangeMessageVisibilityInput, optFns ...func(*sqs.Options)) (*sqs.ChangeMessageVisibilityOutput, error)
+}
+
+// Client submits asynchronous invocations of a function and awaits their results in its success and failure
+// queues. It is safe for concurrent use.
+//
+// While any result is awaited, the client receives from each of the queues in a goroutine of its own and
+// hands the results to their awaiters by request ID. The goroutines stop when nothing is awaited anymore.
+type Client struct {
+	// HTTP invokes the function, http.DefaultClient if nil.
+	HTTP *http.Client
+	// Endpoint is the URL functions are invoked at, DefaultEndpoint if empty.
+	Endpoint string
+
+	queues          queueAPI
+	successQueueURL string
+	failureQueueURL string
+
+	mu sync.Mutex
+	// awaited holds the channel each awaited result is handed to, by request ID.
+	awaited map[string]chan *Result
+	// stop stops the receiving goroutines, nil if they are not running.
+	stop context.CancelFunc
+}
+
+// NewClient creates a client reading the results from the success and failure queues by their URLs.
+// The failure queue URL may be empty if the function has no failure target.
+func NewClient(queues queueAPI, successQueueURL, failureQueueURL string) *Client {
+	return &Client{
+		queues:          queues,
+		successQueueURL: successQueueURL,
+		failureQueueURL: failureQueueURL,
+		awaited:         map[string]chan *Result{},
+	}
+}
+
+// Submit invokes the function asynchronously with the payload and returns the request ID of the invocation.
+func (c *Client) Submit(ctx context.Context, functionID string, payload []byte) (string, error) {
+	endpoint := c.Endpoint
+	if endpoint == "" {
+		endpoint = DefaultEndpoint
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint+"/"+functionID+"?integration=async", bytes.NewReader(payload))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	httpClient := c.HTTP
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to invoke function %s: %w", functionID, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusAccepted {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return "", fmt.Errorf("function %s did not accept the invocation: %s %s", functionID, resp.Status, body)
+	}
+	requestID := resp.Header.Get(RequestIDHeader)
+	if requestID == "" {
+		return "", fmt.Errorf("function %s accepted the invocation without a request ID", functionID)
+	}
+	return requestID, nil
+}
+
+// Await waits for the result of the invocation with the request ID until the context is done.
+// The result is deleted from its queue. A failed invocation is returned as the result with its Error set.
+//
+// Results nobody here awaits are put back into the queue after a second, as they may be awaited elsewhere.
+// Results without a request ID cannot be told apart and are left in the queue, so an invocation whose failure
+// is reported without one is awaited until the context is done.
+func (c *Client) Await(ctx context.Context, requestID string) (*Result, error) {
+	results := c.await(requestID)
+	defer c.forget(requestID)
+	select {
+	case result := <-results:
+		return result, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// Call submits the invocation and awaits its result.
+func (c *Client) Call(ctx context.Context, functionID string, payload []byte) (*Result, error) {
+	requestID, err := c.Submit(ctx, functionID, payload)
+	if err != nil {
+		return nil, err
+	}
+	return c.Await(ctx, requestID)
+}
+
+// await registers the request as awaited and starts receiving the results if the client is not yet.
+func (c *Client) await(requestID string) chan *Result {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	results, ok := c.awaited[requestID]
+	if !ok {
+		results = make(chan *Result, 1)
+		c.awaited[requestID] = results
+	}
+	if c.stop == nil {
+		var ctx context.Context
+		ctx, c.stop = context.WithCancel(context.Background())
+		go c.receiveLoop(ctx, c.successQueueURL, false)
+		if c.failureQueueURL != "" {
+			go c.receiveLoop(ctx, c.failureQueueURL, true)
+		}
+	}
+	return results
+}
+
+// forget stops awaiting the request, and stops receiving once nothing is awaited.
+func (c *Client) forget(requestID string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.awaited, requestID)
+	if len(c.awaited) == 0 && c.stop != nil {
+		c.stop()
+		c.stop = nil
+	}
+}
+
+// receiveLoop receives the results in the queue until the context is canceled.
+func (c *Client) receiveLoop(ctx context.Context, queueURL string, failed bool) {
+	for ctx.Err() == nil {
+		if err := c.receive(ctx, queueURL, failed); err != nil && ctx.Err() == nil {
+			log.Printf("failed to receive results from %s: %v", queueURL, err)
+			select {
+			case <-time.After(receiveRetryDelay):
+			case <-ctx.Done():
+			}
+		}
+	}
+}
+
+// receive long-polls the queue once and hands the awaited results to their awaiters.
+func (c *Client) receive(ctx context.Context, queueURL string, failed bool) error {
+	out, err := c.queues.ReceiveMessage(ctx, &sqs.ReceiveMessageInput{
+		QueueUrl:              aws.String(queueURL),
+		MaxNumberOfMessages:   10,
+		WaitTimeSeconds:       int32(longPollWait / time.Second),
+		MessageAttributeNames: []string{"All"},
+	})
+	if err != nil {
+		return err
+	}
+
+	// The messages are settled even if the receiving stops meanwhile
+	ctx = context.WithoutCancel(ctx)
+	for _, m := range out.Messages {
+		messageID := aws.ToString(m.MessageId)
+		result, err := Decode([]byte(aws.ToString(m.Body)), stringAttributes(m.MessageAttributes), failed)
+		if err != nil {
+			log.Printf("skipping message %s: %v", messageID, err)
+			continue
+		}
+		if result.RequestID == "" {
+			log.Printf("skipping result %s without a request ID", messageID)
+			continue
+		}
+
+		c.mu.Lock()
+		results := c.awaited[result.RequestID]
+		c.mu.Unlock()
+		if results == nil {
+			_, err = c.queues.ChangeMessageVisibility(ctx, &sqs.ChangeMessageVisibilityInput{
+				QueueUrl:          aws.String(queueURL),
+				ReceiptHandle:     m.ReceiptHandle,
+				VisibilityTimeout: int32(otherResultVisibility / time.Second),
+			})
+			if err != nil {
+				log.Printf("failed to put back result %s: %v", result.RequestID, err)
+			}
+			continue
+		}
+
+		_, err = c.queues.DeleteMessage(ctx, &sqs.DeleteMessageInput{QueueUrl: aws.String(queueURL), ReceiptHandle: m.ReceiptHandle})
+		if err != nil {
+			log.Printf("failed to delete result %s: %v", result.RequestID, err)
+		}
+		select {
+		case results <- result:
+		default:
+			// A duplicate of a result already received
+		}
+	}
+	return nil
+}
+
+// stringAttributes returns the values of the string attributes of the message.
+func stringAttributes(attributes map[string]types.MessageAttributeValue) map[string]string {
+	values := make(map[string]string, len(attributes))
+	for name, attr := range attributes {
+		if attr.StringValue != nil {
+			values[name] = *attr.StringValue
+		}
+	}
+	return values
+}
@@ -0,0 +1,225 @@
+package asyncresult
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+)
+
+// memoryQueues keeps the messages of the queues in memory. Received messages are hidden until they are released.
+type memoryQueues struct {
+	mu       sync.Mutex
+	messages map[string][]*memoryMessage
+	nextID   int
+}
+
+type memoryMessage struct {
+	id, body   string
+	attributes map[string]string
+	visibleAt  time.Time
+}
+
+// put adds a message with the string attributes to the queue.
+func (q *memoryQueues) put(queueURL, body string, attributes map[string]string) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.nextID++
+	q.messages[queueURL] = append(q.messages[queueURL], &memoryMessage{id: strconv.Itoa(q.nextID), body: body, attributes: attributes})
+}
+
+func (q *memoryQueues) len(queueURL string) int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.messages[queueURL])
+}
+
+func (q *memoryQueues) ReceiveMessage(ctx context.Context, in *sqs.ReceiveMessageInput, _ ...func(*sqs.Options)) (*sqs.ReceiveMessageOutput, error) {
+	deadline := time.Now().Add(time.Duration(in.WaitTimeSeconds) * time.Second)
+	for {
+		q.mu.Lock()
+		out := &sqs.ReceiveMessageOutput{}
+		now := time.Now()
+		for _, m := range q.messages[aws.ToString(in.QueueUrl)] {
+			if now.Before(m.visibleAt) {
+				continue
+			}
+			m.visibleAt = now.Add(time.Minute)
+			attributes := map[string]types.MessageAttributeValue{}
+			for name, value := range m.attributes {
+				attributes[name] = types.MessageAttributeValue{DataType: aws.String("String"), StringValue: aws.String(value)}
+			}
+			out.Messages = append(out.Messages, types.Message{
+				MessageId:         aws.String(m.id),
+				ReceiptHandle:     aws.String(m.id),
+				Body:              aws.String(m.body),
+				MessageAttributes: attributes,
+			})
+		}
+		q.mu.Unlock()
+		if len(out.Messages) > 0 || !time.Now().Before(deadline) || ctx.Err() != nil {
+			return out, nil
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func (q *memoryQueues) DeleteMessage(_ context.Context, in *sqs.DeleteMessageInput, _ ...func(*sqs.Options)) (*sqs.DeleteMessageOutput, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	messages := q.messages[aws.ToString(in.QueueUrl)]
+	for i, m := range messages {
+		if m.id == aws.ToString(in.ReceiptHandle) {
+			q.messages[aws.ToString(in.QueueUrl)] = append(messages[:i], messages[i+1:]...)
+			break
+		}
+	}
+	return &sqs.DeleteMessageOutput{}, nil
+}
+
+func (q *memoryQueues) ChangeMessageVisibility(_ context.Context, in *sqs.ChangeMessageVisibilityInput, _ ...func(*sqs.Options)) (*sqs.ChangeMessageVisibilityOutput, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for _, m := range q.messages[aws.ToString(in.QueueUrl)] {
+		if m.id == aws.ToString(in.ReceiptHandle) {
+			m.visibleAt = time.Now().Add(time.Duration(in.VisibilityTimeout) * time.Second)
+		}
+	}
+	return &sqs.ChangeMessageVisibilityOutput{}, nil
+}
+
+// startFunction serves asynchronous invocations, answering every one with a new request ID,
+// and puts the result of each invocation to a queue with the result function. As the function of the example,
+// the result carries the request ID in its body, see wrap.
+func startFunction(t *testing.T, queues *memoryQueues, result func(requestID string, payload []byte) (queueURL, body string)) string {
+	t.Helper()
+	var mu sync.Mutex
+	next := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("integration") != "async" {
+			http.Error(w, "not async", http.StatusBadRequest)
+			return
+		}
+		payload, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		next++
+		requestID := "req-" + strconv.Itoa(next)
+		mu.Unlock()
+		go func() {
+			time.Sleep(50 * time.Millisecond)
+			queueURL, body := result(requestID, payload)
+			queues.put(queueURL, body, nil)
+		}()
+		w.Header().Set(RequestIDHeader, requestID)
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	t.Cleanup(srv.Close)
+	return srv.URL
+}
+
+// wrap wraps the response with the request ID, as the function of the example does.
+func wrap(requestID string, response []byte) string {
+	return `{"request_id": "` + requestID + `", "response": ` + string(response) + `}`
+}
+
+func TestCallAwaitsResults(t *testing.T) {
+	queues := &memoryQueues{messages: map[string][]*memoryMessage{}}
+	// A result of an invocation nobody here awaits
+	queues.put("success", wrap("elsewhere", []byte(`{}`)), nil)
+	endpoint := startFunction(t, queues, func(requestID string, payload []byte) (string, string) {
+		if string(payload) == `"fail"` {
+			// A failure wrapped with its request ID
+			return "failure", `{"request_id": "` + requestID + `", "error": {"errorType": "Panic", "errorMessage": "boom"}}`
+		}
+		return "success", wrap(requestID, payload)
+	})
+	client := NewClient(queues, "success", "failure")
+	client.Endpoint = endpoint
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	var wg sync.WaitGroup
+	for _, payload := range []string{`"a"`, `"b"`, `"fail"`, `"c"`} {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			result, err := client.Call(ctx, "function", []byte(payload))
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			var response string
+			err = result.Decode(&response)
+			if payload == `"fail"` {
+				if result.Succeeded() || err == nil {
+					t.Errorf("the failed invocation succeeded: %+v", result)
+				}
+				return
+			}
+			if err != nil || `"`+response+`"` != payload {
+				t.Errorf("unexpected result %s of %s: %v", result.Response, payload, err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if n := queues.len("success"); n != 1 {
+		t.Errorf("%d messages are left in the success queue, expected the foreign one", n)
+	}
+	if n := queues.len("failure"); n != 0 {
+		t.Errorf("%d messages are left in the failure queue", n)
+	}
+}
+
+func TestResultWithoutRequestID(t *testing.T) {
+	queues := &memoryQueues{messages: map[string][]*memoryMessage{}}
+	// A failure of the runtime without the request ID attribute cannot be handed to anyone
+	queues.put("failure", `{"errorType": "Timeout", "errorMessage": "timeout"}`, nil)
+	endpoint := startFunction(t, queues, func(requestID string, payload []byte) (string, string) {
+		return "success", wrap(requestID, payload)
+	})
+	client := NewClient(queues, "success", "failure")
+	client.Endpoint = endpoint
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	result, err := client.Call(ctx, "function", []byte(`"a"`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.Succeeded() || string(result.Response) != `"a"` {
+		t.Errorf("got the result %+v", result)
+	}
+	if n := queues.len("failure"); n != 1 {
+		t.Errorf("%d messages are left in the failure queue, expected the one without a request ID", n)
+	}
+}
+
+func TestSubmitRejected(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "forbidden", http.StatusForbidden)
+	}))
+	defer srv.Close()
+	client := NewClient(nil, "success", "")
+	client.Endpoint = srv.URL
+	if _, err := client.Submit(context.Background(), "function", []byte(`{}`)); err == nil {
+		t.Error("a rejected invocation is submitted")
+	}
+}
+
+func TestAwaitTimeout(t *testing.T) {
+	client := NewClient(&memoryQueues{messages: map[string][]*memoryMessage{}}, "success", "failure")
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	if _, err := client.Await(ctx, "never"); err != context.DeadlineExceeded {
+		t.Errorf("unexpected error %v", err)
+	}
+}
@@ -0,0 +1,114 @@
+// Package asyncresult reads the results of asynchronous function invocations from their message queues.
+//
+// A function invoked with ?integration=async sends the result of every invocation to a queue: the response
+// to its success target, the error to its failure target once its retries are exhausted. Decode turns such
+// a message into a typed Result, and Client submits invocations and awaits their results by request ID.
+package asyncresult
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// RequestIDAttribute is the message attribute with the request ID of the invocation, for messages
+// whose body does not carry it.
+const RequestIDAttribute = "RequestId"
+
+// Result is the outcome of an asynchronous invocation.
+type Result struct {
+	RequestID string
+	// Response is the response of the function, set if the invocation succeeded.
+	Response json.RawMessage
+	// Error is the error of the function, set if the invocation failed.
+	Error *InvocationError
+}
+
+// Succeeded reports whether the invocation succeeded.
+func (r *Result) Succeeded() bool {
+	return r.Error == nil
+}
+
+// Decode decodes the response of a successful invocation into v.
+// It returns the error of the invocation if it failed.
+func (r *Result) Decode(v any) error {
+	if r.Error != nil {
+		return r.Error
+	}
+	if err := json.Unmarshal(r.Response, v); err != nil {
+		return fmt.Errorf("failed to decode the response of request %s: %w", r.RequestID, err)
+	}
+	return nil
+}
+
+// InvocationError is the error an invocation failed with, as the runtime reports it.
+type InvocationError struct {
+	RequestID  string          `json:"-"`
+	Type       string          `json:"errorType"`
+	Message    string          `json:"errorMessage"`
+	StackTrace json.RawMessage `json:"stackTrace,omitempty"`
+}
+
+func (e *InvocationError) Error() string {
+	var b strings.Builder
+	b.WriteString("invocation")
+	if e.RequestID != "" {
+		b.WriteString(" " + e.RequestID)
+	}
+	b.WriteString(" failed")
+	if e.Type != "" {
+		b.WriteString(" with " + e.Type)
+	}
+	if e.Message != "" {
+		b.WriteString(": " + e.Message)
+	}
+	return b.String()
+}
+
+// envelope is the wrapped form of a result, carrying its request ID.
+type envelope struct {
+	RequestID string           `json:"request_id"`
+	Response  json.RawMessage  `json:"response"`
+	Error     *InvocationError `json:"error"`
+}
+
+// ErrNotResult is returned by Decode for a body that is not the result of an invocation.
+var ErrNotResult = errors.New("the message is not a result of an asynchronous invocation")
+
+// Decode decodes a message of a success or failure queue. failed tells which queue the message came from.
+//
+// A body with request_id and either response or error is the wrapped form. Any other body is the response
+// itself for a success queue, and the error of the runtime, {"errorType": ..., "errorMessage": ...},
+// for a failure queue. The request ID of the raw form is taken from the RequestIDAttribute attribute;
+// without it the RequestID of the result is empty, and Client skips such results.
+func Decode(body []byte, attributes map[string]string, failed bool) (*Result, error) {
+	var wrapped envelope
+	if json.Unmarshal(body, &wrapped) == nil && wrapped.RequestID != "" && (wrapped.Response != nil || wrapped.Error != nil) {
+		result := &Result{RequestID: wrapped.RequestID}
+		if wrapped.Error != nil {
+			result.Error = wrapped.Error
+			result.Error.RequestID = wrapped.RequestID
+		} else {
+			result.Response = wrapped.Response
+		}
+		return result, nil
+	}
+
+	if !json.Valid(body) {
+		return nil, fmt.Errorf("%w: %q", ErrNotResult, body)
+	}
+	result := &Result{RequestID: attributes[RequestIDAttribute]}
+	if !failed {
+		result.Response = body
+		return result, nil
+	}
+	invocationErr := &InvocationError{}
+	if err := json.Unmarshal(body, invocationErr); err != nil || (invocationErr.Type == "" && invocationErr.Message == "") {
+		// The failure is not reported in the format of the runtime, keep it as the message
+		invocationErr = &InvocationError{Message: string(body)}
+	}
+	invocationErr.RequestID = result.RequestID
+	result.Error = invocationErr
+	return result, nil
+}
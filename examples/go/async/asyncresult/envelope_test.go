@@ -0,0 +1,58 @@
+package asyncresult
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestDecodeWrapped(t *testing.T) {
+	result, err := Decode([]byte(`{"request_id": "r1", "response": {"name": "test"}}`), nil, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var response struct{ Name string }
+	if result.RequestID != "r1" || !result.Succeeded() || result.Decode(&response) != nil || response.Name != "test" {
+		t.Errorf("unexpected result %+v", result)
+	}
+
+	result, err = Decode([]byte(`{"request_id": "r2", "error": {"errorType": "Panic", "errorMessage": "boom"}}`), nil, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var invocationErr *InvocationError
+	if err = result.Decode(&response); !errors.As(err, &invocationErr) || invocationErr.RequestID != "r2" || invocationErr.Type != "Panic" {
+		t.Errorf("unexpected error %v", err)
+	}
+}
+
+func TestDecodeRaw(t *testing.T) {
+	attributes := map[string]string{RequestIDAttribute: "r3"}
+	result, err := Decode([]byte(`{"name": "test", "result": "success"}`), attributes, false)
+	if err != nil || result.RequestID != "r3" || string(result.Response) != `{"name": "test", "result": "success"}` {
+		t.Errorf("unexpected result %+v, %v", result, err)
+	}
+
+	result, err = Decode([]byte(`{"errorMessage": "timeout", "errorType": "Timeout", "stackTrace": []}`), attributes, true)
+	if err != nil || result.Succeeded() {
+		t.Fatalf("unexpected result %+v, %v", result, err)
+	}
+	if got := result.Error.Error(); got != "invocation r3 failed with Timeout: timeout" {
+		t.Errorf("unexpected error %q", got)
+	}
+
+	// Without the attribute the request ID is unknown
+	result, err = Decode([]byte(`{"errorMessage": "timeout", "errorType": "Timeout"}`), nil, true)
+	if err != nil || result.RequestID != "" || result.Error == nil || result.Error.Error() != "invocation failed with Timeout: timeout" {
+		t.Errorf("unexpected result %+v, %v", result, err)
+	}
+
+	// A failure not in the format of the runtime is kept as the message
+	result, err = Decode([]byte(`"exit status 2"`), nil, true)
+	if err != nil || result.Error == nil || result.Error.Message != `"exit status 2"` {
+		t.Errorf("unexpected result %+v, %v", result, err)
+	}
+
+	if _, err = Decode([]byte("not json"), nil, false); !errors.Is(err, ErrNotResult) {
+		t.Errorf("unexpected error %v", err)
+	}
+}
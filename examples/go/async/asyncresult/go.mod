@@ -0,0 +1,14 @@
+module sls-async-result
+
+go 1.23
+
+require (
+	github.com/aws/aws-sdk-go-v2 v1.36.5
+	github.com/aws/aws-sdk-go-v2/service/sqs v1.38.8
+)
+
+require (
+	github.com/aws/aws-sdk-go-v2/internal/configsources v1.3.36 // indirect
+	github.com/aws/aws-sdk-go-v2/internal/endpoints/v2 v2.6.36 // indirect
+	github.com/aws/smithy-go v1.22.4 // indirect
+)
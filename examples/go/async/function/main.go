@@ -10,7 +10,7 @@ type Req struct {
 	Name string `json:"name"`
 }
 
-func Handler(_ context.Context, req Req) ([]byte, error) {
+func Handler(ctx context.Context, req Req) ([]byte, error) {
 	// get body
 	fmt.Printf("Body: %+v\n", req)
 
@@ -20,7 +20,14 @@ func Handler(_ context.Context, req Req) ([]byte, error) {
 		"name":   req.Name,
 	}
 
-	respBytes, err := json.Marshal(resp)
+	// The response lands in the success queue, wrapped with the request ID of the invocation
+	// so the caller can tell whose result it is, see asyncresult.Decode. The runtime passes the ID
+	// in the context, as it passes the IAM token in lambdaRuntimeTokenJSON
+	requestID, _ := ctx.Value("lambdaRuntimeRequestID").(string)
+	respBytes, err := json.Marshal(map[string]interface{}{
+		"request_id": requestID,
+		"response":   resp,
+	})
 	if err != nil {
 		panic(err)
 	}
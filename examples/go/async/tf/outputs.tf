@@ -22,4 +22,8 @@ output "ymq_reader_access_key" {
 output "ymq_reader_secret_key" {
   value     = yandex_iam_service_account_static_access_key.ymq_reader.secret_key
   sensitive = true
-}
\ No newline at end of file
+}
+
+output "failure_ymq_name" {
+  value = yandex_message_queue.failed_queue.name
+}
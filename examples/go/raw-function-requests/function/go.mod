@@ -1 +1,3 @@
 module rawFunctionRequest
+
+go 1.27.1
@@ -7,81 +7,45 @@ import (
 	"os"
 	"strings"
 	"sync"
-	"time"
 
-	"github.com/redis/go-redis/v9"
+	"redis/azredis"
 )
 
-var azAddr string
-
-type RespWithAddr struct {
-	Resp string
-	Addr string
+// nearest is the client of the replicas, shared by the invocations of the function instance. It measures
+// the replicas on the first invocation and keeps its ranking and connection pools for the next ones.
+var nearest = sync.OnceValues(func() (*azredis.Client, error) {
+	return azredis.New(context.Background(), azredis.Options{
+		Addrs:    redisAddrs(),
+		Username: "default",
+		Password: os.Getenv("REDIS_PASSWORD"),
+	})
+})
+
+// redisAddrs returns the hosts of the cluster from REDIS_ADDRS, a comma-separated list.
+func redisAddrs() []string {
+	var addrs []string
+	for _, addr := range strings.Split(os.Getenv("REDIS_ADDRS"), ",") {
+		if addr = strings.TrimSpace(addr); addr != "" {
+			addrs = append(addrs, addr)
+		}
+	}
+	return addrs
 }
 
 //goland:noinspection ALL
 func AzDetectHandler(ctx context.Context, req Req) ([]byte, error) {
-	addrs := strings.Split(os.Getenv("REDIS_ADDRS"), ",")
-	password := os.Getenv("REDIS_PASSWORD")
-
-	randKey := fmt.Sprintf("key%d", rand.Intn(1000))
-
-	if azAddr != "" {
-		conn := redis.NewUniversalClient(
-			&redis.UniversalOptions{
-				Addrs:    []string{azAddr},
-				Password: password,
-				ReadOnly: true,
-			},
-		)
-		result, err := conn.Get(ctx, randKey).Result()
-		if err == nil {
-			return []byte(result), nil
-		}
-	}
-
-	for i, addr := range addrs {
-		addrs[i] = strings.TrimSpace(addr) + ":6379"
+	client, err := nearest()
+	if err != nil {
+		return nil, err
 	}
-	responseChan := make(chan RespWithAddr, 3)
-	cCtx, cancel := context.WithCancel(ctx)
-	doneChan := make(chan struct{})
-	var wg sync.WaitGroup
-	wg.Add(3)
-	for _, addr := range addrs {
-		go func(addr string) {
-			defer wg.Done()
-			conn := redis.NewUniversalClient(
-				&redis.UniversalOptions{
-					Addrs:    []string{addr},
-					Username: "default",
-					Password: password,
-					ReadOnly: true,
-				},
-			)
-			defer conn.Close()
-			result, err := conn.Get(cCtx, randKey).Result()
-			if err == nil {
-				responseChan <- RespWithAddr{Resp: result, Addr: addr}
-				doneChan <- struct{}{}
-			}
-		}(addr)
 
+	randKey := fmt.Sprintf("key%d", rand.Intn(1000))
+	result, err := client.Get(ctx, randKey)
+	if err != nil {
+		return nil, err
 	}
-	go func() {
-		wg.Wait()
-		close(responseChan)
-		close(doneChan)
-	}()
-
-	<-doneChan
-	cancel()
-
-	select {
-	case result := <-responseChan:
-		azAddr = result.Addr
-		return []byte(result.Resp), nil
-	case <-time.After(5 * time.Second):
-		return nil, fmt.Errorf("timeout waiting for response")
+	if nodes := client.Nodes(); len(nodes) > 0 {
+		fmt.Printf("nearest host: %s, %s\n", nodes[0].Addr, nodes[0].Latency)
 	}
+	return []byte(result), nil
 }
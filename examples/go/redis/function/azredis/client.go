@@ -0,0 +1,290 @@
+// Package azredis connects to the nearest replica of a Redis cluster spread over availability zones.
+//
+// A function runs in one of the zones, and the replica in its zone answers several times faster than
+// the others. The client measures the latency of every host concurrently, sends commands to the fastest
+// healthy one, falls back to the next one if it fails, and measures again when its ranking gets stale.
+//
+// SplitClient adds the master: it finds the hosts through Sentinel, writes to the master, reads from the nearest
+// replica, and finds the master again when it fails over.
+package azredis
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	// DefaultPort is the port of the hosts given without one.
+	DefaultPort = "6379"
+	// DefaultProbeInterval is how long a ranking of the hosts is used before they are measured again.
+	DefaultProbeInterval = 30 * time.Second
+	// DefaultProbeTimeout is how long a host may take to answer a probe.
+	DefaultProbeTimeout = time.Second
+	// DefaultProbeCount is how many pings a probe takes, the fastest of them is the latency of the host.
+	DefaultProbeCount = 3
+)
+
+// ErrNoHealthyNode is returned when no host answers.
+var ErrNoHealthyNode = errors.New("no redis host is available")
+
+// Options configure the client.
+type Options struct {
+	// Addrs are the hosts of the cluster, with or without a port.
+	Addrs    []string
+	Username string
+	Password string
+
+	// ProbeInterval is how long a ranking is used before the hosts are measured again, DefaultProbeInterval if zero.
+	ProbeInterval time.Duration
+	// ProbeTimeout limits a probe of a host, DefaultProbeTimeout if zero.
+	ProbeTimeout time.Duration
+	// ProbeCount is how many pings a probe takes, DefaultProbeCount if zero.
+	ProbeCount int
+	// Ping measures a host, PING by default. Tests replace it.
+	Ping func(ctx context.Context, client *redis.Client) error
+}
+
+// NodeStatus is what the last probe found out about a host.
+type NodeStatus struct {
+	Addr     string
+	Latency  time.Duration
+	Healthy  bool
+	Err      error
+	ProbedAt time.Time
+}
+
+// node is a host with its own connection pool.
+type node struct {
+	addr   string
+	client *redis.Client
+	status NodeStatus // Guarded by the mutex of the client
+}
+
+// Client sends commands to the nearest healthy host. It is safe for concurrent use.
+type Client struct {
+	opts  Options
+	nodes []*node
+
+	mu       sync.RWMutex
+	ranking  []*node // Healthy nodes first, by latency
+	probedAt time.Time
+
+	probing atomic.Bool
+	probes  sync.WaitGroup
+}
+
+// New creates a client of the hosts and measures them. It fails only if the options are invalid:
+// if no host answers, the client is created anyway and tries them all.
+func New(ctx context.Context, opts Options) (*Client, error) {
+	if len(opts.Addrs) == 0 {
+		return nil, errors.New("no redis hosts")
+	}
+	if opts.ProbeInterval == 0 {
+		opts.ProbeInterval = DefaultProbeInterval
+	}
+	if opts.ProbeTimeout == 0 {
+		opts.ProbeTimeout = DefaultProbeTimeout
+	}
+	if opts.ProbeCount == 0 {
+		opts.ProbeCount = DefaultProbeCount
+	}
+	if opts.Ping == nil {
+		opts.Ping = func(ctx context.Context, client *redis.Client) error {
+			return client.Ping(ctx).Err()
+		}
+	}
+
+	c := &Client{opts: opts}
+	for _, addr := range opts.Addrs {
+		addr = WithDefaultPort(addr, DefaultPort)
+		c.nodes = append(c.nodes, &node{
+			addr: addr,
+			// Replicas of a cluster without sharding serve reads as they are
+			client: redis.NewClient(&redis.Options{
+				Addr:     addr,
+				Username: opts.Username,
+				Password: opts.Password,
+			}),
+			status: NodeStatus{Addr: addr},
+		})
+	}
+	c.ranking = append([]*node(nil), c.nodes...)
+	c.Probe(ctx)
+	return c, nil
+}
+
+// WithDefaultPort adds the port to the address if it has none.
+func WithDefaultPort(addr, port string) string {
+	addr = strings.TrimSpace(addr)
+	if _, _, err := net.SplitHostPort(addr); err == nil {
+		return addr
+	}
+	return net.JoinHostPort(strings.Trim(addr, "[]"), port)
+}
+
+// Probe measures all hosts concurrently and ranks them by latency.
+func (c *Client) Probe(ctx context.Context) {
+	statuses := make([]NodeStatus, len(c.nodes))
+	var wg sync.WaitGroup
+	for i, n := range c.nodes {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			statuses[i] = c.probe(ctx, n)
+		}()
+	}
+	wg.Wait()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for i, n := range c.nodes {
+		n.status = statuses[i]
+	}
+	ranking := append([]*node(nil), c.nodes...)
+	sort.SliceStable(ranking, func(i, j int) bool {
+		a, b := ranking[i].status, ranking[j].status
+		if a.Healthy != b.Healthy {
+			return a.Healthy
+		}
+		return a.Latency < b.Latency
+	})
+	c.ranking = ranking
+	c.probedAt = time.Now()
+}
+
+// probe measures a host: the fastest of several pings, so the connection setup is not counted.
+func (c *Client) probe(ctx context.Context, n *node) NodeStatus {
+	status := NodeStatus{Addr: n.addr, ProbedAt: time.Now()}
+	ctx, cancel := context.WithTimeout(ctx, c.opts.ProbeTimeout)
+	defer cancel()
+	for range c.opts.ProbeCount {
+		start := time.Now()
+		if err := c.opts.Ping(ctx, n.client); err != nil {
+			status.Err = err
+			status.Healthy = false
+			return status
+		}
+		if latency := time.Since(start); !status.Healthy || latency < status.Latency {
+			status.Latency = latency
+		}
+		status.Healthy = true
+	}
+	return status
+}
+
+// Nodes returns the status of the hosts, the nearest healthy one first.
+func (c *Client) Nodes() []NodeStatus {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	statuses := make([]NodeStatus, len(c.ranking))
+	for i, n := range c.ranking {
+		statuses[i] = n.status
+	}
+	return statuses
+}
+
+// Nearest returns the client of the nearest healthy host, or ErrNoHealthyNode.
+func (c *Client) Nearest() (*redis.Client, error) {
+	c.refreshIfStale()
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if len(c.ranking) == 0 || !c.ranking[0].status.Healthy {
+		return nil, ErrNoHealthyNode
+	}
+	return c.ranking[0].client, nil
+}
+
+// Do runs the command on the nearest host. If the host cannot be reached, it is marked unhealthy and
+// the command runs on the next one, by latency; the hosts found unhealthy by the last probe are tried last.
+// Errors returned by Redis itself, redis.Nil included, are returned as they are.
+func (c *Client) Do(ctx context.Context, command func(ctx context.Context, client *redis.Client) error) error {
+	c.refreshIfStale()
+	c.mu.RLock()
+	ranking := append([]*node(nil), c.ranking...)
+	c.mu.RUnlock()
+
+	var errs []error
+	for _, n := range ranking {
+		err := command(ctx, n.client)
+		// The host is not to blame for a command given up by its caller
+		if err == nil || !IsConnectionError(err) || ctx.Err() != nil {
+			return err
+		}
+		errs = append(errs, fmt.Errorf("%s: %w", n.addr, err))
+		c.markUnhealthy(n, err)
+	}
+	return errors.Join(append([]error{ErrNoHealthyNode}, errs...)...)
+}
+
+// Get returns the value of the key from the nearest host.
+func (c *Client) Get(ctx context.Context, key string) (string, error) {
+	var value string
+	err := c.Do(ctx, func(ctx context.Context, client *redis.Client) error {
+		var err error
+		value, err = client.Get(ctx, key).Result()
+		return err
+	})
+	return value, err
+}
+
+// IsConnectionError reports whether the command failed to reach the host, rather than being answered
+// with an error by it.
+func IsConnectionError(err error) bool {
+	if err == nil || errors.Is(err, redis.Nil) {
+		return false
+	}
+	var redisErr redis.Error
+	return !errors.As(err, &redisErr)
+}
+
+// markUnhealthy moves the node to the end of the ranking until the next probe.
+func (c *Client) markUnhealthy(failed *node, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	failed.status.Healthy = false
+	failed.status.Err = err
+	ranking := make([]*node, 0, len(c.ranking))
+	for _, n := range c.ranking {
+		if n != failed {
+			ranking = append(ranking, n)
+		}
+	}
+	c.ranking = append(ranking, failed)
+}
+
+// refreshIfStale measures the hosts again in the background if the ranking is older than the probe interval,
+// or if the nearest host is unhealthy, at most once per probe timeout then. A single probe runs at a time.
+func (c *Client) refreshIfStale() {
+	c.mu.RLock()
+	age := time.Since(c.probedAt)
+	stale := age > c.opts.ProbeInterval || (!c.ranking[0].status.Healthy && age > c.opts.ProbeTimeout)
+	c.mu.RUnlock()
+	if !stale || !c.probing.CompareAndSwap(false, true) {
+		return
+	}
+	c.probes.Add(1)
+	go func() {
+		defer c.probes.Done()
+		defer c.probing.Store(false)
+		c.Probe(context.Background())
+	}()
+}
+
+// Close waits for a running probe and closes the connections to all hosts.
+func (c *Client) Close() error {
+	c.probes.Wait()
+	var errs []error
+	for _, n := range c.nodes {
+		errs = append(errs, n.client.Close())
+	}
+	return errors.Join(errs...)
+}
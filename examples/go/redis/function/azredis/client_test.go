@@ -0,0 +1,173 @@
+package azredis
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+// delays simulates the distance to the hosts, by their addresses.
+type delays struct {
+	mu    sync.Mutex
+	delay map[string]time.Duration
+}
+
+func (d *delays) set(addr string, delay time.Duration) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.delay[addr] = delay
+}
+
+// ping pings the host after its delay.
+func (d *delays) ping(ctx context.Context, client *redis.Client) error {
+	d.mu.Lock()
+	delay := d.delay[client.Options().Addr]
+	d.mu.Unlock()
+	time.Sleep(delay)
+	return client.Ping(ctx).Err()
+}
+
+// startHosts starts the hosts with the key set on each of them, delayed by their index.
+func startHosts(t *testing.T, n int) ([]*miniredis.Miniredis, []string, *delays) {
+	t.Helper()
+	d := &delays{delay: map[string]time.Duration{}}
+	var hosts []*miniredis.Miniredis
+	var addrs []string
+	for i := range n {
+		host := miniredis.RunT(t)
+		if err := host.Set("key", host.Addr()); err != nil {
+			t.Fatal(err)
+		}
+		d.set(host.Addr(), time.Duration(n-i)*5*time.Millisecond)
+		hosts = append(hosts, host)
+		addrs = append(addrs, host.Addr())
+	}
+	return hosts, addrs, d
+}
+
+func newClient(t *testing.T, opts Options) *Client {
+	t.Helper()
+	client, err := New(context.Background(), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = client.Close() })
+	return client
+}
+
+func TestNearestOfAnyNumberOfHosts(t *testing.T) {
+	for _, n := range []int{1, 2, 5} {
+		_, addrs, d := startHosts(t, n)
+		client := newClient(t, Options{Addrs: addrs, Ping: d.ping})
+
+		// The last host has the smallest delay
+		value, err := client.Get(context.Background(), "key")
+		if err != nil || value != addrs[n-1] {
+			t.Errorf("%d hosts: got %q from the nearest host, %v", n, value, err)
+		}
+		nodes := client.Nodes()
+		for i := 1; i < len(nodes); i++ {
+			if nodes[i].Latency < nodes[i-1].Latency || !nodes[i].Healthy {
+				t.Errorf("%d hosts: the nodes are not ranked by latency: %+v", n, nodes)
+			}
+		}
+	}
+}
+
+func TestFallsBackToNextHost(t *testing.T) {
+	hosts, addrs, d := startHosts(t, 3)
+	client := newClient(t, Options{Addrs: addrs, Ping: d.ping, ProbeInterval: time.Hour})
+
+	hosts[2].Close()
+	value, err := client.Get(context.Background(), "key")
+	if err != nil || value != addrs[1] {
+		t.Fatalf("got %q after the nearest host failed, %v", value, err)
+	}
+	nodes := client.Nodes()
+	if last := nodes[len(nodes)-1]; last.Addr != addrs[2] || last.Healthy {
+		t.Errorf("the failed host is not ranked last: %+v", nodes)
+	}
+
+	// Answers of Redis itself are not failures of the host
+	if _, err = client.Get(context.Background(), "missing"); !errors.Is(err, redis.Nil) {
+		t.Errorf("a missing key returned %v", err)
+	}
+}
+
+func TestReprobesWhenStale(t *testing.T) {
+	_, addrs, d := startHosts(t, 3)
+	client := newClient(t, Options{Addrs: addrs, Ping: d.ping, ProbeInterval: 50 * time.Millisecond})
+
+	// The first host becomes the nearest one
+	d.set(addrs[0], 0)
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		value, err := client.Get(context.Background(), "key")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if value == addrs[0] {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("the ranking is not updated: %+v", client.Nodes())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestNoHostAvailable(t *testing.T) {
+	hosts, addrs, _ := startHosts(t, 2)
+	for _, host := range hosts {
+		host.Close()
+	}
+	client := newClient(t, Options{Addrs: addrs, ProbeTimeout: 100 * time.Millisecond})
+	if _, err := client.Nearest(); !errors.Is(err, ErrNoHealthyNode) {
+		t.Errorf("Nearest returned %v", err)
+	}
+	if _, err := client.Get(context.Background(), "key"); !errors.Is(err, ErrNoHealthyNode) {
+		t.Errorf("Get returned %v", err)
+	}
+}
+
+func TestConcurrentUse(t *testing.T) {
+	hosts, addrs, d := startHosts(t, 3)
+	client := newClient(t, Options{Addrs: addrs, Ping: d.ping, ProbeInterval: time.Millisecond})
+
+	var wg sync.WaitGroup
+	for i := range 20 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range 20 {
+				if _, err := client.Get(context.Background(), "key"); err != nil {
+					t.Error(err)
+					return
+				}
+				if i == 0 {
+					client.Nodes()
+				}
+			}
+		}()
+	}
+	hosts[0].Close()
+	wg.Wait()
+}
+
+func TestWithDefaultPort(t *testing.T) {
+	for addr, want := range map[string]string{
+		"rc1a-host.mdb.yandexcloud.net":        "rc1a-host.mdb.yandexcloud.net:6379",
+		" rc1a-host.mdb.yandexcloud.net:26379": "rc1a-host.mdb.yandexcloud.net:26379",
+		"::1":                                  "[::1]:6379",
+		"[::1]:7000":                           "[::1]:7000",
+	} {
+		if got := WithDefaultPort(addr, DefaultPort); got != want {
+			t.Errorf("WithDefaultPort(%q) = %q, want %q", addr, got, want)
+		}
+	}
+}
@@ -0,0 +1,336 @@
+package azredis
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	// DefaultSentinelPort is the port of the Sentinel hosts given without one.
+	DefaultSentinelPort = "26379"
+	// DefaultMaxRetries is how many times a command is retried after a failover.
+	DefaultMaxRetries = 3
+	// DefaultMinBackoff is the delay before the first retry, doubled for every next one.
+	DefaultMinBackoff = 100 * time.Millisecond
+	// DefaultMaxBackoff limits the delay between the retries.
+	DefaultMaxBackoff = 2 * time.Second
+)
+
+// Topology is where the master and the replicas of the cluster are.
+type Topology struct {
+	Master   string
+	Replicas []string
+}
+
+// SentinelOptions configure the split client.
+type SentinelOptions struct {
+	// Addrs are the Sentinel hosts, with or without a port.
+	Addrs []string
+	// MasterName is the name the Sentinels monitor the master by.
+	MasterName string
+	// Username and Password authenticate to the master and the replicas.
+	Username string
+	Password string
+	// SentinelUsername and SentinelPassword authenticate to the Sentinels, if they require it.
+	SentinelUsername string
+	SentinelPassword string
+
+	// Replicas configure the probing of the replicas. Their Addrs and credentials are set by the client.
+	Replicas Options
+
+	// MaxRetries is how many times a command is retried after the cluster failed it, DefaultMaxRetries if zero.
+	MaxRetries int
+	// MinBackoff and MaxBackoff bound the delay between the retries, DefaultMinBackoff and DefaultMaxBackoff if zero.
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+
+	// Discover finds the master and the replicas, by asking the Sentinels by default. Tests replace it.
+	Discover func(ctx context.Context) (Topology, error)
+}
+
+// SplitClient sends writes to the master and reads to the nearest replica, discovering them through Sentinel.
+// When a command fails because the master has moved or a host is gone, the client discovers the cluster again
+// and retries the command with a backoff. It is safe for concurrent use.
+type SplitClient struct {
+	opts      SentinelOptions
+	sentinels []*redis.SentinelClient
+
+	mu       sync.RWMutex
+	topology Topology
+	master   *redis.Client
+	replicas *Client // nil if the cluster has no replicas
+
+	// discovering lets a single discovery run at a time, generation tells the waiting ones it is done.
+	discovering sync.Mutex
+	generation  uint64
+}
+
+// NewSplit discovers the cluster through the Sentinels and connects to it.
+func NewSplit(ctx context.Context, opts SentinelOptions) (*SplitClient, error) {
+	if opts.MaxRetries == 0 {
+		opts.MaxRetries = DefaultMaxRetries
+	}
+	if opts.MinBackoff == 0 {
+		opts.MinBackoff = DefaultMinBackoff
+	}
+	if opts.MaxBackoff == 0 {
+		opts.MaxBackoff = DefaultMaxBackoff
+	}
+
+	c := &SplitClient{opts: opts}
+	if c.opts.Discover == nil {
+		if opts.MasterName == "" || len(opts.Addrs) == 0 {
+			return nil, errors.New("no sentinel hosts or master name")
+		}
+		for _, addr := range opts.Addrs {
+			c.sentinels = append(c.sentinels, redis.NewSentinelClient(&redis.Options{
+				Addr:     WithDefaultPort(addr, DefaultSentinelPort),
+				Username: opts.SentinelUsername,
+				Password: opts.SentinelPassword,
+			}))
+		}
+		c.opts.Discover = c.askSentinels
+	}
+	if err := c.rediscover(ctx, 0); err != nil {
+		_ = c.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// Topology returns the master and the replicas found by the last discovery.
+func (c *SplitClient) Topology() Topology {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return Topology{Master: c.topology.Master, Replicas: slices.Clone(c.topology.Replicas)}
+}
+
+// Write runs the command on the master.
+func (c *SplitClient) Write(ctx context.Context, command func(ctx context.Context, client *redis.Client) error) error {
+	return c.retry(ctx, func(ctx context.Context) error {
+		c.mu.RLock()
+		master := c.master
+		c.mu.RUnlock()
+		return command(ctx, master)
+	})
+}
+
+// Read runs the command on the nearest replica, falling back to the other replicas and then to the master.
+func (c *SplitClient) Read(ctx context.Context, command func(ctx context.Context, client *redis.Client) error) error {
+	return c.retry(ctx, func(ctx context.Context) error {
+		c.mu.RLock()
+		master, replicas := c.master, c.replicas
+		c.mu.RUnlock()
+		if replicas != nil {
+			err := replicas.Do(ctx, command)
+			if !errors.Is(err, ErrNoHealthyNode) {
+				return err
+			}
+		}
+		return command(ctx, master)
+	})
+}
+
+// Get returns the value of the key from the nearest replica.
+func (c *SplitClient) Get(ctx context.Context, key string) (string, error) {
+	var value string
+	err := c.Read(ctx, func(ctx context.Context, client *redis.Client) error {
+		var err error
+		value, err = client.Get(ctx, key).Result()
+		return err
+	})
+	return value, err
+}
+
+// Set sets the value of the key on the master.
+func (c *SplitClient) Set(ctx context.Context, key string, value any, expiration time.Duration) error {
+	return c.Write(ctx, func(ctx context.Context, client *redis.Client) error {
+		return client.Set(ctx, key, value, expiration).Err()
+	})
+}
+
+// retry runs the attempt until it succeeds, fails with an error a failover does not explain, or the retries
+// are exhausted. Before every retry the cluster is discovered again.
+func (c *SplitClient) retry(ctx context.Context, attempt func(ctx context.Context) error) error {
+	for i := 0; ; i++ {
+		c.mu.RLock()
+		generation := c.generation
+		c.mu.RUnlock()
+
+		err := attempt(ctx)
+		if err == nil || !IsFailoverError(err) || ctx.Err() != nil {
+			return err
+		}
+		if i == c.opts.MaxRetries {
+			return fmt.Errorf("gave up after %d retries: %w", i, err)
+		}
+
+		select {
+		case <-time.After(c.backoff(i)):
+		case <-ctx.Done():
+			return errors.Join(err, ctx.Err())
+		}
+		if discoverErr := c.rediscover(ctx, generation); discoverErr != nil {
+			return errors.Join(err, discoverErr)
+		}
+	}
+}
+
+// backoff returns the delay before the retry: the minimum backoff doubled for every retry before it,
+// up to the maximum one, randomized by half so the instances of the function do not retry in step.
+func (c *SplitClient) backoff(retry int) time.Duration {
+	d := c.opts.MaxBackoff
+	if retry < 30 {
+		d = min(c.opts.MinBackoff<<retry, c.opts.MaxBackoff)
+	}
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+// IsFailoverError reports whether the command failed because the host is gone or is no longer the master,
+// so it may succeed once the cluster is discovered again.
+func IsFailoverError(err error) bool {
+	if IsConnectionError(err) {
+		return true
+	}
+	for _, prefix := range []string{"READONLY", "LOADING", "MASTERDOWN"} {
+		if redis.HasErrorPrefix(err, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// rediscover discovers the cluster and connects to its new hosts, unless another discovery has finished
+// since the generation was seen. The connections to the hosts that are gone are closed.
+func (c *SplitClient) rediscover(ctx context.Context, seen uint64) error {
+	c.discovering.Lock()
+	defer c.discovering.Unlock()
+	c.mu.RLock()
+	done := c.generation != seen
+	current := c.topology
+	c.mu.RUnlock()
+	if done {
+		return nil
+	}
+
+	topology, err := c.opts.Discover(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to discover the cluster: %w", err)
+	}
+
+	master := c.master
+	if topology.Master != current.Master {
+		master = redis.NewClient(&redis.Options{
+			Addr:     topology.Master,
+			Username: c.opts.Username,
+			Password: c.opts.Password,
+			// The client retries itself, against the master it discovers
+			MaxRetries: -1,
+		})
+	}
+	replicas := c.replicas
+	if !slices.Equal(sorted(topology.Replicas), sorted(current.Replicas)) {
+		replicas = nil
+		if len(topology.Replicas) > 0 {
+			opts := c.opts.Replicas
+			opts.Addrs = topology.Replicas
+			opts.Username = c.opts.Username
+			opts.Password = c.opts.Password
+			if replicas, err = New(ctx, opts); err != nil {
+				if master != c.master {
+					_ = master.Close()
+				}
+				return err
+			}
+		}
+	}
+
+	c.mu.Lock()
+	oldMaster, oldReplicas := c.master, c.replicas
+	c.topology, c.master, c.replicas = topology, master, replicas
+	c.generation++
+	c.mu.Unlock()
+
+	// The commands still running on the old hosts fail with redis.ErrClosed and are retried
+	if oldMaster != nil && oldMaster != master {
+		_ = oldMaster.Close()
+	}
+	if oldReplicas != nil && oldReplicas != replicas {
+		_ = oldReplicas.Close()
+	}
+	return nil
+}
+
+// askSentinels asks the Sentinels in turn for the master and its replicas, until one of them answers.
+// The replicas the Sentinel considers down are left out.
+func (c *SplitClient) askSentinels(ctx context.Context) (Topology, error) {
+	var errs []error
+	for _, sentinel := range c.sentinels {
+		master, err := sentinel.GetMasterAddrByName(ctx, c.opts.MasterName).Result()
+		if err == nil && len(master) != 2 {
+			err = fmt.Errorf("unexpected master address %q", master)
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", sentinel, err))
+			continue
+		}
+		replicas, err := sentinel.Replicas(ctx, c.opts.MasterName).Result()
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", sentinel, err))
+			continue
+		}
+
+		topology := Topology{Master: net.JoinHostPort(master[0], master[1])}
+		for _, replica := range replicas {
+			if isDown(replica["flags"]) {
+				continue
+			}
+			topology.Replicas = append(topology.Replicas, net.JoinHostPort(replica["ip"], replica["port"]))
+		}
+		return topology, nil
+	}
+	return Topology{}, errors.Join(errs...)
+}
+
+// isDown reports whether the flags Sentinel reports for a replica mark it as unusable.
+func isDown(flags string) bool {
+	for _, flag := range strings.Split(flags, ",") {
+		switch flag {
+		case "s_down", "o_down", "disconnected":
+			return true
+		}
+	}
+	return false
+}
+
+func sorted(addrs []string) []string {
+	addrs = slices.Clone(addrs)
+	slices.Sort(addrs)
+	return addrs
+}
+
+// Close closes the connections to the cluster and the Sentinels.
+func (c *SplitClient) Close() error {
+	c.discovering.Lock()
+	defer c.discovering.Unlock()
+	var errs []error
+	if c.master != nil {
+		errs = append(errs, c.master.Close())
+	}
+	if c.replicas != nil {
+		errs = append(errs, c.replicas.Close())
+	}
+	for _, sentinel := range c.sentinels {
+		errs = append(errs, sentinel.Close())
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,173 @@
+package azredis
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+const readOnly = "READONLY You can't write against a read only replica."
+
+// cluster is the topology a fake Sentinel reports.
+type cluster struct {
+	mu          sync.Mutex
+	topology    Topology
+	discoveries atomic.Int32
+}
+
+func (c *cluster) set(topology Topology) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.topology = topology
+}
+
+func (c *cluster) discover(context.Context) (Topology, error) {
+	c.discoveries.Add(1)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.topology, nil
+}
+
+// startCluster starts a master and the replicas, the last replica being the nearest one.
+func startCluster(t *testing.T, replicas int) (*miniredis.Miniredis, []*miniredis.Miniredis, *cluster, *SplitClient) {
+	t.Helper()
+	master := miniredis.RunT(t)
+	hosts, addrs, d := startHosts(t, replicas)
+	c := &cluster{topology: Topology{Master: master.Addr(), Replicas: addrs}}
+	client, err := NewSplit(context.Background(), SentinelOptions{
+		Replicas:   Options{Ping: d.ping},
+		MinBackoff: time.Millisecond,
+		MaxBackoff: 5 * time.Millisecond,
+		Discover:   c.discover,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = client.Close() })
+	return master, hosts, c, client
+}
+
+func TestSplitWritesToMasterAndReadsFromNearestReplica(t *testing.T) {
+	master, replicas, _, client := startCluster(t, 3)
+
+	if err := client.Set(context.Background(), "written", "value", 0); err != nil {
+		t.Fatal(err)
+	}
+	if value, err := master.Get("written"); err != nil || value != "value" {
+		t.Errorf("the master has %q, %v", value, err)
+	}
+	for _, replica := range replicas {
+		if replica.Exists("written") {
+			t.Errorf("the write went to replica %s", replica.Addr())
+		}
+	}
+
+	value, err := client.Get(context.Background(), "key")
+	if err != nil || value != replicas[2].Addr() {
+		t.Errorf("got %q from the replicas, %v", value, err)
+	}
+}
+
+func TestSplitRediscoversAfterFailover(t *testing.T) {
+	master, replicas, c, client := startCluster(t, 2)
+
+	// The master is demoted and the first replica is promoted in its place
+	master.SetError(readOnly)
+	c.set(Topology{Master: replicas[0].Addr(), Replicas: []string{replicas[1].Addr(), master.Addr()}})
+
+	if err := client.Set(context.Background(), "written", "value", 0); err != nil {
+		t.Fatal(err)
+	}
+	if value, err := replicas[0].Get("written"); err != nil || value != "value" {
+		t.Errorf("the new master has %q, %v", value, err)
+	}
+	if got := client.Topology().Master; got != replicas[0].Addr() {
+		t.Errorf("the master is %s after the failover", got)
+	}
+}
+
+func TestSplitFallsBackToMaster(t *testing.T) {
+	master, replicas, _, client := startCluster(t, 2)
+	if err := master.Set("key", "master"); err != nil {
+		t.Fatal(err)
+	}
+	for _, replica := range replicas {
+		replica.Close()
+	}
+
+	value, err := client.Get(context.Background(), "key")
+	if err != nil || value != "master" {
+		t.Errorf("got %q without replicas, %v", value, err)
+	}
+}
+
+func TestSplitGivesUp(t *testing.T) {
+	master, _, c, client := startCluster(t, 1)
+	master.SetError(readOnly)
+	discoveries := c.discoveries.Load()
+
+	err := client.Set(context.Background(), "written", "value", 0)
+	if !redis.HasErrorPrefix(err, "READONLY") {
+		t.Errorf("a write to a replica returned %v", err)
+	}
+	if got := c.discoveries.Load() - discoveries; got != DefaultMaxRetries {
+		t.Errorf("the cluster was discovered %d times, want %d", got, DefaultMaxRetries)
+	}
+
+	// Errors failovers do not explain are returned at once
+	master.SetError("")
+	if err := master.Set("written", "value"); err != nil {
+		t.Fatal(err)
+	}
+	discoveries = c.discoveries.Load()
+	err = client.Write(context.Background(), func(ctx context.Context, client *redis.Client) error {
+		return client.Incr(ctx, "written").Err()
+	})
+	if err == nil || IsFailoverError(err) || c.discoveries.Load() != discoveries {
+		t.Errorf("INCR of a string returned %v after %d discoveries", err, c.discoveries.Load()-discoveries)
+	}
+}
+
+func TestSplitDiscoversOncePerFailover(t *testing.T) {
+	master, replicas, c, client := startCluster(t, 2)
+	master.SetError(readOnly)
+	c.set(Topology{Master: replicas[0].Addr(), Replicas: []string{replicas[1].Addr()}})
+	discoveries := c.discoveries.Load()
+
+	var wg sync.WaitGroup
+	for range 10 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := client.Set(context.Background(), "written", "value", 0); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	if got := c.discoveries.Load() - discoveries; got != 1 {
+		t.Errorf("the cluster was discovered %d times", got)
+	}
+}
+
+func TestIsFailoverError(t *testing.T) {
+	for _, tc := range []struct {
+		err  error
+		want bool
+	}{
+		{redis.ErrClosed, true},
+		{errors.New("dial tcp: connection refused"), true},
+		{redis.Nil, false},
+		{nil, false},
+	} {
+		if got := IsFailoverError(tc.err); got != tc.want {
+			t.Errorf("IsFailoverError(%v) = %v", tc.err, got)
+		}
+	}
+}
@@ -6,10 +6,12 @@ import (
 	"fmt"
 	"math/rand"
 	"os"
-	"strings"
+	"sync"
 	"time"
 
 	"github.com/redis/go-redis/v9"
+
+	"redis/azredis"
 )
 
 type Duration struct {
@@ -21,9 +23,10 @@ func (d Duration) MarshalJSON() (b []byte, err error) {
 }
 
 type RespWithDur struct {
-	Resp string
-	Dur  Duration
-	Addr string
+	Resp  string
+	Dur   Duration
+	Addr  string
+	Error string `json:",omitempty"`
 }
 
 type Response struct {
@@ -31,51 +34,38 @@ type Response struct {
 	Total         Duration
 }
 
+// Handler reads a key from every host of the cluster concurrently and reports how long each of them took.
+//
 //goland:noinspection ALL
 func Handler(ctx context.Context) ([]byte, error) {
 	funcStart := time.Now()
-	addrs := strings.Split(os.Getenv("REDIS_ADDRS"), ",")
+	addrs := redisAddrs()
 	password := os.Getenv("REDIS_PASSWORD")
 	randKey := fmt.Sprintf("key%d", rand.Intn(1000))
 
+	// Every goroutine writes only its own element, so the responses need no channel
+	res := make([]RespWithDur, len(addrs))
+	var wg sync.WaitGroup
 	for i, addr := range addrs {
-		addrs[i] = strings.TrimSpace(addr) + ":6379"
-	}
-
-	responseChan := make(chan RespWithDur, 3)
-	doneChan := make(chan struct{})
-
-	cCtx, cancel := context.WithCancel(ctx)
-
-	for _, addr := range addrs {
-		go func(addr string) {
+		addr = azredis.WithDefaultPort(addr, azredis.DefaultPort)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
 			start := time.Now()
-			conn := redis.NewUniversalClient(
-				&redis.UniversalOptions{
-					Addrs:    []string{addr},
-					Username: "default",
-					Password: password,
-					ReadOnly: true,
-				},
-			)
+			conn := redis.NewClient(&redis.Options{
+				Addr:     addr,
+				Username: "default",
+				Password: password,
+			})
 			defer conn.Close()
-			result, err := conn.Get(cCtx, randKey).Result()
-			if err == nil {
-				responseChan <- RespWithDur{Resp: result, Dur: Duration{time.Since(start)}, Addr: addr}
-				doneChan <- struct{}{}
+			result, err := conn.Get(ctx, randKey).Result()
+			res[i] = RespWithDur{Resp: result, Dur: Duration{time.Since(start)}, Addr: addr}
+			if err != nil {
+				res[i].Error = err.Error()
 			}
-		}(addr)
-	}
-
-	<-doneChan
-	cancel()
-	close(responseChan)
-	close(doneChan)
-
-	var res []RespWithDur
-	for resp := range responseChan {
-		res = append(res, resp)
+		}()
 	}
+	wg.Wait()
 
 	response := Response{
 		NodeResponses: res,
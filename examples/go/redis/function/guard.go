@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+
+	"redis/lease"
+	"redis/ratelimit"
+)
+
+// defaultSeedLimit is how many times a minute the keys may be seeded, unless REDIS_SEED_LIMIT says otherwise.
+const defaultSeedLimit = 5
+
+// GuardedHandler seeds the keys as PlainHandler does, but at most REDIS_SEED_LIMIT times a minute across all
+// the instances of the function, and by a single instance at a time.
+//
+//goland:noinspection ALL
+func GuardedHandler(ctx context.Context, req Req) ([]byte, error) {
+	client, err := split()
+	if err != nil {
+		return nil, err
+	}
+	limit := defaultSeedLimit
+	if v := os.Getenv("REDIS_SEED_LIMIT"); v != "" {
+		if limit, err = strconv.Atoi(v); err != nil {
+			return nil, fmt.Errorf("invalid REDIS_SEED_LIMIT %q", v)
+		}
+	}
+
+	// The lock and the limiter keep their state on the master, so they are created on it.
+	// The lease is taken first, so an instance that finds another one seeding does not use up the limit.
+	var held *lease.Lease
+	err = client.Write(ctx, func(ctx context.Context, master *redis.Client) error {
+		held, err = lease.NewLocker(master).Acquire(ctx, "seed", 30*time.Second)
+		return err
+	})
+	if errors.Is(err, lease.ErrNotAcquired) {
+		return []byte("another instance is seeding"), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := held.Release(context.WithoutCancel(ctx)); err != nil {
+			fmt.Printf("failed to release the seed lease: %v\n", err)
+		}
+	}()
+
+	var allowed ratelimit.Result
+	err = client.Write(ctx, func(ctx context.Context, master *redis.Client) error {
+		allowed, err = ratelimit.New(master, limit, time.Minute).Allow(ctx, "seed")
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if !allowed.Allowed {
+		return []byte(fmt.Sprintf("rate limited, retry in %s", allowed.RetryAfter.Round(time.Second))), nil
+	}
+
+	if err := seedData(ctx, client); err != nil {
+		return nil, err
+	}
+	// Only the latest holder of the lease records the seeding, even if an earlier one was slower
+	err = client.Write(ctx, func(ctx context.Context, master *redis.Client) error {
+		return lease.SetFenced(ctx, master, "{seed}:seeded-at", time.Now().Unix(), held.Fence())
+	})
+	if err != nil {
+		return nil, err
+	}
+	return []byte(fmt.Sprintf("seeded with fencing token %d", held.Fence())), nil
+}
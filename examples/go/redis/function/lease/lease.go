@@ -0,0 +1,175 @@
+// Package lease provides a distributed lock in Redis that expires unless its holder renews it.
+//
+// A lease that expires while its holder is paused or cut off from Redis can be acquired by another holder,
+// and the first one may not know it has lost the lock yet. So every acquisition gets a fencing token,
+// a number greater than the tokens of all the leases of the lock before it. The resource the lock protects
+// rejects writes with a token lower than one it has seen, see SetFenced.
+package lease
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+var (
+	// ErrNotAcquired is returned by Acquire when another holder has the lease.
+	ErrNotAcquired = errors.New("lease: held by another holder")
+	// ErrLost is returned when the lease has expired and may have been acquired by another holder.
+	ErrLost = errors.New("lease: lost")
+	// ErrStaleFence is returned by SetFenced when a write with a greater fencing token has happened.
+	ErrStaleFence = errors.New("lease: stale fencing token")
+)
+
+var (
+	// acquire sets KEYS[1] to the owner ARGV[1] for ARGV[2] milliseconds unless it is set, and returns
+	// the next fencing token from the counter KEYS[2], or 0 if the lock is held.
+	acquire = redis.NewScript(`
+if redis.call('SET', KEYS[1], ARGV[1], 'NX', 'PX', ARGV[2]) then
+  return redis.call('INCR', KEYS[2])
+end
+return 0
+`)
+
+	// renew extends KEYS[1] by ARGV[2] milliseconds if the owner ARGV[1] still holds it.
+	renew = redis.NewScript(`
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+  return redis.call('PEXPIRE', KEYS[1], ARGV[2])
+end
+return 0
+`)
+
+	// release deletes KEYS[1] if the owner ARGV[1] still holds it.
+	release = redis.NewScript(`
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+  return redis.call('DEL', KEYS[1])
+end
+return 0
+`)
+
+	// setFenced sets KEYS[1] to ARGV[1] unless a token greater than ARGV[2] has been seen at KEYS[2].
+	setFenced = redis.NewScript(`
+local seen = tonumber(redis.call('GET', KEYS[2]) or '0')
+local fence = tonumber(ARGV[2])
+if fence < seen then
+  return 0
+end
+redis.call('SET', KEYS[2], fence)
+redis.call('SET', KEYS[1], ARGV[1])
+return 1
+`)
+)
+
+// Locker acquires the leases. It holds no state of its own, so it is cheap to create for every invocation
+// and safe for concurrent use.
+type Locker struct {
+	rdb redis.Scripter
+	// Prefix is prepended to the keys of the locks in Redis.
+	Prefix string
+}
+
+// NewLocker creates a locker keeping the locks in Redis under the "lease:" prefix.
+func NewLocker(rdb redis.Scripter) *Locker {
+	return &Locker{rdb: rdb, Prefix: "lease:"}
+}
+
+// Lease is a lock held until it is released or expires.
+type Lease struct {
+	rdb   redis.Scripter
+	key   string
+	owner string
+	fence int64
+}
+
+// Acquire acquires the lock for the TTL, or returns ErrNotAcquired if another holder has it.
+func (l *Locker) Acquire(ctx context.Context, name string, ttl time.Duration) (*Lease, error) {
+	if ttl < time.Millisecond {
+		return nil, fmt.Errorf("lease: TTL %s is shorter than a millisecond", ttl)
+	}
+	owner, err := randomID()
+	if err != nil {
+		return nil, err
+	}
+	// The lock and its counter share a hash slot, so the script can run on a cluster too
+	key := l.Prefix + "{" + name + "}"
+	fence, err := acquire.Run(ctx, l.rdb, []string{key, key + ":fence"}, owner, ttl.Milliseconds()).Int64()
+	if err != nil {
+		return nil, fmt.Errorf("lease: failed to acquire %s: %w", name, err)
+	}
+	if fence == 0 {
+		return nil, ErrNotAcquired
+	}
+	return &Lease{rdb: l.rdb, key: key, owner: owner, fence: fence}, nil
+}
+
+// AcquireWait tries to acquire the lock every interval until it succeeds or the context is done.
+func (l *Locker) AcquireWait(ctx context.Context, name string, ttl, interval time.Duration) (*Lease, error) {
+	for {
+		lease, err := l.Acquire(ctx, name, ttl)
+		if !errors.Is(err, ErrNotAcquired) {
+			return lease, err
+		}
+		select {
+		case <-time.After(interval):
+		case <-ctx.Done():
+			return nil, errors.Join(err, ctx.Err())
+		}
+	}
+}
+
+// Fence returns the fencing token of the lease, to be passed along with the writes it protects.
+func (l *Lease) Fence() int64 {
+	return l.fence
+}
+
+// Renew extends the lease by the TTL from now, or returns ErrLost if it has expired.
+func (l *Lease) Renew(ctx context.Context, ttl time.Duration) error {
+	renewed, err := renew.Run(ctx, l.rdb, []string{l.key}, l.owner, ttl.Milliseconds()).Int64()
+	if err != nil {
+		return fmt.Errorf("lease: failed to renew %s: %w", l.key, err)
+	}
+	if renewed == 0 {
+		return ErrLost
+	}
+	return nil
+}
+
+// Release releases the lease, or returns ErrLost if it has expired before.
+func (l *Lease) Release(ctx context.Context) error {
+	released, err := release.Run(ctx, l.rdb, []string{l.key}, l.owner).Int64()
+	if err != nil {
+		return fmt.Errorf("lease: failed to release %s: %w", l.key, err)
+	}
+	if released == 0 {
+		return ErrLost
+	}
+	return nil
+}
+
+// SetFenced sets the key to the value, unless a write with a greater fencing token has set it before,
+// ErrStaleFence is returned then. The greatest token seen is kept at the key with the ":fence" suffix,
+// so on a sharded cluster the key needs a hash tag, such as "{report}".
+func SetFenced(ctx context.Context, rdb redis.Scripter, key string, value any, fence int64) error {
+	set, err := setFenced.Run(ctx, rdb, []string{key, key + ":fence"}, value, fence).Int64()
+	if err != nil {
+		return fmt.Errorf("lease: failed to set %s: %w", key, err)
+	}
+	if set == 0 {
+		return ErrStaleFence
+	}
+	return nil
+}
+
+// randomID returns a random ID of the holder, so only the holder can renew and release its lease.
+func randomID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
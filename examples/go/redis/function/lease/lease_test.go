@@ -0,0 +1,130 @@
+package lease
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+func start(t *testing.T) (*miniredis.Miniredis, *Locker, *redis.Client) {
+	t.Helper()
+	mr := miniredis.RunT(t)
+	rdb := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { _ = rdb.Close() })
+	return mr, NewLocker(rdb), rdb
+}
+
+func TestExclusive(t *testing.T) {
+	_, locker, _ := start(t)
+	ctx := context.Background()
+
+	first, err := locker.Acquire(ctx, "job", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := locker.Acquire(ctx, "job", time.Minute); !errors.Is(err, ErrNotAcquired) {
+		t.Fatalf("a held lock is acquired again: %v", err)
+	}
+	if _, err := locker.Acquire(ctx, "other", time.Minute); err != nil {
+		t.Fatalf("another lock: %v", err)
+	}
+
+	if err := first.Release(ctx); err != nil {
+		t.Fatal(err)
+	}
+	second, err := locker.Acquire(ctx, "job", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if second.Fence() <= first.Fence() {
+		t.Errorf("fencing token %d after %d", second.Fence(), first.Fence())
+	}
+}
+
+func TestExpiredLeaseIsFencedOff(t *testing.T) {
+	mr, locker, rdb := start(t)
+	ctx := context.Background()
+
+	first, err := locker.Acquire(ctx, "report", 10*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := first.Renew(ctx, 10*time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	// The first holder pauses for longer than its lease, and the second one takes over
+	mr.FastForward(11 * time.Second)
+	second, err := locker.Acquire(ctx, "report", 10*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := SetFenced(ctx, rdb, "report", "second", second.Fence()); err != nil {
+		t.Fatal(err)
+	}
+
+	// The first holder wakes up: its write is rejected, and its lease is gone
+	if err := SetFenced(ctx, rdb, "report", "first", first.Fence()); !errors.Is(err, ErrStaleFence) {
+		t.Errorf("a write of the expired lease returned %v", err)
+	}
+	if value, _ := mr.Get("report"); value != "second" {
+		t.Errorf("the report is %q", value)
+	}
+	if err := first.Renew(ctx, time.Minute); !errors.Is(err, ErrLost) {
+		t.Errorf("Renew of the expired lease returned %v", err)
+	}
+	if err := first.Release(ctx); !errors.Is(err, ErrLost) {
+		t.Errorf("Release of the expired lease returned %v", err)
+	}
+	if err := second.Release(ctx); err != nil {
+		t.Errorf("the expired lease released the new one: %v", err)
+	}
+}
+
+func TestAcquireWait(t *testing.T) {
+	_, locker, _ := start(t)
+	ctx := context.Background()
+
+	var holders, overlaps atomic.Int32
+	var wg sync.WaitGroup
+	for range 5 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			lease, err := locker.AcquireWait(ctx, "seed", time.Minute, 5*time.Millisecond)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if holders.Add(1) > 1 {
+				overlaps.Add(1)
+			}
+			time.Sleep(10 * time.Millisecond)
+			holders.Add(-1)
+			if err := lease.Release(ctx); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	if overlaps.Load() != 0 {
+		t.Errorf("the lease was held by several holders at once %d times", overlaps.Load())
+	}
+
+	held, err := locker.Acquire(ctx, "seed", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer held.Release(ctx)
+	waitCtx, cancel := context.WithTimeout(ctx, 20*time.Millisecond)
+	defer cancel()
+	if _, err := locker.AcquireWait(waitCtx, "seed", time.Minute, 5*time.Millisecond); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("AcquireWait of a held lock returned %v", err)
+	}
+}
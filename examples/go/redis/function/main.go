@@ -5,55 +5,81 @@ import (
 	"fmt"
 	"math/rand"
 	"os"
-	"strings"
+	"sync"
 
 	"github.com/redis/go-redis/v9"
+
+	"redis/azredis"
 )
 
 type Req struct {
 	Cmd string `json:"cmd"`
 }
 
+var (
+	splitMu     sync.Mutex
+	sharedSplit *azredis.SplitClient
+)
+
+// split returns the client of the cluster behind Sentinel, shared by the invocations of the function instance.
+// It writes to the master and reads from the nearest replica, and follows the master when it fails over.
+// Only a client that has found the master is kept, so an invocation after a failure asks the Sentinels again.
+func split() (*azredis.SplitClient, error) {
+	splitMu.Lock()
+	defer splitMu.Unlock()
+	if sharedSplit != nil {
+		return sharedSplit, nil
+	}
+	client, err := azredis.NewSplit(context.Background(), azredis.SentinelOptions{
+		Addrs:      redisAddrs(),
+		MasterName: os.Getenv("REDIS_MASTER"),
+		Username:   "default",
+		Password:   os.Getenv("REDIS_PASSWORD"),
+	})
+	if err != nil {
+		return nil, err
+	}
+	sharedSplit = client
+	return sharedSplit, nil
+}
+
 //goland:noinspection ALL
 func PlainHandler(ctx context.Context, req Req) ([]byte, error) {
-	addrs := strings.Split(os.Getenv("REDIS_ADDRS"), ",")
-	password := os.Getenv("REDIS_PASSWORD")
-	master := os.Getenv("REDIS_MASTER")
-
-	for i, addr := range addrs {
-		addrs[i] = strings.TrimSpace(addr) + ":26379"
+	client, err := split()
+	if err != nil {
+		return nil, err
 	}
 
-	conn := redis.NewUniversalClient(
-		&redis.UniversalOptions{
-			Addrs:      addrs,
-			MasterName: master,
-			Password:   password,
-			ReadOnly:   req.Cmd != "seed",
-		},
-	)
 	if req.Cmd == "seed" {
-		seedData(ctx, conn)
+		if err := seedData(ctx, client); err != nil {
+			return nil, err
+		}
 		return []byte("seeded"), nil
 	}
 
 	randKey := fmt.Sprintf("key%d", rand.Intn(1000))
 
-	result, err := conn.Get(ctx, randKey).Result()
+	result, err := client.Get(ctx, randKey)
 	if err != nil {
-		panic(err)
+		return nil, fmt.Errorf("failed to read %s: %w", randKey, err)
 	}
 	fmt.Println(result)
 
-	_ = conn.Close()
 	return []byte(result), nil
 }
 
-func seedData(ctx context.Context, conn redis.UniversalClient) {
-	for i := 0; i < 1000; i++ {
-		err := conn.Set(ctx, fmt.Sprintf("key%d", i), rand.Int(), 0).Err()
+// seedData writes the keys the handlers read to the master, in a single round trip.
+func seedData(ctx context.Context, client *azredis.SplitClient) error {
+	return client.Write(ctx, func(ctx context.Context, master *redis.Client) error {
+		_, err := master.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+			for i := 0; i < 1000; i++ {
+				pipe.Set(ctx, fmt.Sprintf("key%d", i), rand.Int(), 0)
+			}
+			return nil
+		})
 		if err != nil {
-			panic(err)
+			return fmt.Errorf("failed to seed the keys: %w", err)
 		}
-	}
+		return nil
+	})
 }
@@ -0,0 +1,111 @@
+// Package ratelimit limits how often something happens across all instances of a function, with a sliding
+// window kept in Redis.
+//
+// Every allowed event is a member of a sorted set scored by the time it happened, so the limit holds over
+// any window, not only over fixed intervals. A Lua script checks and records the events atomically and takes
+// the time from the Redis server, so the clocks of the instances do not matter.
+package ratelimit
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// slidingWindow allows ARGV[3] events at KEYS[1] if fewer than ARGV[1] - ARGV[3] happened during the last
+// ARGV[2] microseconds. It returns whether they are allowed, how many more events the window has room for,
+// and, if they are not allowed, how many microseconds remain until it has room for them.
+var slidingWindow = redis.NewScript(`
+local key = KEYS[1]
+local limit = tonumber(ARGV[1])
+local window = tonumber(ARGV[2])
+local n = tonumber(ARGV[3])
+local id = ARGV[4]
+
+local time = redis.call('TIME')
+local now = tonumber(time[1]) * 1000000 + tonumber(time[2])
+
+redis.call('ZREMRANGEBYSCORE', key, '-inf', now - window)
+local count = redis.call('ZCARD', key)
+if count + n > limit then
+  -- The window has room once the events over the limit leave it, the oldest first
+  local oldest = redis.call('ZRANGE', key, count + n - limit - 1, count + n - limit - 1, 'WITHSCORES')
+  return {0, limit - count, tonumber(oldest[2]) + window - now}
+end
+
+for i = 1, n do
+  redis.call('ZADD', key, now, id .. ':' .. i)
+end
+redis.call('PEXPIRE', key, math.ceil(window / 1000))
+return {1, limit - count - n, 0}
+`)
+
+// Result is the decision of the limiter.
+type Result struct {
+	// Allowed reports whether the events may happen.
+	Allowed bool
+	// Remaining is how many more events the window has room for.
+	Remaining int
+	// RetryAfter is how long to wait until the events are allowed, if they are not.
+	RetryAfter time.Duration
+}
+
+// Limiter allows at most Limit events per key during any Window. It holds no state of its own, so it is cheap
+// to create for every invocation and safe for concurrent use.
+type Limiter struct {
+	rdb redis.Scripter
+	// Limit is how many events the window has room for.
+	Limit int
+	// Window is how long the events are counted for.
+	Window time.Duration
+	// Prefix is prepended to the keys in Redis.
+	Prefix string
+}
+
+// New creates a limiter of limit events per window, keeping them in Redis under the "ratelimit:" prefix.
+func New(rdb redis.Scripter, limit int, window time.Duration) *Limiter {
+	return &Limiter{rdb: rdb, Limit: limit, Window: window, Prefix: "ratelimit:"}
+}
+
+// Allow reports whether an event at the key may happen now, and records it if it may.
+func (l *Limiter) Allow(ctx context.Context, key string) (Result, error) {
+	return l.AllowN(ctx, key, 1)
+}
+
+// AllowN reports whether n events at the key may happen now, and records them if they may.
+// Either all of them are allowed or none.
+func (l *Limiter) AllowN(ctx context.Context, key string, n int) (Result, error) {
+	if n < 1 || n > l.Limit {
+		return Result{}, fmt.Errorf("ratelimit: %d events do not fit into the limit of %d", n, l.Limit)
+	}
+	if l.Window < time.Millisecond {
+		return Result{}, fmt.Errorf("ratelimit: window %s is shorter than a millisecond", l.Window)
+	}
+	id, err := randomID()
+	if err != nil {
+		return Result{}, err
+	}
+	values, err := slidingWindow.Run(ctx, l.rdb, []string{l.Prefix + key},
+		l.Limit, l.Window.Microseconds(), n, id).Int64Slice()
+	if err != nil {
+		return Result{}, fmt.Errorf("ratelimit: failed to check %s: %w", key, err)
+	}
+	return Result{
+		Allowed:    values[0] == 1,
+		Remaining:  int(values[1]),
+		RetryAfter: time.Duration(values[2]) * time.Microsecond,
+	}, nil
+}
+
+// randomID returns a random ID telling apart the events recorded at the same microsecond.
+func randomID() (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,113 @@
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+// start starts Redis with its clock stopped, see advance.
+func start(t *testing.T) (*miniredis.Miniredis, *redis.Client, time.Time) {
+	t.Helper()
+	mr := miniredis.RunT(t)
+	now := time.Now()
+	mr.SetTime(now)
+	rdb := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { _ = rdb.Close() })
+	return mr, rdb, now
+}
+
+func allow(t *testing.T, l *Limiter, key string, want bool) Result {
+	t.Helper()
+	result, err := l.Allow(context.Background(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Allowed != want {
+		t.Fatalf("Allow(%s) = %+v, want allowed %v", key, result, want)
+	}
+	return result
+}
+
+func TestSlidingWindow(t *testing.T) {
+	mr, rdb, now := start(t)
+	l := New(rdb, 3, time.Minute)
+
+	for i, at := range []time.Duration{0, 20 * time.Second, 40 * time.Second} {
+		mr.SetTime(now.Add(at))
+		if result := allow(t, l, "user", true); result.Remaining != 2-i {
+			t.Errorf("%d remaining after %d events", result.Remaining, i+1)
+		}
+	}
+
+	// The window is full until the first event leaves it, a minute after it happened
+	mr.SetTime(now.Add(50 * time.Second))
+	result := allow(t, l, "user", false)
+	if result.RetryAfter != 10*time.Second || result.Remaining != 0 {
+		t.Errorf("denied with %+v", result)
+	}
+	allow(t, l, "other", true)
+
+	mr.SetTime(now.Add(time.Minute + time.Second))
+	allow(t, l, "user", true)
+	allow(t, l, "user", false)
+}
+
+func TestAllowN(t *testing.T) {
+	mr, rdb, now := start(t)
+	l := New(rdb, 5, time.Second)
+
+	if result, err := l.AllowN(context.Background(), "batch", 4); err != nil || !result.Allowed {
+		t.Fatalf("4 of 5 events: %+v, %v", result, err)
+	}
+	// Two more do not fit, and none of them is recorded
+	mr.SetTime(now.Add(100 * time.Millisecond))
+	result, err := l.AllowN(context.Background(), "batch", 2)
+	if err != nil || result.Allowed || result.Remaining != 1 || result.RetryAfter != 900*time.Millisecond {
+		t.Fatalf("2 more events: %+v, %v", result, err)
+	}
+	allow(t, l, "batch", true)
+
+	if _, err := l.AllowN(context.Background(), "batch", 6); err == nil {
+		t.Error("more events than the limit are accepted")
+	}
+}
+
+func TestConcurrentInstances(t *testing.T) {
+	_, rdb, _ := start(t)
+
+	var allowed atomic.Int32
+	var wg sync.WaitGroup
+	for range 50 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			// Every invocation creates its own limiter, as the instances of a function do
+			result, err := New(rdb, 10, time.Minute).Allow(context.Background(), "shared")
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if result.Allowed {
+				allowed.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+	if got := allowed.Load(); got != 10 {
+		t.Errorf("%d of 50 concurrent events allowed with the limit of 10", got)
+	}
+}
+
+func TestKeyExpires(t *testing.T) {
+	mr, rdb, _ := start(t)
+	allow(t, New(rdb, 1, time.Minute), "idle", true)
+	if ttl := mr.TTL("ratelimit:idle"); ttl != time.Minute {
+		t.Errorf("the key expires in %s", ttl)
+	}
+}
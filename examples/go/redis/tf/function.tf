@@ -23,6 +23,10 @@ locals {
       name    = "check"
       handler = "index.Handler"
     }
+    "guarded" = {
+      name    = "guarded"
+      handler = "index.GuardedHandler"
+    }
   }
 }
 
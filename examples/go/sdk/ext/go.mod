@@ -1 +1,3 @@
-module ext
\ No newline at end of file
+module ext
+
+go 1.27.1
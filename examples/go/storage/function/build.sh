@@ -7,8 +7,12 @@ if [ ! -d "/build/shared-libs" ]; then
   mkdir -p /build/shared-libs
 fi
 
-# copy all shared libraries to /build/shared-libs
-libs=$(ldd /build/handler.so | awk -F " => " '{split($2, a, " "); print a[1]}')
+# copy the ffmpeg binary used to extract poster frames from videos
+mkdir -p /build/bin
+cp "$(command -v ffmpeg)" /build/bin/ffmpeg
+
+# copy all shared libraries of the function and ffmpeg to /build/shared-libs
+libs=$(ldd /build/handler.so /build/bin/ffmpeg | awk -F " => " '{split($2, a, " "); print a[1]}')
 for l in $libs; do
   f="${l##*/}"
   cp "$l" "/build/shared-libs/$f"
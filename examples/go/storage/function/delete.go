@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// thumbnailRoot is the prefix all rendition prefixes are created under.
+const thumbnailRoot = "thumbnail/"
+
+// deleteRenditions removes every thumbnail derived from a deleted object.
+// Thumbnails of renditions that are no longer configured are removed as well: the function looks into
+// every rendition prefix that exists in the bucket and deletes only objects whose source-key metadata
+// points to the deleted object, so thumbnails of other sources with the same name are kept.
+//
+// Thumbnails made before the renditions were introduced carry no metadata. They are stored right under
+// thumbnailRoot with the name of their source, so the one at that key is deleted by the key alone.
+func (t *thumbnailer) deleteRenditions(ctx context.Context, message ObjectStorageMessage) error {
+	bucket := message.Details.BucketID
+	key := message.Details.ObjectID
+	if err := t.filter.Check(key); err != nil {
+		return err
+	}
+	name := strings.TrimPrefix(key, "uploads/")
+
+	prefixes, err := renditionPrefixes(ctx, t.s3Client, bucket)
+	if err != nil {
+		return err
+	}
+
+	var derived []types.ObjectIdentifier
+	for _, prefix := range prefixes {
+		for _, candidate := range derivedKeys(prefix, name) {
+			head, err := t.headObject(ctx, bucket, candidate)
+			if err != nil {
+				return err
+			}
+			if head != nil && isGenerated(head.Metadata) && head.Metadata[sourceKeyKey] == key {
+				derived = append(derived, types.ObjectIdentifier{Key: aws.String(candidate)})
+			}
+		}
+	}
+	legacy := thumbnailRoot + name
+	head, err := t.headObject(ctx, bucket, legacy)
+	if err != nil {
+		return err
+	}
+	if head != nil && !isGenerated(head.Metadata) {
+		derived = append(derived, types.ObjectIdentifier{Key: aws.String(legacy)})
+	}
+	if len(derived) == 0 {
+		return skip("%s has no thumbnails", key)
+	}
+
+	out, err := t.s3Client.DeleteObjects(ctx, &s3.DeleteObjectsInput{
+		Bucket: aws.String(bucket),
+		Delete: &types.Delete{
+			Objects: derived,
+			Quiet:   aws.Bool(true),
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to delete thumbnails: %w", err)
+	}
+	if len(out.Errors) > 0 {
+		e := out.Errors[0]
+		return fmt.Errorf("failed to delete %d thumbnails, first %s: %s",
+			len(out.Errors), aws.ToString(e.Key), aws.ToString(e.Message))
+	}
+	fmt.Printf("Deleted %d thumbnails of %s\n", len(derived), key)
+	return nil
+}
+
+// headObject returns the metadata of the object, or nil if it does not exist.
+// Any other error is returned, so the event is retried rather than leaving the thumbnails behind.
+func (t *thumbnailer) headObject(ctx context.Context, bucket, key string) (*s3.HeadObjectOutput, error) {
+	head, err := t.s3Client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+	if isNotFound(err) {
+		// Most candidates do not exist, there is nothing to delete.
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to check %s: %w", key, err)
+	}
+	return head, nil
+}
+
+// isNotFound reports whether the request failed because the object does not exist.
+func isNotFound(err error) bool {
+	var notFound *types.NotFound
+	var noSuchKey *types.NoSuchKey
+	var respErr *awshttp.ResponseError
+	return errors.As(err, &notFound) || errors.As(err, &noSuchKey) ||
+		(errors.As(err, &respErr) && respErr.HTTPStatusCode() == http.StatusNotFound)
+}
+
+// renditionPrefixes lists the rendition prefixes, e.g. thumbnail/100x100/, that exist in the bucket.
+func renditionPrefixes(ctx context.Context, s3Client *s3.Client, bucket string) ([]string, error) {
+	var prefixes []string
+	paginator := s3.NewListObjectsV2Paginator(s3Client, &s3.ListObjectsV2Input{
+		Bucket:    aws.String(bucket),
+		Prefix:    aws.String(thumbnailRoot),
+		Delimiter: aws.String("/"),
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list rendition prefixes: %w", err)
+		}
+		for _, p := range page.CommonPrefixes {
+			prefixes = append(prefixes, aws.ToString(p.Prefix))
+		}
+	}
+	return prefixes, nil
+}
+
+// derivedKeys returns the keys a thumbnail of the source with the given name and its sidecar
+// may have under the prefix in any of the output formats.
+func derivedKeys(prefix, name string) []string {
+	thumbnails := []string{Rendition{Format: FormatNative}.keyUnder(prefix, name)}
+	for format := range contentTypes {
+		thumbnails = append(thumbnails, Rendition{Format: format}.keyUnder(prefix, name))
+	}
+	var keys []string
+	for _, key := range thumbnails {
+		keys = append(keys, key, SidecarKey(key))
+	}
+	return keys
+}
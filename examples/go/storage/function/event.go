@@ -7,8 +7,17 @@ type TracingContext struct {
 	TraceID      string `json:"trace_id,omitempty"`       // The ID of the trace.
 }
 
+// Event types of the object storage trigger.
+const (
+	EventTypeObjectCreate = "yandex.cloud.events.storage.ObjectCreate"
+	EventTypeObjectUpdate = "yandex.cloud.events.storage.ObjectUpdate"
+	EventTypeObjectDelete = "yandex.cloud.events.storage.ObjectDelete"
+)
+
 // ObjectStorageMessageMetadata holds the metadata for an object storage event.
 type ObjectStorageMessageMetadata struct {
+	EventID        string         `json:"event_id,omitempty"`   // The ID of the event.
+	EventType      string         `json:"event_type,omitempty"` // The type of the event, one of EventTypeObject*.
 	CreatedAt      string         `json:"created_at,omitempty"` // The creation timestamp of the event.
 	CloudID        string         `json:"cloud_id,omitempty"`   // The ID of the cloud where the event occurred.
 	FolderID       string         `json:"folder_id,omitempty"`  // The ID of the folder where the event occurred.
@@ -23,13 +32,23 @@ type ObjectStorageMessageDetails struct {
 
 // ObjectStorageMessage represents an event in object storage.
 type ObjectStorageMessage struct {
-	Metadata ObjectStorageMessageMetadata `json:"metadata"` // The metadata for the event.
-	Details  ObjectStorageMessageDetails  `json:"details"`  // The details of the event.
+	EventMetadata ObjectStorageMessageMetadata `json:"event_metadata"` // The metadata for the event.
+	Details       ObjectStorageMessageDetails  `json:"details"`        // The details of the event.
+}
+
+// ObjectFailure describes an object that could not be processed.
+type ObjectFailure struct {
+	BucketID string `json:"bucket_id"` // The ID of the bucket of the failed object.
+	ObjectID string `json:"object_id"` // The ID of the failed object.
+	Error    string `json:"error"`     // The reason of the failure.
 }
 
 // ObjectStorageResponse represents the response from an object storage operation.
 type ObjectStorageResponse struct {
-	StatusCode int // The status code of the response.
+	StatusCode int             `json:"status_code"`        // The status code of the response.
+	Processed  int             `json:"processed"`          // The number of successfully processed objects.
+	Skipped    int             `json:"skipped"`            // The number of objects that did not need processing.
+	Failures   []ObjectFailure `json:"failures,omitempty"` // The objects that could not be processed.
 }
 
 // ObjectStorageEvent represents an event in object storage.
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"mime"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+
+	"github.com/davidbyttow/govips/v2/vips"
+)
+
+const (
+	// pdfDensity is the resolution in DPI the first page of a PDF is rendered at.
+	pdfDensity = 150
+	// posterOffset is the position of the poster frame in a video, so it is not a black first frame.
+	posterOffset = "1"
+)
+
+// Generator turns a source file into an image the thumbnails are made from.
+// Generators are chosen by the content type of the source object.
+type Generator interface {
+	// Name is the name of the generator used in logs.
+	Name() string
+	// Accepts reports whether the generator handles sources of the content type.
+	Accepts(contentType string) bool
+	// Native reports whether the source is an image itself, so its thumbnails can keep its format.
+	Native() bool
+	// Preview renders the source file into an image file and returns its name.
+	// Native generators return the source itself, otherwise the caller removes the file.
+	Preview(ctx context.Context, source string) (string, error)
+}
+
+// defaultGenerators are tried in order for every object, the first one accepting the content type is used.
+func defaultGenerators() []Generator {
+	return []Generator{
+		imageGenerator{},
+		pdfGenerator{},
+		videoGenerator{ffmpeg: ffmpegPath()},
+	}
+}
+
+// generatorFor returns the generator for the content type, or nil if no generator handles it.
+func generatorFor(generators []Generator, contentType string) Generator {
+	for _, g := range generators {
+		if g.Accepts(contentType) {
+			return g
+		}
+	}
+	return nil
+}
+
+// sourceContentType returns the content type of the source object without parameters.
+// Objects uploaded without a content type get one guessed from the extension of the key.
+func sourceContentType(stored *string, key string) string {
+	contentType := ""
+	if stored != nil {
+		contentType = *stored
+	}
+	if contentType == "" || contentType == "application/octet-stream" || contentType == "binary/octet-stream" {
+		contentType = mime.TypeByExtension(strings.ToLower(path.Ext(key)))
+	}
+	if mediaType, _, err := mime.ParseMediaType(contentType); err == nil {
+		return mediaType
+	}
+	return contentType
+}
+
+// imageGenerator passes images to vips as they are.
+type imageGenerator struct{}
+
+func (imageGenerator) Name() string { return "image" }
+
+func (imageGenerator) Accepts(contentType string) bool {
+	return strings.HasPrefix(contentType, "image/")
+}
+
+func (imageGenerator) Native() bool { return true }
+
+func (imageGenerator) Preview(_ context.Context, source string) (string, error) {
+	return source, nil
+}
+
+// pdfGenerator renders the first page of a PDF with the poppler loader of vips.
+type pdfGenerator struct{}
+
+func (pdfGenerator) Name() string { return "pdf" }
+
+func (pdfGenerator) Accepts(contentType string) bool {
+	return contentType == "application/pdf"
+}
+
+func (pdfGenerator) Native() bool { return false }
+
+func (pdfGenerator) Preview(_ context.Context, source string) (string, error) {
+	params := vips.NewImportParams()
+	params.Page.Set(0)
+	params.Density.Set(pdfDensity)
+	page, err := vips.LoadImageFromFile(source, params)
+	if err != nil {
+		return "", fmt.Errorf("failed to render the first page: %w", err)
+	}
+	defer page.Close()
+	data, _, err := page.ExportPng(vips.NewPngExportParams())
+	if err != nil {
+		return "", fmt.Errorf("failed to encode the first page: %w", err)
+	}
+	return writeTemp("page-*.png", data)
+}
+
+// videoGenerator extracts a poster frame from a video with the ffmpeg binary.
+type videoGenerator struct {
+	ffmpeg string // The path to the ffmpeg binary.
+}
+
+func (videoGenerator) Name() string { return "video" }
+
+func (videoGenerator) Accepts(contentType string) bool {
+	return strings.HasPrefix(contentType, "video/")
+}
+
+func (videoGenerator) Native() bool { return false }
+
+// Preview takes the frame at posterOffset seconds. Videos shorter than that have no frame there,
+// so the first frame is taken instead.
+func (g videoGenerator) Preview(ctx context.Context, source string) (string, error) {
+	poster, err := g.frame(ctx, source, posterOffset)
+	if errors.Is(err, errNoFrame) {
+		poster, err = g.frame(ctx, source, "0")
+	}
+	return poster, err
+}
+
+var errNoFrame = errors.New("no frame at the offset")
+
+func (g videoGenerator) frame(ctx context.Context, source, offset string) (string, error) {
+	file, err := os.CreateTemp("", "poster-*.png")
+	if err != nil {
+		return "", fmt.Errorf("failed to create temporary file: %w", err)
+	}
+	file.Close()
+	poster := file.Name()
+
+	cmd := exec.CommandContext(ctx, g.ffmpeg,
+		"-hide_banner", "-loglevel", "error", "-nostdin",
+		"-ss", offset, "-i", source,
+		"-frames:v", "1", "-update", "1", "-y", poster,
+	)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		os.Remove(poster)
+		return "", fmt.Errorf("ffmpeg failed: %w: %s", err, strings.TrimSpace(string(out)))
+	}
+	// ffmpeg succeeds without writing a frame if the offset is past the end of the video.
+	if stat, err := os.Stat(poster); err != nil || stat.Size() == 0 {
+		os.Remove(poster)
+		return "", errNoFrame
+	}
+	return poster, nil
+}
+
+// ffmpegPath returns the ffmpeg binary from FFMPEG_PATH, or the one found in PATH.
+func ffmpegPath() string {
+	if p := os.Getenv("FFMPEG_PATH"); p != "" {
+		return p
+	}
+	return "ffmpeg"
+}
+
+// writeTemp writes the data to a new temporary file and returns its name.
+func writeTemp(pattern string, data []byte) (string, error) {
+	file, err := os.CreateTemp("", pattern)
+	if err != nil {
+		return "", fmt.Errorf("failed to create temporary file: %w", err)
+	}
+	_, err = file.Write(data)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(file.Name())
+		return "", fmt.Errorf("failed to write temporary file: %w", err)
+	}
+	return file.Name(), nil
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+const (
+	// generatedByKey is the metadata key that marks objects written by this function.
+	generatedByKey = "generated-by"
+	// generatedByValue is the value of the generatedByKey marker.
+	generatedByValue = "sls-storage-handler"
+	// sourceETagKey is the metadata key that stores the ETag of the source a thumbnail was generated from.
+	sourceETagKey = "source-etag"
+	// sourceKeyKey is the metadata key that stores the key of the source a thumbnail was generated from.
+	sourceKeyKey = "source-key"
+)
+
+// errSkipped is returned for objects that intentionally were not processed.
+// It is not a failure and is not reported as such.
+var errSkipped = errors.New("skipped")
+
+// skip returns an errSkipped error with the reason the object was skipped.
+func skip(format string, args ...any) error {
+	return fmt.Errorf("%w: %s", errSkipped, fmt.Sprintf(format, args...))
+}
+
+// Filter selects the objects the function generates thumbnails for.
+// An object is accepted if its key matches one of the include lists (an empty list matches anything)
+// and none of the exclude lists.
+type Filter struct {
+	IncludePrefixes []string
+	ExcludePrefixes []string
+	IncludeSuffixes []string
+	ExcludeSuffixes []string
+}
+
+// FilterFromEnv creates a filter from the THUMBNAIL_{INCLUDE,EXCLUDE}_{PREFIXES,SUFFIXES} environment variables.
+// By default, only objects under uploads/ are accepted and everything under thumbnail/ is rejected,
+// so the function never processes its own output even if the trigger has no prefix.
+func FilterFromEnv() Filter {
+	return Filter{
+		IncludePrefixes: listFromEnv("THUMBNAIL_INCLUDE_PREFIXES", "uploads/"),
+		ExcludePrefixes: listFromEnv("THUMBNAIL_EXCLUDE_PREFIXES", "thumbnail/"),
+		IncludeSuffixes: listFromEnv("THUMBNAIL_INCLUDE_SUFFIXES", ""),
+		ExcludeSuffixes: listFromEnv("THUMBNAIL_EXCLUDE_SUFFIXES", ""),
+	}
+}
+
+// Check returns an errSkipped error if the object key is not accepted by the filter.
+func (f Filter) Check(key string) error {
+	if len(f.IncludePrefixes) > 0 && !hasAny(key, f.IncludePrefixes, strings.HasPrefix) {
+		return skip("%s does not match included prefixes", key)
+	}
+	if hasAny(key, f.ExcludePrefixes, strings.HasPrefix) {
+		return skip("%s matches excluded prefixes", key)
+	}
+	if len(f.IncludeSuffixes) > 0 && !hasAny(key, f.IncludeSuffixes, hasSuffixFold) {
+		return skip("%s does not match included suffixes", key)
+	}
+	if hasAny(key, f.ExcludeSuffixes, hasSuffixFold) {
+		return skip("%s matches excluded suffixes", key)
+	}
+	return nil
+}
+
+// isGenerated reports whether the object metadata carries the marker of this function.
+func isGenerated(metadata map[string]string) bool {
+	return metadata[generatedByKey] == generatedByValue
+}
+
+// generatedMetadata returns the metadata stored with a thumbnail generated from the source with the given key and ETag.
+func generatedMetadata(sourceKey, sourceETag string) map[string]string {
+	return map[string]string{
+		generatedByKey: generatedByValue,
+		sourceKeyKey:   sourceKey,
+		sourceETagKey:  sourceETag,
+	}
+}
+
+func hasAny(s string, patterns []string, match func(s, pattern string) bool) bool {
+	for _, pattern := range patterns {
+		if match(s, pattern) {
+			return true
+		}
+	}
+	return false
+}
+
+// hasSuffixFold is strings.HasSuffix ignoring case, so ".jpg" matches "photo.JPG".
+func hasSuffixFold(s, suffix string) bool {
+	return strings.HasSuffix(strings.ToLower(s), strings.ToLower(suffix))
+}
+
+// listFromEnv splits a comma-separated environment variable, using def if the variable is not set.
+// Setting the variable to an empty string disables the default.
+func listFromEnv(name, def string) []string {
+	value, ok := os.LookupEnv(name)
+	if !ok {
+		value = def
+	}
+	var list []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
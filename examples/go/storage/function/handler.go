@@ -3,112 +3,414 @@ package main
 import (
 	"bytes"
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
-	"log"
 	"net/url"
+	"os"
+	"strconv"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
 	smithyendpoints "github.com/aws/smithy-go/endpoints"
 )
 
+const (
+	// defaultConcurrency is the number of objects processed at the same time
+	// unless THUMBNAIL_CONCURRENCY says otherwise.
+	defaultConcurrency = 4
+	// deadlineMargin is the time reserved before the function deadline to report the results.
+	deadlineMargin = 500 * time.Millisecond
+	// defaultMaxSourceBytes is the largest object the function downloads
+	// unless THUMBNAIL_MAX_SOURCE_BYTES says otherwise.
+	defaultMaxSourceBytes = 50 << 20
+	// uploadPartSize is the part size of multipart uploads, thumbnails smaller than that are uploaded at once.
+	uploadPartSize = manager.MinUploadPartSize
+	// defaultEndpoint is the object storage used unless S3_ENDPOINT says otherwise.
+	defaultEndpoint = "https://storage.yandexcloud.net"
+)
+
+// thumbnailer holds the clients and settings shared by all objects of a batch.
+type thumbnailer struct {
+	s3Client       *s3.Client
+	uploader       *manager.Uploader
+	filter         Filter
+	generators     []Generator
+	maxSourceBytes int64
+}
+
 // Handler handles an object storage event.
-// It creates a new S3 client, retrieves the object involved in the event, and returns a response.
+// It creates a new S3 client and creates thumbnails for all objects in the event using a pool of workers.
+// For deleted objects, it removes their thumbnails instead.
+// A failure of one object does not affect the others: failed objects are reported in the response.
 func Handler(ctx context.Context, event *ObjectStorageEvent) (*ObjectStorageResponse, error) {
-	// Load the AWS configuration with the custom endpoint resolver.
-	cfg, err := config.LoadDefaultConfig(ctx,
-		config.WithDefaultRegion("ru-central1"),
-	)
+	s3Client, err := newS3Client(ctx)
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
+	}
+	t := &thumbnailer{
+		s3Client: s3Client,
+		// The uploader sends small thumbnails with a single request and switches to multipart upload for large ones.
+		uploader: manager.NewUploader(s3Client, func(u *manager.Uploader) {
+			u.PartSize = uploadPartSize
+			u.Concurrency = 2
+		}),
+		filter:         FilterFromEnv(),
+		generators:     defaultGenerators(),
+		maxSourceBytes: int64FromEnv("THUMBNAIL_MAX_SOURCE_BYTES", defaultMaxSourceBytes),
 	}
 
-	// Create a new S3 client.
-	s3Client := s3.NewFromConfig(cfg, func(o *s3.Options) {
-		o.Region = "ru-central1"
-		o.EndpointResolverV2 = &resolverV2{}
-	})
-	// Initialize a WaitGroup to manage the goroutine.
-	wg := sync.WaitGroup{}
-	// Add a task to the WaitGroup.
-	wg.Add(len(event.Messages))
+	// Stop taking new objects a bit before the function deadline, so there is time to report the results.
+	if deadline, ok := ctx.Deadline(); ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithDeadline(ctx, deadline.Add(-deadlineMargin))
+		defer cancel()
+	}
 
+	// Renditions are configured per bucket, so load them once per bucket in the batch.
+	renditions := map[string][]Rendition{}
+	renditionErrs := map[string]error{}
 	for _, message := range event.Messages {
-		// Get the object involved in the event.
-		object, err := s3Client.GetObject(ctx, &s3.GetObjectInput{
-			Bucket: aws.String(message.Details.BucketID),
-			Key:    aws.String(message.Details.ObjectID),
-		})
+		bucket := message.Details.BucketID
+		if _, ok := renditions[bucket]; ok {
+			continue
+		}
+		if _, ok := renditionErrs[bucket]; ok {
+			continue
+		}
+		r, err := LoadRenditions(ctx, s3Client, bucket)
 		if err != nil {
-			return nil, fmt.Errorf("failed to get object: %w", err)
+			renditionErrs[bucket] = err
+			continue
 		}
+		renditions[bucket] = r
+	}
 
-		// Print the size of the object to stdout.
-		fmt.Printf("Object size: %d", *object.ContentLength)
-		thumbnailKey := "thumbnail/" + strings.TrimPrefix(message.Details.ObjectID, "uploads/")
-
-		pipeReader, pipeWriter := io.Pipe()
-
-		// Start a new goroutine to handle the object storage operation.
+	// Every worker writes only the results of the messages it has taken from the channel.
+	results := make([]error, len(event.Messages))
+	jobs := make(chan int)
+	wg := sync.WaitGroup{}
+	for range min(concurrency(), len(event.Messages)) {
+		wg.Add(1)
 		go func() {
-			body := bytes.NewBuffer(nil)
-			i, err := io.Copy(body, pipeReader)
-			if err != nil {
-				panic("failed to copy data from pipeReader: " + err.Error())
+			defer wg.Done()
+			for i := range jobs {
+				message := event.Messages[i]
+				if message.EventMetadata.EventType == EventTypeObjectDelete {
+					results[i] = t.deleteRenditions(ctx, message)
+					continue
+				}
+				if err, ok := renditionErrs[message.Details.BucketID]; ok {
+					results[i] = err
+					continue
+				}
+				results[i] = t.processObject(ctx, message, renditions[message.Details.BucketID])
 			}
-			fmt.Printf("Copied %d bytes to body\n", i)
-			// Attempt to put the object into the bucket.
-			_, err = s3Client.PutObject(ctx, &s3.PutObjectInput{
-				// The name of the bucket to put the object into.
-				Bucket: aws.String(message.Details.BucketID),
+		}()
+	}
 
-				// The key to store the object under, prefixed with "thumbnail-".
-				Key: aws.String(thumbnailKey),
+dispatch:
+	for i := range event.Messages {
+		select {
+		case jobs <- i:
+		case <-ctx.Done():
+			// The deadline is near: mark the rest of the batch as not processed.
+			for j := i; j < len(event.Messages); j++ {
+				results[j] = fmt.Errorf("not processed: %w", ctx.Err())
+			}
+			break dispatch
+		}
+	}
+	close(jobs)
+	// Wait for all workers to finish.
+	wg.Wait()
 
-				// The data to store in the object.
-				Body: body,
+	return newResponse(event.Messages, results), nil
+}
 
-				// The MIME type of the object.
-				ContentType: object.ContentType,
-			})
+// processObject creates all renditions of a single object and uploads them to the bucket.
+// Objects rejected by the filter, objects generated by this function and objects whose thumbnails
+// were already generated from the same ETag are skipped, so redelivered events and thumbnails written
+// into the triggering bucket do not cause extra work or recursion.
+// The source is streamed to a temporary file instead of memory, and vips decodes it with shrink-on-load,
+// so large uploads do not need the memory for the full-size image.
+// The source is turned into an image by the generator for its content type: images are used as they are,
+// PDFs and videos are rendered into a preview first. Objects of other types are skipped.
+// A panic in the image library is turned into an error, so it fails only this object.
+func (t *thumbnailer) processObject(ctx context.Context, message ObjectStorageMessage, renditions []Rendition) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic while processing object: %v", r)
+		}
+	}()
 
-			// If an error occurred while putting the object, panic.
-			if err != nil {
-				panic(fmt.Errorf("failed to upload object: %w", err))
-			}
+	bucket := message.Details.BucketID
+	key := message.Details.ObjectID
+	if err = t.filter.Check(key); err != nil {
+		return err
+	}
 
-			// Signal to the WaitGroup that the task is done.
-			wg.Done()
-		}()
+	// Check the object metadata before downloading it.
+	head, err := t.s3Client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to head object: %w", err)
+	}
+	if isGenerated(head.Metadata) {
+		return skip("%s was generated by this function", key)
+	}
+	if size := aws.ToInt64(head.ContentLength); size > t.maxSourceBytes {
+		return fmt.Errorf("object is too large: %d bytes, the limit is %d bytes", size, t.maxSourceBytes)
+	}
+	contentType := sourceContentType(head.ContentType, key)
+	generator := generatorFor(t.generators, contentType)
+	if generator == nil {
+		return skip("%s has unsupported content type %q", key, contentType)
+	}
+	if !generator.Native() {
+		renditions = previewRenditions(renditions)
+	}
+	name := strings.TrimPrefix(key, "uploads/")
+	etag := aws.ToString(head.ETag)
+	renditions = pendingRenditions(ctx, t.s3Client, bucket, name, etag, renditions)
+	if len(renditions) == 0 {
+		return skip("thumbnails of %s are up to date with ETag %s", key, etag)
+	}
+
+	// Get the object involved in the event.
+	// If it has been replaced since the check, the request fails and the event for the new version will do the work.
+	object, err := t.s3Client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket:  aws.String(bucket),
+		Key:     aws.String(key),
+		IfMatch: head.ETag,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to get object: %w", err)
+	}
+
+	// Print the size of the object to stdout.
+	fmt.Printf("Object %s size: %d\n", key, aws.ToInt64(object.ContentLength))
+	// Every rendition is generated from the same source, so download it only once.
+	source, err := download(object.Body, t.maxSourceBytes)
+	object.Body.Close()
+	if err != nil {
+		return err
+	}
+	defer os.Remove(source)
+
+	preview, err := generator.Preview(ctx, source)
+	if err != nil {
+		return fmt.Errorf("failed to generate %s preview: %w", generator.Name(), err)
+	}
+	if preview != source {
+		defer os.Remove(preview)
+	}
 
+	info, err := probeSource(preview)
+	if err != nil {
+		return fmt.Errorf("failed to read image header: %w", err)
+	}
+	info.Key = key
+	info.ContentType = contentType
+	if !generator.Native() {
+		info.Format = generator.Name()
+	}
+
+	for _, rendition := range renditions {
 		// Create a thumbnail of the object.
-		defer object.Body.Close()
-		err = Thumbnail(object.Body, pipeWriter)
+		output, err := Thumbnail(preview, rendition)
 		if err != nil {
-			return nil, err
+			return fmt.Errorf("failed to create %s thumbnail: %w", rendition.Prefix(), err)
+		}
+		thumbnailKey := rendition.Key(name)
+		thumbnailType := rendition.ContentType(object.ContentType)
+
+		// Attempt to put the object into the bucket.
+		_, err = t.uploader.Upload(ctx, &s3.PutObjectInput{
+			// The name of the bucket to put the object into.
+			Bucket: aws.String(bucket),
+
+			// The key to store the object under, prefixed with the rendition prefix.
+			Key: aws.String(thumbnailKey),
+
+			// The data to store in the object.
+			Body: bytes.NewReader(output.Data),
+
+			// The MIME type of the object.
+			ContentType: thumbnailType,
+
+			// The marker of generated objects and the ETag of the source for the idempotency check.
+			Metadata: generatedMetadata(key, etag),
+		})
+		if err != nil {
+			return fmt.Errorf("failed to upload object: %w", err)
+		}
+
+		// Put the sidecar with the dimensions and the placeholder next to the thumbnail.
+		sidecar := output.Sidecar
+		sidecar.ContentType = aws.ToString(thumbnailType)
+		sidecar.Source = info
+		body, err := json.Marshal(sidecar)
+		if err != nil {
+			return fmt.Errorf("failed to marshal sidecar: %w", err)
+		}
+		_, err = t.uploader.Upload(ctx, &s3.PutObjectInput{
+			Bucket:      aws.String(bucket),
+			Key:         aws.String(SidecarKey(thumbnailKey)),
+			Body:        bytes.NewReader(body),
+			ContentType: aws.String("application/json"),
+			Metadata:    generatedMetadata(key, etag),
+		})
+		if err != nil {
+			return fmt.Errorf("failed to upload sidecar: %w", err)
 		}
 	}
-	// Wait for all goroutins to finish.
-	wg.Wait()
+	return nil
+}
 
-	// Return a successful response.
-	return &ObjectStorageResponse{
-		StatusCode: 200,
-	}, nil
+// download streams the body to a temporary file and returns its name.
+// It fails if the body is larger than limit, whatever the object metadata said.
+func download(body io.Reader, limit int64) (string, error) {
+	file, err := os.CreateTemp("", "source-*")
+	if err != nil {
+		return "", fmt.Errorf("failed to create temporary file: %w", err)
+	}
+	defer file.Close()
+
+	n, err := io.Copy(file, io.LimitReader(body, limit+1))
+	if err == nil && n > limit {
+		err = fmt.Errorf("object is larger than the limit of %d bytes", limit)
+	}
+	if err != nil {
+		os.Remove(file.Name())
+		return "", fmt.Errorf("failed to download object: %w", err)
+	}
+	return file.Name(), nil
+}
+
+// pendingRenditions returns the renditions that have no thumbnail generated from the source with the given ETag.
+// If the existing thumbnail cannot be checked, the rendition is regenerated.
+func pendingRenditions(
+	ctx context.Context,
+	s3Client *s3.Client,
+	bucket, name, etag string,
+	renditions []Rendition,
+) []Rendition {
+	var pending []Rendition
+	for _, rendition := range renditions {
+		thumbnail, err := s3Client.HeadObject(ctx, &s3.HeadObjectInput{
+			Bucket: aws.String(bucket),
+			Key:    aws.String(rendition.Key(name)),
+		})
+		if err == nil && etag != "" && thumbnail.Metadata[sourceETagKey] == etag {
+			continue
+		}
+		pending = append(pending, rendition)
+	}
+	return pending
+}
+
+// previewRenditions returns the renditions to make from a rendered preview.
+// A preview has no source format to keep, so native renditions are encoded as JPEG.
+func previewRenditions(renditions []Rendition) []Rendition {
+	out := make([]Rendition, len(renditions))
+	for i, r := range renditions {
+		if r.Format == FormatNative {
+			r.Format = FormatJPEG
+		}
+		out[i] = r
+	}
+	return out
+}
+
+// newResponse builds the response for the batch from the per-message results.
+// Skipped objects are not failures.
+// The status code is 200 if all objects succeeded, 207 on partial failure and 500 if every object failed.
+func newResponse(messages []ObjectStorageMessage, results []error) *ObjectStorageResponse {
+	resp := &ObjectStorageResponse{}
+	for i, err := range results {
+		if err == nil {
+			resp.Processed++
+			continue
+		}
+		if errors.Is(err, errSkipped) {
+			fmt.Printf("Skipped object %s: %v\n", messages[i].Details.ObjectID, err)
+			resp.Skipped++
+			continue
+		}
+		fmt.Printf("Failed to process object %s: %v\n", messages[i].Details.ObjectID, err)
+		resp.Failures = append(resp.Failures, ObjectFailure{
+			BucketID: messages[i].Details.BucketID,
+			ObjectID: messages[i].Details.ObjectID,
+			Error:    err.Error(),
+		})
+	}
+	switch {
+	case len(resp.Failures) == 0:
+		resp.StatusCode = 200
+	case resp.Processed+resp.Skipped > 0:
+		resp.StatusCode = 207
+	default:
+		resp.StatusCode = 500
+	}
+	return resp
+}
+
+// concurrency returns the maximum number of objects processed at the same time.
+func concurrency() int {
+	return int(int64FromEnv("THUMBNAIL_CONCURRENCY", defaultConcurrency))
+}
+
+// int64FromEnv returns the positive integer from the environment variable or def if it is not set or invalid.
+func int64FromEnv(name string, def int64) int64 {
+	if n, err := strconv.ParseInt(os.Getenv(name), 10, 64); err == nil && n > 0 {
+		return n
+	}
+	return def
+}
+
+// newS3Client creates an S3 client for the object storage with the custom endpoint resolver.
+func newS3Client(ctx context.Context) (*s3.Client, error) {
+	// Load the AWS configuration with the custom endpoint resolver.
+	cfg, err := config.LoadDefaultConfig(ctx,
+		config.WithDefaultRegion("ru-central1"),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load config: %w", err)
+	}
+
+	// Create a new S3 client.
+	return s3.NewFromConfig(cfg, func(o *s3.Options) {
+		o.Region = "ru-central1"
+		o.EndpointResolverV2 = &resolverV2{endpoint: endpoint()}
+	}), nil
 }
 
 type resolverV2 struct {
-	// you could inject additional application context here as well
+	endpoint string // The URL of the object storage, the bucket is appended to its path.
+}
+
+// endpoint returns the object storage URL from S3_ENDPOINT, Yandex Object Storage by default.
+// Tests point it to a local server.
+func endpoint() string {
+	if e := os.Getenv("S3_ENDPOINT"); e != "" {
+		return e
+	}
+	return defaultEndpoint
 }
 
-func (*resolverV2) ResolveEndpoint(ctx context.Context, params s3.EndpointParameters) (
+func (r *resolverV2) ResolveEndpoint(ctx context.Context, params s3.EndpointParameters) (
 	smithyendpoints.Endpoint, error,
 ) {
-	u, err := url.Parse("https://storage.yandexcloud.net")
+	u, err := url.Parse(r.endpoint)
 	if err != nil {
 		return smithyendpoints.Endpoint{}, err
 	}
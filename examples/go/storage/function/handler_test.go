@@ -0,0 +1,190 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+
+	"sls-storage-handler/s3local"
+)
+
+const testBucket = "uploads-bucket"
+
+// startLocalStorage starts the local S3 server and points the handler to it.
+func startLocalStorage(t *testing.T) *s3local.Server {
+	t.Helper()
+	srv := s3local.NewServer(testBucket)
+	srv.SecretKeys = map[string]string{"local": "local"}
+	t.Cleanup(srv.Close)
+	t.Setenv("S3_ENDPOINT", srv.URL())
+	t.Setenv("AWS_ACCESS_KEY_ID", "local")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "local")
+	t.Setenv("THUMBNAIL_CONFIG_KEY", "")
+	t.Setenv("THUMBNAIL_RENDITIONS", "100x100:centre,64x48:attention:webp:80")
+	return srv
+}
+
+// upload stores the file from testdata under the key with a plain PUT, as a client would.
+func upload(t *testing.T, srv *s3local.Server, key, file, contentType string) {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join("testdata", file))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, err := http.NewRequest(http.MethodPut, srv.URL()+"/"+testBucket+"/"+key, bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", contentType)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("upload of %s failed with status %d", key, resp.StatusCode)
+	}
+}
+
+// trigger delivers the recorded events to the handler the way the trigger of the example does.
+// It returns nil if no event matches the trigger.
+func trigger(t *testing.T, srv *s3local.Server) *ObjectStorageResponse {
+	t.Helper()
+	payload, err := s3local.DefaultTrigger.Payload(srv.Events())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if payload == nil {
+		return nil
+	}
+	var event ObjectStorageEvent
+	if err = json.Unmarshal(payload, &event); err != nil {
+		t.Fatal(err)
+	}
+	resp, err := Handler(context.Background(), &event)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp
+}
+
+func TestHandlerCreatesThumbnails(t *testing.T) {
+	srv := startLocalStorage(t)
+	upload(t, srv, "uploads/star.png", "star.png", "image/png")
+
+	resp := trigger(t, srv)
+	if resp == nil || resp.StatusCode != 200 || resp.Processed != 1 {
+		t.Fatalf("unexpected response %+v", resp)
+	}
+
+	renditions, err := ParseRenditions(os.Getenv("THUMBNAIL_RENDITIONS"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, rendition := range renditions {
+		key := rendition.Key("star.png")
+		got, ok := srv.Object(testBucket, key)
+		if !ok {
+			t.Errorf("%s was not created", key)
+			continue
+		}
+		want, err := Thumbnail(filepath.Join("testdata", "star.png"), rendition)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got.Data, want.Data) {
+			t.Errorf("%s differs from the thumbnail made directly: %d bytes, want %d", key, len(got.Data), len(want.Data))
+		}
+		if got.ContentType != aws.ToString(rendition.ContentType(aws.String("image/png"))) {
+			t.Errorf("%s has content type %s", key, got.ContentType)
+		}
+		if !isGenerated(got.Metadata) || got.Metadata[sourceKeyKey] != "uploads/star.png" {
+			t.Errorf("%s has metadata %v", key, got.Metadata)
+		}
+
+		sidecarObject, ok := srv.Object(testBucket, SidecarKey(key))
+		if !ok {
+			t.Errorf("sidecar of %s was not created", key)
+			continue
+		}
+		var sidecar Sidecar
+		if err = json.Unmarshal(sidecarObject.Data, &sidecar); err != nil {
+			t.Fatal(err)
+		}
+		if sidecar.Width != want.Sidecar.Width || sidecar.Height != want.Sidecar.Height ||
+			sidecar.BlurHash != want.Sidecar.BlurHash || sidecar.Source.Key != "uploads/star.png" {
+			t.Errorf("sidecar of %s = %+v", key, sidecar)
+		}
+	}
+
+	// The thumbnails are outside the trigger prefix, so they do not trigger the function again.
+	if resp = trigger(t, srv); resp != nil {
+		t.Errorf("thumbnails triggered the function: %+v", resp)
+	}
+}
+
+func TestHandlerSkipsUpToDateThumbnails(t *testing.T) {
+	srv := startLocalStorage(t)
+	upload(t, srv, "uploads/star.png", "star.png", "image/png")
+	events := srv.Events()
+	payload, err := s3local.DefaultTrigger.Payload(events)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var event ObjectStorageEvent
+	if err = json.Unmarshal(payload, &event); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = Handler(context.Background(), &event); err != nil {
+		t.Fatal(err)
+	}
+
+	// A redelivered event finds the thumbnails of the same ETag and does nothing.
+	resp, err := Handler(context.Background(), &event)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != 200 || resp.Skipped != 1 || resp.Processed != 0 {
+		t.Errorf("unexpected response to a redelivered event %+v", resp)
+	}
+}
+
+func TestHandlerDeletesThumbnails(t *testing.T) {
+	srv := startLocalStorage(t)
+	upload(t, srv, "uploads/star.png", "star.png", "image/png")
+	// Another source with the same base name keeps its thumbnails. Their WebP renditions share a key,
+	// so the one left belongs to whichever source was processed last.
+	upload(t, srv, "uploads/star.jpeg", "star.png", "image/png")
+	if resp := trigger(t, srv); resp == nil || resp.Processed != 2 {
+		t.Fatalf("unexpected response %+v", resp)
+	}
+
+	req, err := http.NewRequest(http.MethodDelete, srv.URL()+"/"+testBucket+"/uploads/star.png", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	if got := trigger(t, srv); got == nil || got.StatusCode != 200 || got.Processed != 1 {
+		t.Fatalf("unexpected response %+v", got)
+	}
+	for _, key := range srv.Keys(testBucket, thumbnailRoot) {
+		o, _ := srv.Object(testBucket, key)
+		if o.Metadata[sourceKeyKey] == "uploads/star.png" {
+			t.Errorf("%s was not deleted", key)
+		}
+	}
+	if _, ok := srv.Object(testBucket, thumbnailRoot+"100x100/star.jpeg"); !ok {
+		t.Error("thumbnails of uploads/star.jpeg were deleted")
+	}
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/png"
+
+	"github.com/buckket/go-blurhash"
+	"github.com/davidbyttow/govips/v2/vips"
+)
+
+const (
+	// sidecarSuffix is appended to the thumbnail key to get the key of its sidecar object.
+	sidecarSuffix = ".json"
+	// placeholderSize is the size of the image the dominant color and the blurhash are computed from.
+	placeholderSize = 32
+	// blurhashComponents is the number of blurhash components along the longer side of the image.
+	blurhashComponents = 4
+)
+
+// SourceInfo describes the uploaded object a thumbnail was generated from.
+// For PDFs and videos the size is the size of the rendered first page or poster frame.
+type SourceInfo struct {
+	Key         string `json:"key"`          // The key of the source object.
+	ContentType string `json:"content_type"` // The MIME type of the source object.
+	Width       int    `json:"width"`        // The width of the source as stored, before rotation.
+	Height      int    `json:"height"`       // The height of the source as stored, before rotation.
+	Format      string `json:"format"`       // The format of the source, e.g. jpeg, pdf or video.
+	Orientation int    `json:"orientation"`  // The EXIF orientation of the source, 1 if upright or unknown.
+}
+
+// Sidecar is the JSON object stored next to every thumbnail, so clients can render a placeholder
+// before the thumbnail itself is loaded.
+type Sidecar struct {
+	Width         int        `json:"width"`          // The width of the thumbnail.
+	Height        int        `json:"height"`         // The height of the thumbnail.
+	Format        string     `json:"format"`         // The format of the thumbnail.
+	ContentType   string     `json:"content_type"`   // The MIME type of the thumbnail.
+	DominantColor string     `json:"dominant_color"` // The most common color of the thumbnail as #rrggbb.
+	BlurHash      string     `json:"blurhash"`       // The blurhash of the thumbnail.
+	Source        SourceInfo `json:"source"`         // The source image.
+}
+
+// SidecarKey returns the key of the sidecar object of the thumbnail with the given key.
+func SidecarKey(thumbnailKey string) string {
+	return thumbnailKey + sidecarSuffix
+}
+
+// probeSource reads the header of the source image file. Pixels are not decoded.
+func probeSource(source string) (SourceInfo, error) {
+	img, err := vips.NewImageFromFile(source)
+	if err != nil {
+		return SourceInfo{}, err
+	}
+	defer img.Close()
+	orientation := img.Orientation()
+	if orientation == 0 {
+		orientation = 1
+	}
+	return SourceInfo{
+		Width:       img.Width(),
+		Height:      img.Height(),
+		Format:      formatName(img.Format()),
+		Orientation: orientation,
+	}, nil
+}
+
+// placeholder computes the dominant color and the blurhash of the image.
+// Both are computed from a copy scaled down to placeholderSize, the image itself is not changed.
+func placeholder(img *vips.ImageRef) (dominantColor, hash string, err error) {
+	small, err := img.Copy()
+	if err != nil {
+		return "", "", err
+	}
+	defer small.Close()
+	if err = small.Thumbnail(placeholderSize, placeholderSize, vips.InterestingNone); err != nil {
+		return "", "", err
+	}
+	params := vips.NewPngExportParams()
+	params.StripMetadata = true
+	data, _, err := small.ExportPng(params)
+	if err != nil {
+		return "", "", err
+	}
+	pixels, err := png.Decode(bytes.NewReader(data))
+	if err != nil {
+		return "", "", err
+	}
+
+	// Keep the components proportional to the aspect ratio, so the placeholder is not distorted.
+	x, y := blurhashComponents, blurhashComponents
+	if w, h := pixels.Bounds().Dx(), pixels.Bounds().Dy(); w > h {
+		y = max(1, blurhashComponents*h/w)
+	} else if h > w {
+		x = max(1, blurhashComponents*w/h)
+	}
+	hash, err = blurhash.Encode(x, y, pixels)
+	if err != nil {
+		return "", "", err
+	}
+	return dominant(pixels), hash, nil
+}
+
+// dominant returns the most common color of the image as #rrggbb.
+// Colors are grouped into 4096 buckets (4 bits per channel) ignoring transparent pixels,
+// and the average color of the largest bucket is returned.
+func dominant(img image.Image) string {
+	type bucket struct {
+		count   int
+		r, g, b int
+	}
+	var buckets [4096]bucket
+	best := 0
+	bounds := img.Bounds()
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			r, g, b, a := img.At(x, y).RGBA()
+			if a < 0x8000 {
+				continue
+			}
+			r, g, b = r>>8, g>>8, b>>8
+			i := int(r>>4)<<8 | int(g>>4)<<4 | int(b>>4)
+			buckets[i].count++
+			buckets[i].r += int(r)
+			buckets[i].g += int(g)
+			buckets[i].b += int(b)
+			if buckets[i].count > buckets[best].count {
+				best = i
+			}
+		}
+	}
+	c := buckets[best]
+	if c.count == 0 {
+		return "#000000"
+	}
+	return fmt.Sprintf("#%02x%02x%02x", c.r/c.count, c.g/c.count, c.b/c.count)
+}
+
+// formatName returns the lower-case name of the vips image type, e.g. jpeg.
+func formatName(t vips.ImageType) string {
+	if name, ok := vips.ImageTypes[t]; ok {
+		return name
+	}
+	return "unknown"
+}
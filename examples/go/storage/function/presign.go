@@ -0,0 +1,198 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+const (
+	// defaultPresignExpiry is the lifetime of a presigned URL unless PRESIGN_EXPIRY says otherwise.
+	defaultPresignExpiry = 15 * time.Minute
+	// defaultPresignContentTypes are the content types clients may upload unless PRESIGN_CONTENT_TYPES says otherwise.
+	// They match the sources the thumbnail generators accept.
+	defaultPresignContentTypes = "image/*,application/pdf,video/*"
+	// userIDHeader is the header with the ID of the user the upload belongs to.
+	// It is set by the API gateway or the authorizer in front of the function, never by the browser itself.
+	userIDHeader = "X-User-Id"
+)
+
+var (
+	userIDPattern   = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+	unsafeFileChars = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+)
+
+// PresignRequest is the body of a request for an upload URL.
+type PresignRequest struct {
+	Filename    string `json:"filename"`     // The name of the file, only its base name is kept.
+	ContentType string `json:"content_type"` // The MIME type of the file, the upload must send the same.
+	Size        int64  `json:"size"`         // The size of the file in bytes, the upload must send the same.
+}
+
+// PresignResponse tells the client how to upload the file.
+type PresignResponse struct {
+	URL       string            `json:"url"`        // The presigned URL.
+	Method    string            `json:"method"`     // The HTTP method of the upload, always PUT.
+	Headers   map[string]string `json:"headers"`    // The headers the upload must send unchanged.
+	Key       string            `json:"key"`        // The key the file will be stored under.
+	ExpiresAt time.Time         `json:"expires_at"` // The time the URL stops working.
+}
+
+// Presign issues a presigned PUT URL, so browsers can upload files directly to the uploads bucket
+// without credentials. The content type and the size are signed, so the upload fails if the client sends
+// anything else, and every user gets their own prefix under uploads/, which triggers the thumbnails.
+// Configuration:
+//   - UPLOAD_BUCKET is the bucket the files are uploaded to;
+//   - PRESIGN_CONTENT_TYPES is a comma-separated list of allowed types, type/* allows the whole type;
+//   - PRESIGN_EXPIRY is the lifetime of the URL, e.g. 5m;
+//   - THUMBNAIL_MAX_SOURCE_BYTES is the largest allowed file, the same limit the thumbnailer applies.
+func Presign(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeJSONError(w, http.StatusMethodNotAllowed, "only POST is allowed")
+		return
+	}
+	bucket := os.Getenv("UPLOAD_BUCKET")
+	if bucket == "" {
+		writeJSONError(w, http.StatusInternalServerError, "UPLOAD_BUCKET is not set")
+		return
+	}
+	userID := r.Header.Get(userIDHeader)
+	if !userIDPattern.MatchString(userID) {
+		writeJSONError(w, http.StatusUnauthorized, "missing or invalid "+userIDHeader)
+		return
+	}
+
+	var req PresignRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 4096)).Decode(&req); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid request body: "+err.Error())
+		return
+	}
+	if err := req.validate(); err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	key, err := uploadKey(userID, req.Filename)
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	expiry, err := presignExpiry()
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	s3Client, err := newS3Client(r.Context())
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	presigned, err := s3.NewPresignClient(s3Client).PresignPutObject(r.Context(), &s3.PutObjectInput{
+		Bucket:        aws.String(bucket),
+		Key:           aws.String(key),
+		ContentType:   aws.String(req.ContentType),
+		ContentLength: aws.Int64(req.Size),
+	}, s3.WithPresignExpires(expiry))
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, fmt.Sprintf("failed to presign upload: %v", err))
+		return
+	}
+
+	headers := map[string]string{}
+	for name, values := range presigned.SignedHeader {
+		// The browser sets Host itself.
+		if len(values) > 0 && !strings.EqualFold(name, "Host") {
+			headers[name] = values[0]
+		}
+	}
+	writeJSON(w, http.StatusOK, PresignResponse{
+		URL:       presigned.URL,
+		Method:    presigned.Method,
+		Headers:   headers,
+		Key:       key,
+		ExpiresAt: time.Now().Add(expiry).UTC(),
+	})
+}
+
+func (req PresignRequest) validate() error {
+	if strings.TrimSpace(req.Filename) == "" {
+		return fmt.Errorf("filename is required")
+	}
+	if !contentTypeAllowed(req.ContentType) {
+		return fmt.Errorf("content type %q is not allowed", req.ContentType)
+	}
+	limit := int64FromEnv("THUMBNAIL_MAX_SOURCE_BYTES", defaultMaxSourceBytes)
+	if req.Size <= 0 || req.Size > limit {
+		return fmt.Errorf("size must be between 1 and %d bytes", limit)
+	}
+	return nil
+}
+
+// contentTypeAllowed reports whether the content type matches PRESIGN_CONTENT_TYPES.
+func contentTypeAllowed(contentType string) bool {
+	if contentType == "" || strings.ContainsAny(contentType, ";,") {
+		return false
+	}
+	allowed := os.Getenv("PRESIGN_CONTENT_TYPES")
+	if allowed == "" {
+		allowed = defaultPresignContentTypes
+	}
+	for _, pattern := range strings.Split(allowed, ",") {
+		pattern = strings.TrimSpace(pattern)
+		if prefix, ok := strings.CutSuffix(pattern, "/*"); ok {
+			if strings.HasPrefix(contentType, prefix+"/") {
+				return true
+			}
+		} else if contentType == pattern {
+			return true
+		}
+	}
+	return false
+}
+
+// uploadKey returns a new key under the prefix of the user. A random part keeps uploads of files
+// with the same name from replacing each other.
+func uploadKey(userID, filename string) (string, error) {
+	name := unsafeFileChars.ReplaceAllString(path.Base(strings.ReplaceAll(filename, `\`, "/")), "_")
+	name = strings.TrimLeft(name, ".")
+	if name == "" {
+		name = "file"
+	}
+	var id [8]byte
+	if _, err := rand.Read(id[:]); err != nil {
+		return "", fmt.Errorf("failed to generate key: %w", err)
+	}
+	return fmt.Sprintf("uploads/%s/%s-%s", userID, hex.EncodeToString(id[:]), name), nil
+}
+
+func presignExpiry() (time.Duration, error) {
+	v := os.Getenv("PRESIGN_EXPIRY")
+	if v == "" {
+		return defaultPresignExpiry, nil
+	}
+	expiry, err := time.ParseDuration(v)
+	if err != nil || expiry <= 0 || expiry > 7*24*time.Hour {
+		return 0, fmt.Errorf("invalid PRESIGN_EXPIRY %q", v)
+	}
+	return expiry, nil
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func writeJSONError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]string{"error": message})
+}
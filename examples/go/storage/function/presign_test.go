@@ -0,0 +1,106 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func presign(t *testing.T, userID string, req PresignRequest) *httptest.ResponseRecorder {
+	t.Helper()
+	body, err := json.Marshal(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(body))
+	if userID != "" {
+		r.Header.Set(userIDHeader, userID)
+	}
+	w := httptest.NewRecorder()
+	Presign(w, r)
+	return w
+}
+
+func TestPresignUpload(t *testing.T) {
+	srv := startLocalStorage(t)
+	t.Setenv("UPLOAD_BUCKET", testBucket)
+	data := []byte("not really a png")
+
+	w := presign(t, "user-1", PresignRequest{Filename: "../My Photo.png", ContentType: "image/png", Size: int64(len(data))})
+	if w.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", w.Code, w.Body)
+	}
+	var resp PresignResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(resp.Key, "uploads/user-1/") || !strings.HasSuffix(resp.Key, "-My_Photo.png") {
+		t.Errorf("unexpected key %s", resp.Key)
+	}
+
+	// The content type and the size are signed, an upload sending others is rejected
+	if status := uploadPresigned(t, resp, []byte("a larger file than signed"), nil); status != http.StatusForbidden {
+		t.Errorf("an upload of another size got status %d", status)
+	}
+	if status := uploadPresigned(t, resp, data, map[string]string{"Content-Type": "text/html"}); status != http.StatusForbidden {
+		t.Errorf("an upload of another content type got status %d", status)
+	}
+	if status := uploadPresigned(t, resp, data, nil); status != http.StatusOK {
+		t.Fatalf("upload failed with status %d", status)
+	}
+	o, ok := srv.Object(testBucket, resp.Key)
+	if !ok || !bytes.Equal(o.Data, data) || o.ContentType != "image/png" {
+		t.Errorf("unexpected uploaded object %+v", o)
+	}
+}
+
+// uploadPresigned uploads the data to the presigned URL with its headers, replaced by the headers given,
+// and returns the status of the upload.
+func uploadPresigned(t *testing.T, resp PresignResponse, data []byte, headers map[string]string) int {
+	t.Helper()
+	put, err := http.NewRequest(resp.Method, resp.URL, bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, value := range resp.Headers {
+		put.Header.Set(name, value)
+	}
+	for name, value := range headers {
+		put.Header.Set(name, value)
+	}
+	res, err := http.DefaultClient.Do(put)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	return res.StatusCode
+}
+
+func TestPresignRejects(t *testing.T) {
+	startLocalStorage(t)
+	t.Setenv("UPLOAD_BUCKET", testBucket)
+	t.Setenv("THUMBNAIL_MAX_SOURCE_BYTES", "1000")
+
+	cases := []struct {
+		name   string
+		userID string
+		req    PresignRequest
+		status int
+	}{
+		{"no user", "", PresignRequest{Filename: "a.png", ContentType: "image/png", Size: 10}, http.StatusUnauthorized},
+		{"invalid user", "../other", PresignRequest{Filename: "a.png", ContentType: "image/png", Size: 10}, http.StatusUnauthorized},
+		{"content type", "u", PresignRequest{Filename: "a.exe", ContentType: "application/x-msdownload", Size: 10}, http.StatusBadRequest},
+		{"too large", "u", PresignRequest{Filename: "a.png", ContentType: "image/png", Size: 1001}, http.StatusBadRequest},
+		{"empty", "u", PresignRequest{Filename: "a.png", ContentType: "image/png"}, http.StatusBadRequest},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if w := presign(t, c.userID, c.req); w.Code != c.status {
+				t.Errorf("status %d, want %d: %s", w.Code, c.status, w.Body)
+			}
+		})
+	}
+}
@@ -0,0 +1,251 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/davidbyttow/govips/v2/vips"
+)
+
+// Format is the output format of a rendition.
+type Format string
+
+const (
+	FormatNative Format = "native" // Keep the format of the source image.
+	FormatJPEG   Format = "jpeg"
+	FormatWebP   Format = "webp"
+	FormatAVIF   Format = "avif"
+)
+
+// Crop is the area of interest kept when the image is cropped to the rendition size.
+type Crop string
+
+const (
+	CropNone      Crop = "none"
+	CropCentre    Crop = "centre"
+	CropEntropy   Crop = "entropy"
+	CropAttention Crop = "attention"
+	CropLow       Crop = "low"
+	CropHigh      Crop = "high"
+	CropAll       Crop = "all"
+)
+
+var cropModes = map[Crop]vips.Interesting{
+	CropNone:      vips.InterestingNone,
+	CropCentre:    vips.InterestingCentre,
+	CropEntropy:   vips.InterestingEntropy,
+	CropAttention: vips.InterestingAttention,
+	CropLow:       vips.InterestingLow,
+	CropHigh:      vips.InterestingHigh,
+	CropAll:       vips.InterestingAll,
+}
+
+// Interesting returns the vips crop mode for the crop.
+func (c Crop) Interesting() vips.Interesting {
+	return cropModes[c]
+}
+
+// Rendition describes a single thumbnail produced for every uploaded image.
+type Rendition struct {
+	Width   int    `json:"width"`             // The width of the thumbnail in pixels.
+	Height  int    `json:"height"`            // The height of the thumbnail in pixels.
+	Crop    Crop   `json:"crop,omitempty"`    // The crop mode, centre by default.
+	Format  Format `json:"format,omitempty"`  // The output format, the source format by default.
+	Quality int    `json:"quality,omitempty"` // The encoder quality 1-100, the encoder default if zero.
+	// StripMetadata removes EXIF (including GPS), XMP and IPTC metadata from the thumbnail.
+	// THUMBNAIL_STRIP_METADATA=true enables it for all renditions.
+	StripMetadata bool `json:"strip_metadata,omitempty"`
+}
+
+// RenditionConfig is the content of the bucket-side config object.
+type RenditionConfig struct {
+	Renditions []Rendition `json:"renditions"`
+}
+
+// defaultRenditions are used when neither the environment nor the bucket configures renditions.
+var defaultRenditions = []Rendition{
+	{Width: 100, Height: 100, Crop: CropCentre, Format: FormatNative},
+}
+
+// contentTypes maps output formats to the MIME type stored with the thumbnail.
+var contentTypes = map[Format]string{
+	FormatJPEG: "image/jpeg",
+	FormatWebP: "image/webp",
+	FormatAVIF: "image/avif",
+}
+
+// Prefix returns the key prefix all thumbnails of the rendition are stored under.
+// Renditions with an output format get a prefix of their own, so a JPEG rendition of photo.png
+// does not overwrite the native rendition of photo.jpeg of the same size.
+func (r Rendition) Prefix() string {
+	if r.Format == FormatNative {
+		return fmt.Sprintf("%s%dx%d/", thumbnailRoot, r.Width, r.Height)
+	}
+	return fmt.Sprintf("%s%dx%d-%s/", thumbnailRoot, r.Width, r.Height, r.Format)
+}
+
+// Key returns the key of the thumbnail generated from the source object with the given name.
+func (r Rendition) Key(name string) string {
+	return r.keyUnder(r.Prefix(), name)
+}
+
+func (r Rendition) keyUnder(prefix, name string) string {
+	if r.Format == FormatNative {
+		return prefix + name
+	}
+	return prefix + strings.TrimSuffix(name, path.Ext(name)) + "." + string(r.Format)
+}
+
+// ContentType returns the MIME type of the thumbnail.
+// Thumbnails in the native format inherit the content type of the source object.
+func (r Rendition) ContentType(source *string) *string {
+	if contentType, ok := contentTypes[r.Format]; ok {
+		return aws.String(contentType)
+	}
+	return source
+}
+
+func (r Rendition) validate() error {
+	if r.Width <= 0 || r.Height <= 0 {
+		return fmt.Errorf("invalid size %dx%d", r.Width, r.Height)
+	}
+	if _, ok := cropModes[r.Crop]; !ok {
+		return fmt.Errorf("unknown crop mode %q", r.Crop)
+	}
+	if _, ok := contentTypes[r.Format]; !ok && r.Format != FormatNative {
+		return fmt.Errorf("unknown format %q", r.Format)
+	}
+	if r.Quality < 0 || r.Quality > 100 {
+		return fmt.Errorf("quality %d is out of range 1-100, or 0 for the encoder default", r.Quality)
+	}
+	return nil
+}
+
+func (r Rendition) withDefaults() Rendition {
+	if r.Crop == "" {
+		r.Crop = CropCentre
+	}
+	if r.Format == "" {
+		r.Format = FormatNative
+	}
+	return r
+}
+
+// ParseRenditions parses a comma-separated list of renditions in the form
+// WIDTHxHEIGHT[:crop[:format[:quality]]], e.g. "200x200:attention:webp:80,100x100".
+func ParseRenditions(spec string) ([]Rendition, error) {
+	var renditions []Rendition
+	for _, item := range strings.Split(spec, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		parts := strings.Split(item, ":")
+		if len(parts) > 4 {
+			return nil, fmt.Errorf("rendition %q: too many fields", item)
+		}
+		var r Rendition
+		if _, err := fmt.Sscanf(parts[0], "%dx%d", &r.Width, &r.Height); err != nil {
+			return nil, fmt.Errorf("rendition %q: invalid size: %w", item, err)
+		}
+		if len(parts) > 1 {
+			r.Crop = Crop(parts[1])
+		}
+		if len(parts) > 2 {
+			r.Format = Format(parts[2])
+		}
+		if len(parts) > 3 {
+			quality, err := strconv.Atoi(parts[3])
+			if err != nil {
+				return nil, fmt.Errorf("rendition %q: invalid quality: %w", item, err)
+			}
+			r.Quality = quality
+		}
+		r = r.withDefaults()
+		if err := r.validate(); err != nil {
+			return nil, fmt.Errorf("rendition %q: %w", item, err)
+		}
+		renditions = append(renditions, r)
+	}
+	return renditions, nil
+}
+
+// LoadRenditions returns the renditions configured for the bucket.
+// The config object named by THUMBNAIL_CONFIG_KEY takes precedence over
+// the THUMBNAIL_RENDITIONS environment variable, and both fall back to a single 100x100 thumbnail.
+func LoadRenditions(ctx context.Context, client *s3.Client, bucket string) ([]Rendition, error) {
+	renditions, err := loadRenditions(ctx, client, bucket)
+	if err != nil {
+		return nil, err
+	}
+	if strip, _ := strconv.ParseBool(os.Getenv("THUMBNAIL_STRIP_METADATA")); strip {
+		// Copy the slice, so the defaults are not changed.
+		renditions = append([]Rendition(nil), renditions...)
+		for i := range renditions {
+			renditions[i].StripMetadata = true
+		}
+	}
+	return renditions, nil
+}
+
+func loadRenditions(ctx context.Context, client *s3.Client, bucket string) ([]Rendition, error) {
+	if key := os.Getenv("THUMBNAIL_CONFIG_KEY"); key != "" {
+		renditions, err := loadBucketRenditions(ctx, client, bucket, key)
+		if err != nil {
+			return nil, err
+		}
+		if len(renditions) > 0 {
+			return renditions, nil
+		}
+	}
+	if spec := os.Getenv("THUMBNAIL_RENDITIONS"); spec != "" {
+		renditions, err := ParseRenditions(spec)
+		if err != nil {
+			return nil, fmt.Errorf("invalid THUMBNAIL_RENDITIONS: %w", err)
+		}
+		if len(renditions) > 0 {
+			return renditions, nil
+		}
+	}
+	return defaultRenditions, nil
+}
+
+// loadBucketRenditions reads the renditions from a JSON config object stored in the bucket.
+// A missing object is not an error, the caller falls back to the environment.
+func loadBucketRenditions(ctx context.Context, client *s3.Client, bucket, key string) ([]Rendition, error) {
+	object, err := client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		var noSuchKey *types.NoSuchKey
+		if errors.As(err, &noSuchKey) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to get rendition config %s: %w", key, err)
+	}
+	defer object.Body.Close()
+
+	var config RenditionConfig
+	if err = json.NewDecoder(object.Body).Decode(&config); err != nil {
+		return nil, fmt.Errorf("failed to parse rendition config %s: %w", key, err)
+	}
+	renditions := make([]Rendition, 0, len(config.Renditions))
+	for _, r := range config.Renditions {
+		r = r.withDefaults()
+		if err = r.validate(); err != nil {
+			return nil, fmt.Errorf("rendition config %s: %w", key, err)
+		}
+		renditions = append(renditions, r)
+	}
+	return renditions, nil
+}
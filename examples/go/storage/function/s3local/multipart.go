@@ -0,0 +1,135 @@
+package s3local
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"strconv"
+)
+
+// upload is a multipart upload in progress.
+type upload struct {
+	bucket, key string
+	contentType string
+	metadata    map[string]string
+	parts       map[int][]byte
+}
+
+type initiateResult struct {
+	XMLName  xml.Name `xml:"InitiateMultipartUploadResult"`
+	Bucket   string   `xml:"Bucket"`
+	Key      string   `xml:"Key"`
+	UploadID string   `xml:"UploadId"`
+}
+
+type completeRequest struct {
+	Parts []struct {
+		PartNumber int    `xml:"PartNumber"`
+		ETag       string `xml:"ETag"`
+	} `xml:"Part"`
+}
+
+type completeResult struct {
+	XMLName xml.Name `xml:"CompleteMultipartUploadResult"`
+	Bucket  string   `xml:"Bucket"`
+	Key     string   `xml:"Key"`
+	ETag    string   `xml:"ETag"`
+}
+
+func (s *Server) createMultipartUpload(w http.ResponseWriter, r *http.Request, bucket, key string) {
+	s.mu.Lock()
+	if _, ok := s.buckets[bucket]; !ok {
+		s.mu.Unlock()
+		writeError(w, http.StatusNotFound, "NoSuchBucket", "The specified bucket does not exist")
+		return
+	}
+	s.nextID++
+	id := strconv.Itoa(s.nextID)
+	s.uploads[id] = &upload{
+		bucket:      bucket,
+		key:         key,
+		contentType: r.Header.Get("Content-Type"),
+		metadata:    metadataFrom(r.Header),
+		parts:       map[int][]byte{},
+	}
+	s.mu.Unlock()
+	writeXML(w, initiateResult{Bucket: bucket, Key: key, UploadID: id})
+}
+
+func (s *Server) uploadPart(w http.ResponseWriter, r *http.Request, id, partNumber string) {
+	n, err := strconv.Atoi(partNumber)
+	if err != nil || n < 1 {
+		writeError(w, http.StatusBadRequest, "InvalidArgument", "invalid part number "+partNumber)
+		return
+	}
+	data, err := readBody(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "IncompleteBody", err.Error())
+		return
+	}
+	s.mu.Lock()
+	u, ok := s.uploads[id]
+	if ok {
+		u.parts[n] = data
+	}
+	s.mu.Unlock()
+	if !ok {
+		writeError(w, http.StatusNotFound, "NoSuchUpload", "The specified upload does not exist")
+		return
+	}
+	sum := md5.Sum(data)
+	w.Header().Set("ETag", `"`+hex.EncodeToString(sum[:])+`"`)
+	w.WriteHeader(http.StatusOK)
+}
+
+// completeMultipartUpload joins the listed parts into the object.
+// The ETag is computed as S3 does: the MD5 of the concatenated part MD5s followed by the number of parts.
+func (s *Server) completeMultipartUpload(w http.ResponseWriter, r *http.Request, bucket, key, id string) {
+	var req completeRequest
+	if err := decodeXML(r, &req); err != nil {
+		writeError(w, http.StatusBadRequest, "MalformedXML", err.Error())
+		return
+	}
+	s.mu.Lock()
+	u, ok := s.uploads[id]
+	if ok {
+		delete(s.uploads, id)
+	}
+	s.mu.Unlock()
+	if !ok || u.bucket != bucket || u.key != key {
+		writeError(w, http.StatusNotFound, "NoSuchUpload", "The specified upload does not exist")
+		return
+	}
+
+	var data, sums []byte
+	for _, p := range req.Parts {
+		part, ok := u.parts[p.PartNumber]
+		if !ok {
+			writeError(w, http.StatusBadRequest, "InvalidPart", fmt.Sprintf("part %d was not uploaded", p.PartNumber))
+			return
+		}
+		sum := md5.Sum(part)
+		data = append(data, part...)
+		sums = append(sums, sum[:]...)
+	}
+	sum := md5.Sum(sums)
+	o := &Object{
+		Data:        data,
+		ContentType: u.contentType,
+		ETag:        fmt.Sprintf(`"%s-%d"`, hex.EncodeToString(sum[:]), len(req.Parts)),
+		Metadata:    u.metadata,
+	}
+	if !s.store(w, bucket, key, o) {
+		return
+	}
+	writeXML(w, completeResult{Bucket: bucket, Key: key, ETag: o.ETag})
+}
+
+func (s *Server) abortMultipartUpload(w http.ResponseWriter, id string) {
+	s.mu.Lock()
+	delete(s.uploads, id)
+	s.mu.Unlock()
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,410 @@
+// Package s3local is an in-process S3-compatible server for tests of the storage handler.
+//
+// It keeps buckets in memory and implements the subset of the S3 API the handler and the AWS SDK upload manager
+// use: PutObject, GetObject, HeadObject, DeleteObject, DeleteObjects, ListObjectsV2 and multipart uploads.
+// Requests use path-style addressing, e.g. PUT /bucket/key, and are not authenticated, except presigned URLs:
+// with SecretKeys set their signature is checked, so an upload sending other signed headers is rejected.
+// Every created or deleted object is recorded as an event, so a Trigger can turn them into the payload
+// the object storage trigger sends to the function.
+package s3local
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// metaPrefix is the prefix of the headers carrying user metadata.
+const metaPrefix = "X-Amz-Meta-"
+
+// Object is an object stored in the server.
+type Object struct {
+	Data         []byte
+	ContentType  string
+	ETag         string            // The quoted ETag, as returned in the ETag header.
+	Metadata     map[string]string // The user metadata with lower-case keys.
+	LastModified time.Time
+}
+
+// Server is an in-memory S3-compatible server.
+type Server struct {
+	// SecretKeys are the secret keys by their access key IDs, to check the signatures of presigned URLs with.
+	// Without them presigned URLs are not checked either.
+	SecretKeys map[string]string
+
+	mu      sync.Mutex
+	buckets map[string]map[string]*Object
+	uploads map[string]*upload
+	events  []Event
+	nextID  int
+	http    *httptest.Server
+}
+
+// NewServer starts a server listening on a local port. Stop it with Close.
+func NewServer(buckets ...string) *Server {
+	s := &Server{
+		buckets: map[string]map[string]*Object{},
+		uploads: map[string]*upload{},
+	}
+	for _, b := range buckets {
+		s.CreateBucket(b)
+	}
+	s.http = httptest.NewServer(s)
+	return s
+}
+
+// URL returns the endpoint of the server, e.g. http://127.0.0.1:12345.
+func (s *Server) URL() string {
+	return s.http.URL
+}
+
+// Close stops the server.
+func (s *Server) Close() {
+	s.http.Close()
+}
+
+// CreateBucket creates an empty bucket. Existing buckets are kept as they are.
+func (s *Server) CreateBucket(name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.buckets[name]; !ok {
+		s.buckets[name] = map[string]*Object{}
+	}
+}
+
+// Object returns a copy of the stored object, or false if there is none.
+func (s *Server) Object(bucket, key string) (Object, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	o, ok := s.buckets[bucket][key]
+	if !ok {
+		return Object{}, false
+	}
+	return *o, true
+}
+
+// Keys returns the sorted keys of all objects in the bucket with the prefix.
+func (s *Server) Keys(bucket, prefix string) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var keys []string
+	for key := range s.buckets[bucket] {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// ServeHTTP routes the request to the S3 operation.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	bucket, key, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	query := r.URL.Query()
+	if query.Has("X-Amz-Signature") && s.SecretKeys != nil {
+		if err := s.checkPresigned(r); err != nil {
+			writeError(w, http.StatusForbidden, err.code, err.message)
+			return
+		}
+	}
+	if bucket == "" {
+		writeError(w, http.StatusBadRequest, "InvalidRequest", "bucket is required")
+		return
+	}
+	if key == "" {
+		switch {
+		case r.Method == http.MethodPut:
+			s.CreateBucket(bucket)
+		case r.Method == http.MethodGet && query.Get("list-type") == "2":
+			s.listObjects(w, r, bucket)
+		case r.Method == http.MethodPost && query.Has("delete"):
+			s.deleteObjects(w, r, bucket)
+		default:
+			writeError(w, http.StatusNotImplemented, "NotImplemented", r.Method+" on a bucket is not supported")
+		}
+		return
+	}
+
+	switch {
+	case r.Method == http.MethodPost && query.Has("uploads"):
+		s.createMultipartUpload(w, r, bucket, key)
+	case r.Method == http.MethodPut && query.Has("uploadId"):
+		s.uploadPart(w, r, query.Get("uploadId"), query.Get("partNumber"))
+	case r.Method == http.MethodPost && query.Has("uploadId"):
+		s.completeMultipartUpload(w, r, bucket, key, query.Get("uploadId"))
+	case r.Method == http.MethodDelete && query.Has("uploadId"):
+		s.abortMultipartUpload(w, query.Get("uploadId"))
+	case r.Method == http.MethodPut:
+		s.putObject(w, r, bucket, key)
+	case r.Method == http.MethodGet, r.Method == http.MethodHead:
+		s.getObject(w, r, bucket, key)
+	case r.Method == http.MethodDelete:
+		s.deleteObject(w, bucket, key)
+	default:
+		writeError(w, http.StatusNotImplemented, "NotImplemented", r.Method+" on an object is not supported")
+	}
+}
+
+func (s *Server) putObject(w http.ResponseWriter, r *http.Request, bucket, key string) {
+	data, err := readBody(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "IncompleteBody", err.Error())
+		return
+	}
+	sum := md5.Sum(data)
+	o := &Object{
+		Data:        data,
+		ContentType: r.Header.Get("Content-Type"),
+		ETag:        `"` + hex.EncodeToString(sum[:]) + `"`,
+		Metadata:    metadataFrom(r.Header),
+	}
+	if !s.store(w, bucket, key, o) {
+		return
+	}
+	w.Header().Set("ETag", o.ETag)
+	w.WriteHeader(http.StatusOK)
+}
+
+// store saves the object and records the event. It writes NoSuchBucket and returns false if there is no bucket.
+func (s *Server) store(w http.ResponseWriter, bucket, key string, o *Object) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	objects, ok := s.buckets[bucket]
+	if !ok {
+		writeError(w, http.StatusNotFound, "NoSuchBucket", "The specified bucket does not exist")
+		return false
+	}
+	o.LastModified = time.Now().UTC()
+	eventType := EventCreate
+	if _, exists := objects[key]; exists {
+		eventType = EventUpdate
+	}
+	objects[key] = o
+	s.record(eventType, bucket, key)
+	return true
+}
+
+func (s *Server) getObject(w http.ResponseWriter, r *http.Request, bucket, key string) {
+	o, ok := s.Object(bucket, key)
+	if !ok {
+		writeError(w, http.StatusNotFound, "NoSuchKey", "The specified key does not exist")
+		return
+	}
+	if match := r.Header.Get("If-Match"); match != "" && match != o.ETag && match != "*" {
+		writeError(w, http.StatusPreconditionFailed, "PreconditionFailed", "At least one of the preconditions failed")
+		return
+	}
+	h := w.Header()
+	h.Set("ETag", o.ETag)
+	h.Set("Content-Length", strconv.Itoa(len(o.Data)))
+	h.Set("Last-Modified", o.LastModified.Format(http.TimeFormat))
+	if o.ContentType != "" {
+		h.Set("Content-Type", o.ContentType)
+	}
+	for k, v := range o.Metadata {
+		h.Set(metaPrefix+k, v)
+	}
+	w.WriteHeader(http.StatusOK)
+	if r.Method == http.MethodGet {
+		_, _ = w.Write(o.Data)
+	}
+}
+
+func (s *Server) deleteObject(w http.ResponseWriter, bucket, key string) {
+	s.remove(bucket, key)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// remove deletes the object and records the event. Deleting a missing object is not an error, as in S3.
+func (s *Server) remove(bucket, key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.buckets[bucket][key]; ok {
+		delete(s.buckets[bucket], key)
+		s.record(EventDelete, bucket, key)
+	}
+}
+
+type deleteRequest struct {
+	Quiet   bool `xml:"Quiet"`
+	Objects []struct {
+		Key string `xml:"Key"`
+	} `xml:"Object"`
+}
+
+type deletedObject struct {
+	Key string `xml:"Key"`
+}
+
+type deleteResult struct {
+	XMLName xml.Name        `xml:"DeleteResult"`
+	Deleted []deletedObject `xml:"Deleted"`
+}
+
+func (s *Server) deleteObjects(w http.ResponseWriter, r *http.Request, bucket string) {
+	var req deleteRequest
+	if err := decodeXML(r, &req); err != nil {
+		writeError(w, http.StatusBadRequest, "MalformedXML", err.Error())
+		return
+	}
+	var result deleteResult
+	for _, o := range req.Objects {
+		s.remove(bucket, o.Key)
+		if !req.Quiet {
+			result.Deleted = append(result.Deleted, deletedObject{Key: o.Key})
+		}
+	}
+	writeXML(w, result)
+}
+
+type listResult struct {
+	XMLName        xml.Name       `xml:"ListBucketResult"`
+	Name           string         `xml:"Name"`
+	Prefix         string         `xml:"Prefix"`
+	Delimiter      string         `xml:"Delimiter,omitempty"`
+	KeyCount       int            `xml:"KeyCount"`
+	MaxKeys        int            `xml:"MaxKeys"`
+	IsTruncated    bool           `xml:"IsTruncated"`
+	Contents       []listObject   `xml:"Contents"`
+	CommonPrefixes []commonPrefix `xml:"CommonPrefixes"`
+}
+
+type listObject struct {
+	Key          string `xml:"Key"`
+	LastModified string `xml:"LastModified"`
+	ETag         string `xml:"ETag"`
+	Size         int    `xml:"Size"`
+}
+
+type commonPrefix struct {
+	Prefix string `xml:"Prefix"`
+}
+
+// listObjects lists all matching objects in a single page. Pagination is not needed for test buckets.
+func (s *Server) listObjects(w http.ResponseWriter, r *http.Request, bucket string) {
+	s.mu.Lock()
+	objects, ok := s.buckets[bucket]
+	if !ok {
+		s.mu.Unlock()
+		writeError(w, http.StatusNotFound, "NoSuchBucket", "The specified bucket does not exist")
+		return
+	}
+	prefix := r.URL.Query().Get("prefix")
+	delimiter := r.URL.Query().Get("delimiter")
+	result := listResult{Name: bucket, Prefix: prefix, Delimiter: delimiter, MaxKeys: 1000}
+	seen := map[string]bool{}
+	for key, o := range objects {
+		if !strings.HasPrefix(key, prefix) {
+			continue
+		}
+		if delimiter != "" {
+			if i := strings.Index(key[len(prefix):], delimiter); i >= 0 {
+				p := key[:len(prefix)+i+len(delimiter)]
+				if !seen[p] {
+					seen[p] = true
+					result.CommonPrefixes = append(result.CommonPrefixes, commonPrefix{Prefix: p})
+				}
+				continue
+			}
+		}
+		result.Contents = append(result.Contents, listObject{
+			Key:          key,
+			LastModified: o.LastModified.Format(time.RFC3339),
+			ETag:         o.ETag,
+			Size:         len(o.Data),
+		})
+	}
+	s.mu.Unlock()
+
+	sort.Slice(result.Contents, func(i, j int) bool { return result.Contents[i].Key < result.Contents[j].Key })
+	sort.Slice(result.CommonPrefixes, func(i, j int) bool {
+		return result.CommonPrefixes[i].Prefix < result.CommonPrefixes[j].Prefix
+	})
+	result.KeyCount = len(result.Contents) + len(result.CommonPrefixes)
+	writeXML(w, result)
+}
+
+// readBody reads the request body, decoding the aws-chunked encoding the SDK uses for trailing checksums.
+func readBody(r *http.Request) ([]byte, error) {
+	data, err := io.ReadAll(r.Body)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.Contains(r.Header.Get("Content-Encoding"), "aws-chunked") &&
+		!strings.HasPrefix(r.Header.Get("X-Amz-Content-Sha256"), "STREAMING-") {
+		return data, nil
+	}
+	return decodeChunked(data)
+}
+
+// decodeChunked decodes the aws-chunked body: chunks of "size[;extensions]\r\ndata\r\n"
+// ending with a zero-size chunk followed by optional trailers.
+func decodeChunked(data []byte) ([]byte, error) {
+	var out []byte
+	for {
+		line, rest, ok := strings.Cut(string(data), "\r\n")
+		if !ok {
+			return nil, errors.New("malformed aws-chunked body")
+		}
+		sizeHex, _, _ := strings.Cut(line, ";")
+		size, err := strconv.ParseInt(strings.TrimSpace(sizeHex), 16, 64)
+		if err != nil {
+			return nil, fmt.Errorf("malformed chunk size %q", line)
+		}
+		if size == 0 {
+			return out, nil
+		}
+		if int64(len(rest)) < size+2 {
+			return nil, errors.New("truncated aws-chunked body")
+		}
+		out = append(out, rest[:size]...)
+		data = []byte(rest[size+2:])
+	}
+}
+
+func metadataFrom(h http.Header) map[string]string {
+	meta := map[string]string{}
+	for k, v := range h {
+		if strings.HasPrefix(k, metaPrefix) && len(v) > 0 {
+			meta[strings.ToLower(strings.TrimPrefix(k, metaPrefix))] = v[0]
+		}
+	}
+	return meta
+}
+
+type errorResponse struct {
+	XMLName xml.Name `xml:"Error"`
+	Code    string   `xml:"Code"`
+	Message string   `xml:"Message"`
+}
+
+func writeError(w http.ResponseWriter, status int, code, message string) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	_ = xml.NewEncoder(w).Encode(errorResponse{Code: code, Message: message})
+}
+
+func writeXML(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(http.StatusOK)
+	_, _ = io.WriteString(w, xml.Header)
+	_ = xml.NewEncoder(w).Encode(v)
+}
+
+func decodeXML(r *http.Request, v any) error {
+	data, err := readBody(r)
+	if err != nil {
+		return err
+	}
+	return xml.Unmarshal(data, v)
+}
@@ -0,0 +1,284 @@
+package s3local
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	smithyendpoints "github.com/aws/smithy-go/endpoints"
+)
+
+const bucket = "test-bucket"
+
+type resolver struct{ endpoint string }
+
+func (r resolver) ResolveEndpoint(_ context.Context, params s3.EndpointParameters) (smithyendpoints.Endpoint, error) {
+	u, err := url.Parse(r.endpoint)
+	if err != nil {
+		return smithyendpoints.Endpoint{}, err
+	}
+	u.Path += "/" + *params.Bucket
+	return smithyendpoints.Endpoint{URI: *u}, nil
+}
+
+func newClient(t *testing.T) (*Server, *s3.Client) {
+	t.Helper()
+	srv := NewServer(bucket)
+	t.Cleanup(srv.Close)
+	client := s3.New(s3.Options{
+		Region:             "ru-central1",
+		Credentials:        credentials.NewStaticCredentialsProvider("key", "secret", ""),
+		EndpointResolverV2: resolver{endpoint: srv.URL()},
+	})
+	return srv, client
+}
+
+func TestPutGetHead(t *testing.T) {
+	ctx := context.Background()
+	_, client := newClient(t)
+
+	put, err := client.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:      aws.String(bucket),
+		Key:         aws.String("uploads/a.txt"),
+		Body:        bytes.NewReader([]byte("hello")),
+		ContentType: aws.String("text/plain"),
+		Metadata:    map[string]string{"source-key": "x"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	head, err := client.HeadObject(ctx, &s3.HeadObjectInput{Bucket: aws.String(bucket), Key: aws.String("uploads/a.txt")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if aws.ToString(head.ETag) != aws.ToString(put.ETag) || aws.ToInt64(head.ContentLength) != 5 ||
+		aws.ToString(head.ContentType) != "text/plain" || head.Metadata["source-key"] != "x" {
+		t.Errorf("unexpected head: etag %s, length %d, type %s, metadata %v",
+			aws.ToString(head.ETag), aws.ToInt64(head.ContentLength), aws.ToString(head.ContentType), head.Metadata)
+	}
+
+	get, err := client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket:  aws.String(bucket),
+		Key:     aws.String("uploads/a.txt"),
+		IfMatch: put.ETag,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := io.ReadAll(get.Body)
+	get.Body.Close()
+	if string(data) != "hello" {
+		t.Errorf("got %q, want hello", data)
+	}
+
+	_, err = client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket:  aws.String(bucket),
+		Key:     aws.String("uploads/a.txt"),
+		IfMatch: aws.String(`"other"`),
+	})
+	if err == nil {
+		t.Error("GetObject with a stale If-Match succeeded")
+	}
+}
+
+func TestMissingObject(t *testing.T) {
+	ctx := context.Background()
+	_, client := newClient(t)
+
+	_, err := client.GetObject(ctx, &s3.GetObjectInput{Bucket: aws.String(bucket), Key: aws.String("missing")})
+	var noSuchKey *types.NoSuchKey
+	if !errors.As(err, &noSuchKey) {
+		t.Errorf("GetObject error = %v, want NoSuchKey", err)
+	}
+	_, err = client.HeadObject(ctx, &s3.HeadObjectInput{Bucket: aws.String(bucket), Key: aws.String("missing")})
+	var notFound *types.NotFound
+	if !errors.As(err, &notFound) {
+		t.Errorf("HeadObject error = %v, want NotFound", err)
+	}
+}
+
+func TestListAndDelete(t *testing.T) {
+	ctx := context.Background()
+	srv, client := newClient(t)
+	for _, key := range []string{"thumbnail/100x100/a.png", "thumbnail/200x200/a.png", "thumbnail/b.png", "uploads/a.png"} {
+		if _, err := client.PutObject(ctx, &s3.PutObjectInput{
+			Bucket: aws.String(bucket),
+			Key:    aws.String(key),
+			Body:   bytes.NewReader([]byte(key)),
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	list, err := client.ListObjectsV2(ctx, &s3.ListObjectsV2Input{
+		Bucket:    aws.String(bucket),
+		Prefix:    aws.String("thumbnail/"),
+		Delimiter: aws.String("/"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var prefixes []string
+	for _, p := range list.CommonPrefixes {
+		prefixes = append(prefixes, aws.ToString(p.Prefix))
+	}
+	if len(prefixes) != 2 || prefixes[0] != "thumbnail/100x100/" || prefixes[1] != "thumbnail/200x200/" {
+		t.Errorf("prefixes = %v", prefixes)
+	}
+	if len(list.Contents) != 1 || aws.ToString(list.Contents[0].Key) != "thumbnail/b.png" {
+		t.Errorf("contents = %v", list.Contents)
+	}
+
+	_, err = client.DeleteObjects(ctx, &s3.DeleteObjectsInput{
+		Bucket: aws.String(bucket),
+		Delete: &types.Delete{
+			Objects: []types.ObjectIdentifier{
+				{Key: aws.String("thumbnail/100x100/a.png")},
+				{Key: aws.String("thumbnail/200x200/a.png")},
+			},
+			Quiet: aws.Bool(true),
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = client.DeleteObject(ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String("thumbnail/b.png"),
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if keys := srv.Keys(bucket, ""); len(keys) != 1 || keys[0] != "uploads/a.png" {
+		t.Errorf("keys after delete = %v", keys)
+	}
+}
+
+func TestMultipartUpload(t *testing.T) {
+	ctx := context.Background()
+	srv, client := newClient(t)
+	data := bytes.Repeat([]byte("0123456789abcdef"), int(2*manager.MinUploadPartSize+100)/16)
+
+	uploader := manager.NewUploader(client, func(u *manager.Uploader) {
+		u.PartSize = manager.MinUploadPartSize
+	})
+	out, err := uploader.Upload(ctx, &s3.PutObjectInput{
+		Bucket:      aws.String(bucket),
+		Key:         aws.String("uploads/big.bin"),
+		Body:        bytes.NewReader(data),
+		ContentType: aws.String("application/octet-stream"),
+		Metadata:    map[string]string{"generated-by": "test"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out.UploadID == "" {
+		t.Fatal("the upload was not multipart")
+	}
+	o, ok := srv.Object(bucket, "uploads/big.bin")
+	if !ok {
+		t.Fatal("object was not stored")
+	}
+	if !bytes.Equal(o.Data, data) {
+		t.Errorf("stored %d bytes, want %d", len(o.Data), len(data))
+	}
+	if o.Metadata["generated-by"] != "test" || o.ContentType != "application/octet-stream" {
+		t.Errorf("unexpected metadata %v and content type %s", o.Metadata, o.ContentType)
+	}
+}
+
+func TestPresignedUpload(t *testing.T) {
+	srv, client := newClient(t)
+	srv.SecretKeys = map[string]string{"key": "secret"}
+	presigned, err := s3.NewPresignClient(client).PresignPutObject(context.Background(), &s3.PutObjectInput{
+		Bucket:        aws.String(bucket),
+		Key:           aws.String("uploads/a photo.png"),
+		ContentType:   aws.String("image/png"),
+		ContentLength: aws.Int64(5),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	put := func(body, contentType string) int {
+		t.Helper()
+		req, err := http.NewRequest(presigned.Method, presigned.URL, strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Content-Type", contentType)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	if status := put("hello", "text/html"); status != http.StatusForbidden {
+		t.Errorf("an upload of another content type got status %d", status)
+	}
+	if status := put("hello world", "image/png"); status != http.StatusForbidden {
+		t.Errorf("an upload of another size got status %d", status)
+	}
+	if _, ok := srv.Object(bucket, "uploads/a photo.png"); ok {
+		t.Fatal("a rejected upload is stored")
+	}
+	if status := put("hello", "image/png"); status != http.StatusOK {
+		t.Fatalf("the signed upload got status %d", status)
+	}
+	if o, ok := srv.Object(bucket, "uploads/a photo.png"); !ok || string(o.Data) != "hello" {
+		t.Errorf("unexpected uploaded object %+v", o)
+	}
+}
+
+func TestTriggerPayload(t *testing.T) {
+	ctx := context.Background()
+	srv, client := newClient(t)
+	for _, key := range []string{"uploads/a.png", "thumbnail/100x100/a.png", "uploads/a.png"} {
+		if _, err := client.PutObject(ctx, &s3.PutObjectInput{
+			Bucket: aws.String(bucket),
+			Key:    aws.String(key),
+			Body:   bytes.NewReader([]byte(key)),
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := client.DeleteObject(ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String("uploads/a.png"),
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := DefaultTrigger.Payload(srv.Events())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var p payload
+	if err = json.Unmarshal(data, &p); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{EventCreate, EventUpdate, EventDelete}
+	if len(p.Messages) != len(want) {
+		t.Fatalf("got %d messages, want %d: %s", len(p.Messages), len(want), data)
+	}
+	for i, m := range p.Messages {
+		if m.EventMetadata.EventType != want[i] || m.Details.BucketID != bucket || m.Details.ObjectID != "uploads/a.png" {
+			t.Errorf("message %d = %+v", i, m)
+		}
+	}
+	if events := srv.Events(); len(events) != 0 {
+		t.Errorf("events were not drained: %v", events)
+	}
+}
@@ -0,0 +1,105 @@
+package s3local
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// signatureError is why the server rejects a presigned request, with the S3 error code.
+type signatureError struct {
+	code    string
+	message string
+}
+
+// checkPresigned verifies the Signature Version 4 of a presigned URL, as S3 does: the request must send
+// the signed headers with the values they were signed with, before the URL expires.
+// Content-Length is taken from the length of the body the request sends.
+func (s *Server) checkPresigned(r *http.Request) *signatureError {
+	query := r.URL.Query()
+	if query.Get("X-Amz-Algorithm") != "AWS4-HMAC-SHA256" {
+		return &signatureError{"AuthorizationQueryParametersError", "unsupported algorithm " + query.Get("X-Amz-Algorithm")}
+	}
+	accessKey, scope, _ := strings.Cut(query.Get("X-Amz-Credential"), "/")
+	secretKey, ok := s.SecretKeys[accessKey]
+	if !ok {
+		return &signatureError{"InvalidAccessKeyId", "the access key " + accessKey + " does not exist"}
+	}
+	scopeParts := strings.Split(scope, "/")
+	if len(scopeParts) != 4 || scopeParts[3] != "aws4_request" {
+		return &signatureError{"AuthorizationQueryParametersError", "invalid credential scope " + scope}
+	}
+	signedAt, err := time.Parse("20060102T150405Z", query.Get("X-Amz-Date"))
+	if err != nil {
+		return &signatureError{"AuthorizationQueryParametersError", "invalid X-Amz-Date"}
+	}
+	expires, err := strconv.Atoi(query.Get("X-Amz-Expires"))
+	if err != nil {
+		return &signatureError{"AuthorizationQueryParametersError", "invalid X-Amz-Expires"}
+	}
+	if time.Now().After(signedAt.Add(time.Duration(expires) * time.Second)) {
+		return &signatureError{"AccessDenied", "Request has expired"}
+	}
+
+	signedHeaders := query.Get("X-Amz-SignedHeaders")
+	var headers strings.Builder
+	for _, name := range strings.Split(signedHeaders, ";") {
+		var value string
+		switch name {
+		case "host":
+			value = r.Host
+		case "content-length":
+			value = strconv.FormatInt(r.ContentLength, 10)
+		default:
+			value = strings.Join(r.Header.Values(name), ",")
+		}
+		headers.WriteString(name + ":" + strings.TrimSpace(value) + "\n")
+	}
+	payloadHash := query.Get("X-Amz-Content-Sha256")
+	if payloadHash == "" {
+		payloadHash = "UNSIGNED-PAYLOAD"
+	}
+	unsigned := url.Values{}
+	for name, values := range query {
+		if name != "X-Amz-Signature" {
+			unsigned[name] = values
+		}
+	}
+	canonicalRequest := strings.Join([]string{
+		r.Method,
+		r.URL.EscapedPath(),
+		strings.ReplaceAll(unsigned.Encode(), "+", "%20"),
+		headers.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := strings.Join([]string{
+		"AWS4-HMAC-SHA256",
+		query.Get("X-Amz-Date"),
+		scope,
+		hex.EncodeToString(requestHash[:]),
+	}, "\n")
+	key := []byte("AWS4" + secretKey)
+	for _, part := range scopeParts {
+		key = hmacSHA256(key, part)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+	if !hmac.Equal([]byte(signature), []byte(query.Get("X-Amz-Signature"))) {
+		return &signatureError{"SignatureDoesNotMatch",
+			"The request signature we calculated does not match the signature you provided"}
+	}
+	return nil
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
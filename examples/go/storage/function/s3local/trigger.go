@@ -0,0 +1,122 @@
+package s3local
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Event types recorded by the server, as sent by the object storage trigger.
+const (
+	EventCreate = "yandex.cloud.events.storage.ObjectCreate"
+	EventUpdate = "yandex.cloud.events.storage.ObjectUpdate"
+	EventDelete = "yandex.cloud.events.storage.ObjectDelete"
+)
+
+// Event is a change of an object in the server.
+type Event struct {
+	ID        string
+	Type      string // One of EventCreate, EventUpdate and EventDelete.
+	Bucket    string
+	Key       string
+	CreatedAt time.Time
+}
+
+// record appends the event. The caller holds the lock.
+func (s *Server) record(eventType, bucket, key string) {
+	s.nextID++
+	s.events = append(s.events, Event{
+		ID:        strconv.Itoa(s.nextID),
+		Type:      eventType,
+		Bucket:    bucket,
+		Key:       key,
+		CreatedAt: time.Now().UTC(),
+	})
+}
+
+// Events returns and forgets all events recorded since the previous call.
+func (s *Server) Events() []Event {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	events := s.events
+	s.events = nil
+	return events
+}
+
+// Trigger turns events into trigger payloads, the same way the object storage trigger configured
+// in tf/main.tf does: only events of objects under Prefix are delivered, and Create, Update and Delete
+// select the event types.
+type Trigger struct {
+	Prefix string
+	Create bool
+	Update bool
+	Delete bool
+}
+
+// DefaultTrigger matches the trigger of the example: all events of objects under uploads/.
+var DefaultTrigger = Trigger{Prefix: "uploads/", Create: true, Update: true, Delete: true}
+
+type payload struct {
+	Messages []message `json:"messages"`
+}
+
+type message struct {
+	EventMetadata eventMetadata  `json:"event_metadata"`
+	Details       messageDetails `json:"details"`
+}
+
+type eventMetadata struct {
+	EventID        string         `json:"event_id"`
+	EventType      string         `json:"event_type"`
+	CreatedAt      string         `json:"created_at"`
+	CloudID        string         `json:"cloud_id"`
+	FolderID       string         `json:"folder_id"`
+	TracingContext map[string]any `json:"tracing_context"`
+}
+
+type messageDetails struct {
+	BucketID string `json:"bucket_id"`
+	ObjectID string `json:"object_id"`
+}
+
+// Payload returns the JSON payload of a single trigger invocation for the matching events,
+// or nil if none match. It can be unmarshalled into the event type of the handler.
+func (t Trigger) Payload(events []Event) ([]byte, error) {
+	var p payload
+	for _, e := range events {
+		if !t.matches(e) {
+			continue
+		}
+		p.Messages = append(p.Messages, message{
+			EventMetadata: eventMetadata{
+				EventID:        e.ID,
+				EventType:      e.Type,
+				CreatedAt:      e.CreatedAt.Format(time.RFC3339Nano),
+				CloudID:        "local",
+				FolderID:       "local",
+				TracingContext: map[string]any{},
+			},
+			Details: messageDetails{BucketID: e.Bucket, ObjectID: e.Key},
+		})
+	}
+	if len(p.Messages) == 0 {
+		return nil, nil
+	}
+	return json.Marshal(p)
+}
+
+func (t Trigger) matches(e Event) bool {
+	if !strings.HasPrefix(e.Key, t.Prefix) {
+		return false
+	}
+	switch e.Type {
+	case EventCreate:
+		return t.Create
+	case EventUpdate:
+		return t.Update
+	case EventDelete:
+		return t.Delete
+	}
+	return false
+}
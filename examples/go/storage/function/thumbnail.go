@@ -1,31 +1,89 @@
 package main
 
 import (
-	"io"
+	"fmt"
 
 	"github.com/davidbyttow/govips/v2/vips"
 )
 
-const (
-	width  = 100
-	height = 100
-)
+// Output is a thumbnail generated for a rendition.
+type Output struct {
+	Data    []byte  // The encoded thumbnail.
+	Sidecar Sidecar // The description of the thumbnail, the caller fills in the source and the content type.
+}
 
-func Thumbnail(input io.Reader, output io.WriteCloser) error {
-	image, err := vips.NewImageFromReader(input)
+// Thumbnail creates a thumbnail of the source image file as described by the rendition.
+// The image is decoded with shrink-on-load: JPEG, WebP and other formats that support it are decoded
+// directly at a reduced size, and the full-size image is never held in memory.
+// The thumbnail is rotated upright according to the EXIF orientation of the source before it is cropped:
+// vips applies the orientation while making the thumbnail.
+func Thumbnail(source string, rendition Rendition) (*Output, error) {
+	image, err := vips.LoadThumbnailFromFile(
+		source, rendition.Width, rendition.Height, rendition.Crop.Interesting(), vips.SizeBoth, nil,
+	)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	if err = image.Thumbnail(width, height, vips.InterestingCentre); err != nil {
-		return err
+	defer image.Close()
+	if rendition.StripMetadata {
+		// Removes EXIF (including GPS), XMP and IPTC, but keeps the ICC profile, so colors are not changed.
+		if err = image.RemoveMetadata(); err != nil {
+			return nil, err
+		}
+	}
+	data, err := export(image, rendition)
+	if err != nil {
+		return nil, err
 	}
-	data, _, err := image.ExportNative()
+	dominantColor, hash, err := placeholder(image)
 	if err != nil {
-		return err
+		return nil, fmt.Errorf("failed to compute placeholder: %w", err)
 	}
-	defer output.Close()
-	if _, err = output.Write(data); err != nil {
-		return err
+	format := string(rendition.Format)
+	if rendition.Format == FormatNative {
+		format = formatName(image.Format())
+	}
+	return &Output{
+		Data: data,
+		Sidecar: Sidecar{
+			Width:         image.Width(),
+			Height:        image.Height(),
+			Format:        format,
+			DominantColor: dominantColor,
+			BlurHash:      hash,
+		},
+	}, nil
+}
+
+// export encodes the image in the format requested by the rendition.
+func export(image *vips.ImageRef, rendition Rendition) ([]byte, error) {
+	var (
+		data []byte
+		err  error
+	)
+	switch rendition.Format {
+	case FormatNative:
+		data, _, err = image.ExportNative()
+	case FormatJPEG:
+		params := vips.NewJpegExportParams()
+		if rendition.Quality > 0 {
+			params.Quality = rendition.Quality
+		}
+		data, _, err = image.ExportJpeg(params)
+	case FormatWebP:
+		params := vips.NewWebpExportParams()
+		if rendition.Quality > 0 {
+			params.Quality = rendition.Quality
+		}
+		data, _, err = image.ExportWebp(params)
+	case FormatAVIF:
+		params := vips.NewAvifExportParams()
+		if rendition.Quality > 0 {
+			params.Quality = rendition.Quality
+		}
+		data, _, err = image.ExportAvif(params)
+	default:
+		return nil, fmt.Errorf("unsupported format %q", rendition.Format)
 	}
-	return nil
+	return data, err
 }
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"image/jpeg"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/davidbyttow/govips/v2/vips"
+)
+
+// benchmarkSizes are the source images of the benchmarks, from a phone photo to a large camera shot.
+var benchmarkSizes = []struct {
+	name          string
+	width, height int
+}{
+	{"12MP", 4000, 3000},
+	{"24MP", 6000, 4000},
+	{"48MP", 8000, 6000},
+}
+
+var benchmarkRendition = Rendition{Width: 200, Height: 200, Crop: CropCentre, Format: FormatJPEG}
+
+// BenchmarkThumbnail measures the shrink-on-load pipeline used by the handler.
+// The vips-peak-MiB metric is the high-water mark of the memory allocated by libvips in the process,
+// so run a single size at a time to get its own peak:
+//
+//	go test -run '^$' -bench 'BenchmarkThumbnail/24MP' -benchmem
+func BenchmarkThumbnail(b *testing.B) {
+	for _, size := range benchmarkSizes {
+		b.Run(size.name, func(b *testing.B) {
+			source := writeJPEG(b, size.width, size.height)
+			b.ReportAllocs()
+			b.ResetTimer()
+			for range b.N {
+				if _, err := Thumbnail(source, benchmarkRendition); err != nil {
+					b.Fatal(err)
+				}
+			}
+			reportVipsPeak(b)
+		})
+	}
+}
+
+// BenchmarkThumbnailFullDecode measures decoding the full-size image before resizing it,
+// which is what the handler did before, to compare the peak memory with BenchmarkThumbnail:
+//
+//	go test -run '^$' -bench 'BenchmarkThumbnailFullDecode/24MP' -benchmem
+func BenchmarkThumbnailFullDecode(b *testing.B) {
+	for _, size := range benchmarkSizes {
+		b.Run(size.name, func(b *testing.B) {
+			source := writeJPEG(b, size.width, size.height)
+			b.ReportAllocs()
+			b.ResetTimer()
+			for range b.N {
+				data, err := os.ReadFile(source)
+				if err != nil {
+					b.Fatal(err)
+				}
+				img, err := vips.NewImageFromBuffer(data)
+				if err != nil {
+					b.Fatal(err)
+				}
+				err = img.Thumbnail(benchmarkRendition.Width, benchmarkRendition.Height, benchmarkRendition.Crop.Interesting())
+				if err == nil {
+					_, err = export(img, benchmarkRendition)
+				}
+				img.Close()
+				if err != nil {
+					b.Fatal(err)
+				}
+			}
+			reportVipsPeak(b)
+		})
+	}
+}
+
+// writeJPEG writes a gradient JPEG of the given size to a temporary file.
+func writeJPEG(b *testing.B, width, height int) string {
+	b.Helper()
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := range height {
+		for x := range width {
+			img.Set(x, y, color.RGBA{R: uint8(x), G: uint8(y), B: uint8(x + y), A: 255})
+		}
+	}
+	name := filepath.Join(b.TempDir(), "source.jpg")
+	file, err := os.Create(name)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer file.Close()
+	if err = jpeg.Encode(file, img, &jpeg.Options{Quality: 90}); err != nil {
+		b.Fatal(err)
+	}
+	return name
+}
+
+func reportVipsPeak(b *testing.B) {
+	var stats vips.MemoryStats
+	vips.ReadVipsMemStats(&stats)
+	b.ReportMetric(float64(stats.MemHigh)/(1<<20), "vips-peak-MiB")
+}
@@ -26,8 +26,9 @@ resource "yandex_function" "storage-handler" {
   user_hash         = data.archive_file.function_code.output_sha
   runtime           = "golang123"
   entrypoint        = "handler.Handler"
-  memory            = "128"
-  execution_timeout = "10"
+  # A trigger batch of PDFs or videos up to THUMBNAIL_MAX_SOURCE_BYTES is rendered by ffmpeg and libvips
+  memory            = var.thumbnail_memory
+  execution_timeout = var.thumbnail_timeout
   package {
     bucket_name = yandex_storage_bucket.for-deploy.bucket
     object_name = "function.zip"
@@ -37,8 +38,37 @@ resource "yandex_function" "storage-handler" {
   environment        = {
     # The trigger will provide the name of the bucket and object key, but not actual content of the object
     # So we need to get the content of the object ourselves
+    "AWS_ACCESS_KEY_ID"        = yandex_iam_service_account_static_access_key.sa_storage_editor.access_key
+    "AWS_SECRET_ACCESS_KEY"    = yandex_iam_service_account_static_access_key.sa_storage_editor.secret_key
+    "THUMBNAIL_RENDITIONS"     = var.thumbnail_renditions
+    "THUMBNAIL_CONFIG_KEY"     = var.thumbnail_config_key
+    "THUMBNAIL_STRIP_METADATA" = tostring(var.thumbnail_strip_metadata)
+    "FFMPEG_PATH"              = "/function/code/bin/ffmpeg"
+  }
+  depends_on = [
+    yandex_storage_object.function_code
+  ]
+}
+
+resource "yandex_function" "presign" {
+  name              = "storage-presign"
+  user_hash         = data.archive_file.function_code.output_sha
+  runtime           = "golang123"
+  entrypoint        = "handler.Presign"
+  memory            = "128"
+  execution_timeout = "5"
+  package {
+    bucket_name = yandex_storage_bucket.for-deploy.bucket
+    object_name = "function.zip"
+  }
+  service_account_id = yandex_iam_service_account.sa_storage_editor.id
+  environment        = {
+    # Presigned URLs are signed with the static key, so they carry its permissions
     "AWS_ACCESS_KEY_ID"     = yandex_iam_service_account_static_access_key.sa_storage_editor.access_key
     "AWS_SECRET_ACCESS_KEY" = yandex_iam_service_account_static_access_key.sa_storage_editor.secret_key
+    "UPLOAD_BUCKET"         = yandex_storage_bucket.for-uploads.bucket
+    "PRESIGN_EXPIRY"        = var.presign_expiry
+    "PRESIGN_CONTENT_TYPES" = var.presign_content_types
   }
   depends_on = [
     yandex_storage_object.function_code
@@ -54,6 +84,7 @@ resource "yandex_function_trigger" "storage-trigger" {
     batch_cutoff = 1
     create       = true
     update       = true
+    delete       = true
   }
   function {
     id                 = yandex_function.storage-handler.id
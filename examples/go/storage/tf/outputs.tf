@@ -1,6 +1,9 @@
 output "bucket" {
   value = yandex_storage_bucket.for-uploads.bucket
 }
+output "presign_function_id" {
+  value = yandex_function.presign.id
+}
 output "bucket_for_function" {
   value = yandex_storage_bucket.for-deploy.bucket
 }
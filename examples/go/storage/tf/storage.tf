@@ -8,6 +8,15 @@ resource "yandex_storage_bucket" "for-uploads" {
   access_key = yandex_iam_service_account_static_access_key.sa_storage_editor.access_key
   secret_key = yandex_iam_service_account_static_access_key.sa_storage_editor.secret_key
   bucket     = random_uuid.upload-bucket-name.result
+
+  # Browsers upload with presigned URLs directly to the bucket, so it has to allow cross-origin PUT requests.
+  cors_rule {
+    allowed_methods = ["PUT"]
+    allowed_origins = var.upload_allowed_origins
+    allowed_headers = ["*"]
+    expose_headers  = ["ETag"]
+    max_age_seconds = 3600
+  }
   depends_on = [
     yandex_iam_service_account.sa_storage_editor,
     yandex_iam_service_account_static_access_key.sa_storage_editor,
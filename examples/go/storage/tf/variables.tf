@@ -11,3 +11,51 @@ variable "zone" {
   default = "ru-central1-a"
 }
 
+
+variable "thumbnail_renditions" {
+  description = "Comma-separated renditions in the form WIDTHxHEIGHT[:crop[:format[:quality]]]"
+  type        = string
+  default     = "100x100:centre"
+}
+
+variable "thumbnail_config_key" {
+  description = "Key of an optional JSON object in the uploads bucket that overrides thumbnail_renditions"
+  type        = string
+  default     = "config/thumbnails.json"
+}
+
+variable "thumbnail_strip_metadata" {
+  description = "Remove EXIF (including GPS), XMP and IPTC metadata from thumbnails"
+  type        = bool
+  default     = false
+}
+
+variable "presign_expiry" {
+  description = "Lifetime of presigned upload URLs, e.g. 15m"
+  type        = string
+  default     = "15m"
+}
+
+variable "presign_content_types" {
+  description = "Comma-separated content types browsers may upload, type/* allows the whole type"
+  type        = string
+  default     = "image/*,application/pdf,video/*"
+}
+
+variable "upload_allowed_origins" {
+  description = "Origins of the web pages allowed to upload to the bucket with presigned URLs"
+  type        = list(string)
+  default     = ["*"]
+}
+
+variable "thumbnail_memory" {
+  description = "Memory of the thumbnail function in MB, enough for THUMBNAIL_CONCURRENCY sources of THUMBNAIL_MAX_SOURCE_BYTES each"
+  type        = number
+  default     = 1024
+}
+
+variable "thumbnail_timeout" {
+  description = "Execution timeout of the thumbnail function in seconds, enough to download and decode the largest source"
+  type        = number
+  default     = 60
+}
@@ -0,0 +1,335 @@
+// Package cache keeps the values of a slower source, such as a database, in Redis.
+//
+// A Cache reads through: a value missing from Redis is loaded from the source and cached for the TTL, and keys
+// the source does not have are cached too, for a shorter time. A value set through the cache is written through:
+// it is stored in the source and then cached, so the next read does not load it. Concurrent loads of a key by
+// the same instance are merged into one, and a value close to its expiry is loaded again early by a random
+// request, so the instances do not all load it at once when it expires.
+//
+// A load that reads the source before a change and writes Redis after its invalidation would cache the old value
+// for the whole TTL. So every key has a version in Redis, incremented by the sets and invalidations, and a load
+// or a set caches its value only if no other one has incremented the version since it started.
+package cache
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"math/rand"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"golang.org/x/sync/singleflight"
+)
+
+const (
+	// DefaultTTL is how long a value is cached.
+	DefaultTTL = 5 * time.Minute
+	// DefaultNegativeTTL is how long a key the source does not have is cached as missing.
+	DefaultNegativeTTL = 30 * time.Second
+	// DefaultBeta scales how early the values are loaded again before they expire.
+	DefaultBeta = 1.0
+	// versionTTL is how long the version of a key is kept after its last invalidation. It has to outlast
+	// the loads, which cache nothing if the version has expired since they started.
+	versionTTL = 24 * time.Hour
+)
+
+var (
+	// invalidate deletes the value at KEYS[1] and increments its version at KEYS[2], kept for ARGV[1] milliseconds.
+	invalidate = redis.NewScript(`
+redis.call('DEL', KEYS[1])
+redis.call('INCR', KEYS[2])
+redis.call('PEXPIRE', KEYS[2], ARGV[1])
+return 1
+`)
+
+	// bump increments the version at KEYS[1], kept for ARGV[1] milliseconds, and returns it.
+	bump = redis.NewScript(`
+local version = redis.call('INCR', KEYS[1])
+redis.call('PEXPIRE', KEYS[1], ARGV[1])
+return version
+`)
+
+	// writeThrough increments the version at KEYS[2], kept for ARGV[4] milliseconds. If the version was still
+	// ARGV[1], it sets KEYS[1] to ARGV[2] for ARGV[3] milliseconds, and otherwise deletes it.
+	writeThrough = redis.NewScript(`
+local version = redis.call('INCR', KEYS[2])
+redis.call('PEXPIRE', KEYS[2], ARGV[4])
+if version ~= tonumber(ARGV[1]) + 1 then
+  redis.call('DEL', KEYS[1])
+  return 0
+end
+redis.call('SET', KEYS[1], ARGV[2], 'PX', ARGV[3])
+return 1
+`)
+
+	// writeIfVersion sets KEYS[1] to ARGV[2] for ARGV[3] milliseconds if the version at KEYS[2] is still ARGV[1].
+	writeIfVersion = redis.NewScript(`
+if (redis.call('GET', KEYS[2]) or '0') ~= ARGV[1] then
+  return 0
+end
+redis.call('SET', KEYS[1], ARGV[2], 'PX', ARGV[3])
+return 1
+`)
+)
+
+// ErrNotFound is returned by Load when the source has no value for the key, and by Get then.
+var ErrNotFound = errors.New("not found")
+
+// Options configure a cache.
+type Options[K comparable, V any] struct {
+	// Redis keeps the values. If nil, every Get loads the value, still merging the concurrent loads.
+	Redis redis.Cmdable
+	// Prefix is prepended to the keys in Redis, so caches of different values do not collide.
+	Prefix string
+	// Key formats a key for Redis, fmt.Sprint by default. The version of a key is kept at the key with
+	// the ":version" suffix, so on a sharded cluster the key needs a hash tag, such as "{42}".
+	Key func(key K) string
+
+	// TTL is how long a value is cached, DefaultTTL if zero.
+	TTL time.Duration
+	// NegativeTTL is how long a missing key is cached, DefaultNegativeTTL if zero. Negative disables it.
+	NegativeTTL time.Duration
+	// Beta scales how early a value is loaded again, DefaultBeta if zero. Negative disables the early loads.
+	Beta float64
+
+	// Load reads the value from the source. It returns ErrNotFound if there is none.
+	Load func(ctx context.Context, key K) (V, error)
+	// Store writes the value to the source. Set fails without it.
+	Store func(ctx context.Context, key K, value V) error
+}
+
+// entry is a value as it is kept in Redis.
+type entry[V any] struct {
+	Value   V    `json:"value"`
+	Missing bool `json:"missing,omitempty"`
+	// Delta is how long the value took to load, in milliseconds: the longer, the earlier it is loaded again.
+	Delta int64 `json:"delta"`
+	// Expires is when the value expires, in Unix milliseconds.
+	Expires int64 `json:"expires"`
+}
+
+// Cache is a read-through cache of the values of type V by keys of type K.
+// It is safe for concurrent use.
+type Cache[K comparable, V any] struct {
+	opts  Options[K, V]
+	loads singleflight.Group
+
+	// now and random are replaced by tests
+	now    func() time.Time
+	random func() float64
+}
+
+// New creates a cache with the options. Load is required.
+func New[K comparable, V any](opts Options[K, V]) *Cache[K, V] {
+	if opts.Load == nil {
+		panic("cache: Load is required")
+	}
+	if opts.Key == nil {
+		opts.Key = func(key K) string { return fmt.Sprint(key) }
+	}
+	if opts.TTL == 0 {
+		opts.TTL = DefaultTTL
+	}
+	if opts.NegativeTTL == 0 {
+		opts.NegativeTTL = DefaultNegativeTTL
+	}
+	if opts.Beta == 0 {
+		opts.Beta = DefaultBeta
+	}
+	return &Cache[K, V]{opts: opts, now: time.Now, random: rand.Float64}
+}
+
+// Get returns the value of the key, from Redis if it is cached there, or from the source otherwise.
+// It returns ErrNotFound if the source has no value for the key.
+//
+// Redis failing is not an error: the value is loaded from the source then.
+func (c *Cache[K, V]) Get(ctx context.Context, key K) (V, error) {
+	redisKey := c.redisKey(key)
+	cached, ok := c.read(ctx, redisKey)
+	if ok && !c.refreshEarly(cached) {
+		return cached.result()
+	}
+
+	loaded, err := c.load(ctx, key, redisKey)
+	if err != nil {
+		if ok {
+			// The cached value has not expired yet, it is still good
+			log.Printf("cache: failed to refresh %s early: %v", redisKey, err)
+			return cached.result()
+		}
+		var zero V
+		return zero, err
+	}
+	return loaded.result()
+}
+
+// Set stores the value in the source and then caches it.
+//
+// The version of the key is incremented before the value is stored and again after, so a load that read the source
+// before the store does not cache its older value. The value is cached only if no other Set or invalidation has
+// incremented the version in between; otherwise the key is removed from Redis, so the next Get loads whatever
+// value was stored last.
+func (c *Cache[K, V]) Set(ctx context.Context, key K, value V) error {
+	if c.opts.Store == nil {
+		return errors.New("cache: Store is not set")
+	}
+	redisKey := c.redisKey(key)
+	if c.opts.Redis == nil {
+		if err := c.opts.Store(ctx, key, value); err != nil {
+			return err
+		}
+		c.loads.Forget(redisKey)
+		return nil
+	}
+
+	versionKeys := []string{redisKey + ":version"}
+	version, err := bump.Run(ctx, c.opts.Redis, versionKeys, versionTTL.Milliseconds()).Int64()
+	if err != nil {
+		return fmt.Errorf("cache: failed to invalidate %s: %w", redisKey, err)
+	}
+	if err = c.opts.Store(ctx, key, value); err != nil {
+		return err
+	}
+	c.loads.Forget(redisKey)
+
+	data, err := json.Marshal(entry[V]{Value: value, Expires: c.now().Add(c.opts.TTL).UnixMilli()})
+	if err != nil {
+		return fmt.Errorf("cache: failed to encode %s: %w", redisKey, err)
+	}
+	err = writeThrough.Run(ctx, c.opts.Redis, []string{redisKey, versionKeys[0]},
+		version, data, c.opts.TTL.Milliseconds(), versionTTL.Milliseconds()).Err()
+	if err != nil {
+		return fmt.Errorf("cache: failed to write %s: %w", redisKey, err)
+	}
+	return nil
+}
+
+// Invalidate removes the keys from Redis, so the next Get loads them from the source. The loads of the keys
+// in progress do not cache their values, and the next Gets of this instance do not wait for them.
+func (c *Cache[K, V]) Invalidate(ctx context.Context, keys ...K) error {
+	for _, key := range keys {
+		redisKey := c.redisKey(key)
+		c.loads.Forget(redisKey)
+		if c.opts.Redis == nil {
+			continue
+		}
+		err := invalidate.Run(ctx, c.opts.Redis, []string{redisKey, redisKey + ":version"},
+			versionTTL.Milliseconds()).Err()
+		if err != nil {
+			return fmt.Errorf("cache: failed to invalidate %s: %w", redisKey, err)
+		}
+	}
+	return nil
+}
+
+func (c *Cache[K, V]) redisKey(key K) string {
+	return c.opts.Prefix + c.opts.Key(key)
+}
+
+// read returns the entry of the key cached in Redis, if there is one.
+func (c *Cache[K, V]) read(ctx context.Context, redisKey string) (entry[V], bool) {
+	var cached entry[V]
+	if c.opts.Redis == nil {
+		return cached, false
+	}
+	data, err := c.opts.Redis.Get(ctx, redisKey).Bytes()
+	if err != nil {
+		if !errors.Is(err, redis.Nil) {
+			log.Printf("cache: failed to read %s: %v", redisKey, err)
+		}
+		return cached, false
+	}
+	if err := json.Unmarshal(data, &cached); err != nil {
+		log.Printf("cache: failed to decode %s: %v", redisKey, err)
+		return cached, false
+	}
+	return cached, true
+}
+
+// refreshEarly decides whether this request loads the cached value again before it expires.
+// The chance grows as the expiry comes closer and with the time the value took to load, so usually a single
+// request loads it in time, see "Optimal Probabilistic Cache Stampede Prevention" by Vattani et al.
+func (c *Cache[K, V]) refreshEarly(cached entry[V]) bool {
+	if c.opts.Beta < 0 || cached.Missing {
+		return false
+	}
+	delta := float64(cached.Delta) * c.opts.Beta * -math.Log(c.random())
+	return float64(c.now().UnixMilli())+delta >= float64(cached.Expires)
+}
+
+// load loads the value from the source and caches it, unless the key is invalidated meanwhile. The concurrent
+// loads of the key share a single one, which is not canceled if one of the callers gives up.
+func (c *Cache[K, V]) load(ctx context.Context, key K, redisKey string) (entry[V], error) {
+	loads := c.loads.DoChan(redisKey, func() (any, error) {
+		ctx := context.WithoutCancel(ctx)
+		// The version is read before the source, so an invalidation after the read changes it
+		version, versionOK := c.version(ctx, redisKey)
+		start := time.Now()
+		value, err := c.opts.Load(ctx, key)
+		loaded := entry[V]{Value: value, Delta: time.Since(start).Milliseconds()}
+		ttl := c.opts.TTL
+		if errors.Is(err, ErrNotFound) && c.opts.NegativeTTL > 0 {
+			loaded.Missing = true
+			ttl = c.opts.NegativeTTL
+		} else if err != nil {
+			return nil, err
+		}
+		if versionOK {
+			c.write(ctx, redisKey, version, loaded, ttl)
+		}
+		return loaded, nil
+	})
+	select {
+	case res := <-loads:
+		if res.Err != nil {
+			return entry[V]{}, res.Err
+		}
+		return res.Val.(entry[V]), nil
+	case <-ctx.Done():
+		return entry[V]{}, ctx.Err()
+	}
+}
+
+// version returns the version of the key in Redis, "0" if it has never been invalidated, and false if it
+// cannot be read, so the loaded value is not cached.
+func (c *Cache[K, V]) version(ctx context.Context, redisKey string) (string, bool) {
+	if c.opts.Redis == nil {
+		return "", false
+	}
+	version, err := c.opts.Redis.Get(ctx, redisKey+":version").Result()
+	if errors.Is(err, redis.Nil) {
+		return "0", true
+	}
+	if err != nil {
+		log.Printf("cache: failed to read the version of %s: %v", redisKey, err)
+		return "", false
+	}
+	return version, true
+}
+
+// write caches the entry for the TTL if the key is still at the version. Failures are only logged: the value
+// is loaded from the source next time.
+func (c *Cache[K, V]) write(ctx context.Context, redisKey, version string, cached entry[V], ttl time.Duration) {
+	cached.Expires = c.now().Add(ttl).UnixMilli()
+	data, err := json.Marshal(cached)
+	if err == nil {
+		err = writeIfVersion.Run(ctx, c.opts.Redis, []string{redisKey, redisKey + ":version"},
+			version, data, ttl.Milliseconds()).Err()
+	}
+	if err != nil {
+		log.Printf("cache: failed to write %s: %v", redisKey, err)
+	}
+}
+
+// result returns the value, or ErrNotFound for a missing key.
+func (e entry[V]) result() (V, error) {
+	if e.Missing {
+		var zero V
+		return zero, ErrNotFound
+	}
+	return e.Value, nil
+}
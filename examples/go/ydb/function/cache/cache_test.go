@@ -0,0 +1,283 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+// source is a map standing for the database, counting the loads.
+type source struct {
+	mu     sync.Mutex
+	values map[int]string
+	loads  atomic.Int32
+	// delay is how long a load takes
+	delay time.Duration
+	// hold, if set, is called by every load after it has read the value
+	hold func()
+}
+
+func (s *source) load(_ context.Context, key int) (string, error) {
+	s.loads.Add(1)
+	time.Sleep(s.delay)
+	s.mu.Lock()
+	value, ok := s.values[key]
+	s.mu.Unlock()
+	if s.hold != nil {
+		s.hold()
+	}
+	if !ok {
+		return "", ErrNotFound
+	}
+	return value, nil
+}
+
+func (s *source) store(_ context.Context, key int, value string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.values[key] = value
+	return nil
+}
+
+// clock is the time of the cache, moved together with the time of miniredis.
+type clock struct {
+	mu  sync.Mutex
+	now time.Time
+	mr  *miniredis.Miniredis
+}
+
+func (c *clock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *clock) advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+	c.mr.FastForward(d)
+}
+
+func newCache(t *testing.T, opts Options[int, string]) (*Cache[int, string], *source, *clock) {
+	t.Helper()
+	mr := miniredis.RunT(t)
+	rdb := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { _ = rdb.Close() })
+
+	src := &source{values: map[int]string{1: "Alice", 2: "Bob"}}
+	opts.Redis = rdb
+	opts.Prefix = "users:"
+	opts.Load = src.load
+	opts.Store = src.store
+	c := New(opts)
+	clk := &clock{now: time.Now(), mr: mr}
+	c.now = clk.Now
+	// No early loads unless a test asks for them
+	c.random = func() float64 { return 1 }
+	return c, src, clk
+}
+
+func get(t *testing.T, c *Cache[int, string], key int, want string) {
+	t.Helper()
+	value, err := c.Get(context.Background(), key)
+	if err != nil || value != want {
+		t.Fatalf("Get(%d) = %q, %v, want %q", key, value, err, want)
+	}
+}
+
+func TestReadThrough(t *testing.T) {
+	c, src, clk := newCache(t, Options[int, string]{TTL: time.Minute})
+
+	get(t, c, 1, "Alice")
+	get(t, c, 1, "Alice")
+	if got := src.loads.Load(); got != 1 {
+		t.Errorf("loaded %d times within the TTL", got)
+	}
+
+	clk.advance(time.Minute + time.Second)
+	get(t, c, 1, "Alice")
+	if got := src.loads.Load(); got != 2 {
+		t.Errorf("loaded %d times after the TTL", got)
+	}
+}
+
+func TestNegativeCaching(t *testing.T) {
+	c, src, clk := newCache(t, Options[int, string]{NegativeTTL: 10 * time.Second})
+
+	for range 2 {
+		if _, err := c.Get(context.Background(), 3); !errors.Is(err, ErrNotFound) {
+			t.Fatalf("Get of a missing key returned %v", err)
+		}
+	}
+	if got := src.loads.Load(); got != 1 {
+		t.Errorf("a missing key was loaded %d times", got)
+	}
+
+	_ = src.store(context.Background(), 3, "Charlie")
+	clk.advance(11 * time.Second)
+	get(t, c, 3, "Charlie")
+}
+
+func TestConcurrentLoadsAreMerged(t *testing.T) {
+	c, src, _ := newCache(t, Options[int, string]{})
+	src.delay = 50 * time.Millisecond
+
+	var wg sync.WaitGroup
+	for range 20 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if value, err := c.Get(context.Background(), 1); err != nil || value != "Alice" {
+				t.Errorf("got %q, %v", value, err)
+			}
+		}()
+	}
+	wg.Wait()
+	if got := src.loads.Load(); got != 1 {
+		t.Errorf("concurrent Gets loaded %d times", got)
+	}
+}
+
+func TestEarlyRefresh(t *testing.T) {
+	c, src, clk := newCache(t, Options[int, string]{TTL: time.Minute})
+	src.delay = 10 * time.Millisecond
+	get(t, c, 1, "Alice")
+
+	// Far from the expiry even an unlucky draw does not load the value again
+	c.random = func() float64 { return 1e-9 }
+	get(t, c, 1, "Alice")
+	if got := src.loads.Load(); got != 1 {
+		t.Fatalf("loaded %d times a minute before the expiry", got)
+	}
+
+	// Close to it, it does, and the new value is cached for the whole TTL
+	_ = src.store(context.Background(), 1, "Alicia")
+	clk.advance(time.Minute - 100*time.Millisecond)
+	get(t, c, 1, "Alicia")
+	c.random = func() float64 { return 1 }
+	clk.advance(30 * time.Second)
+	get(t, c, 1, "Alicia")
+	if got := src.loads.Load(); got != 2 {
+		t.Errorf("loaded %d times", got)
+	}
+}
+
+func TestSetAndInvalidate(t *testing.T) {
+	c, src, _ := newCache(t, Options[int, string]{})
+	get(t, c, 2, "Bob")
+
+	if err := c.Set(context.Background(), 2, "Robert"); err != nil {
+		t.Fatal(err)
+	}
+	if src.values[2] != "Robert" {
+		t.Errorf("the source has %q", src.values[2])
+	}
+	// The value is written through, so it is not loaded again
+	get(t, c, 2, "Robert")
+	if got := src.loads.Load(); got != 1 {
+		t.Errorf("loaded %d times, want once before the write", got)
+	}
+
+	// A change the cache did not see is read after the invalidation
+	_ = src.store(context.Background(), 2, "Bobby")
+	if err := c.Invalidate(context.Background(), 2); err != nil {
+		t.Fatal(err)
+	}
+	get(t, c, 2, "Bobby")
+}
+
+func TestLoadDuringChange(t *testing.T) {
+	changes := map[string]struct {
+		change func(c *Cache[int, string], src *source) error
+		// loads is how many times the key is loaded, the stalled load included
+		loads int32
+	}{
+		"set": {func(c *Cache[int, string], _ *source) error {
+			return c.Set(context.Background(), 1, "Alicia")
+		}, 1},
+		"invalidate": {func(c *Cache[int, string], src *source) error {
+			_ = src.store(context.Background(), 1, "Alicia")
+			return c.Invalidate(context.Background(), 1)
+		}, 2},
+	}
+	for name, tc := range changes {
+		t.Run(name, func(t *testing.T) {
+			c, src, _ := newCache(t, Options[int, string]{})
+
+			// The first load reads the old value and stalls until the change is made
+			var held atomic.Bool
+			loaded, resume := make(chan struct{}), make(chan struct{})
+			src.hold = func() {
+				if held.CompareAndSwap(false, true) {
+					close(loaded)
+					<-resume
+				}
+			}
+			stale := make(chan string)
+			go func() {
+				value, _ := c.Get(context.Background(), 1)
+				stale <- value
+			}()
+			<-loaded
+
+			if err := tc.change(c, src); err != nil {
+				t.Fatal(err)
+			}
+			// A Get after the change does not wait for the stalled load
+			get(t, c, 1, "Alicia")
+
+			close(resume)
+			if value := <-stale; value != "Alice" {
+				t.Errorf("the stalled Get returned %q", value)
+			}
+			// and the stalled load does not cache its old value over the new one
+			get(t, c, 1, "Alicia")
+			if got := src.loads.Load(); got != tc.loads {
+				t.Errorf("loaded %d times, want %d", got, tc.loads)
+			}
+		})
+	}
+}
+
+func TestConcurrentSets(t *testing.T) {
+	c, src, _ := newCache(t, Options[int, string]{})
+	ctx := context.Background()
+	get(t, c, 1, "Alice")
+
+	// Another Set stores its value after this one and caches it before
+	first := true
+	c.opts.Store = func(ctx context.Context, key int, value string) error {
+		if err := src.store(ctx, key, value); err != nil {
+			return err
+		}
+		if first {
+			first = false
+			return c.Set(ctx, key, "Alicia")
+		}
+		return nil
+	}
+	if err := c.Set(ctx, 1, "Ali"); err != nil {
+		t.Fatal(err)
+	}
+	// The first Set cannot tell which value was stored last, so it removes the key and the next Get loads it
+	get(t, c, 1, "Alicia")
+	if got := src.loads.Load(); got != 2 {
+		t.Errorf("loaded %d times, want once before and once after the Sets", got)
+	}
+}
+
+func TestRedisDown(t *testing.T) {
+	src := &source{values: map[int]string{1: "Alice"}}
+	rdb := redis.NewClient(&redis.Options{Addr: "127.0.0.1:1", MaxRetries: -1})
+	defer rdb.Close()
+	c := New(Options[int, string]{Redis: rdb, Load: src.load})
+
+	get(t, c, 1, "Alice")
+}
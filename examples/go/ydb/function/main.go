@@ -3,132 +3,159 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"log"
 	"net/http"
 	"os"
+	"strconv"
+	"sync"
+	"time"
 
+	"github.com/redis/go-redis/v9"
 	"github.com/ydb-platform/ydb-go-sdk/v3"
-	"github.com/ydb-platform/ydb-go-sdk/v3/table"              // для работы с table-сервисом
-	"github.com/ydb-platform/ydb-go-sdk/v3/table/result"       // для работы с table-сервисом
-	"github.com/ydb-platform/ydb-go-sdk/v3/table/result/named" // для работы с table-сервисом
-	"github.com/ydb-platform/ydb-go-sdk/v3/table/types"        // для работы с типами YDB и значениями
-	yc "github.com/ydb-platform/ydb-go-yc"                     // для работы с YDB в Яндекс Облаке
+	yc "github.com/ydb-platform/ydb-go-yc" // для работы с YDB в Яндекс Облаке
+
+	"ydb/cache"
 )
 
-//goland:noinspection GoUnusedExportedFunction
-func Handler(rw http.ResponseWriter, _ *http.Request) {
-	ctx := context.Background()
+// defaultUserID is the user returned when the request does not name one.
+const defaultUserID = 3
+
+// userStore is the users table with the cache in front of it.
+type userStore struct {
+	db    *ydb.Driver
+	users *cache.Cache[int32, User]
+}
+
+var (
+	storeMu sync.Mutex
+	// shared is the store of the function instance, shared by its invocations together with its connections
+	// to YDB and Redis. It is nil until a connection succeeds.
+	shared *userStore
+)
+
+// store returns the shared store, connecting it on the first call. A failed connection is not kept:
+// the next invocation tries again.
+func store() (*userStore, error) {
+	storeMu.Lock()
+	defer storeMu.Unlock()
+	if shared != nil {
+		return shared, nil
+	}
+	s, err := openStore(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	shared = s
+	return shared, nil
+}
 
+// openStore connects to YDB and, if REDIS_ADDR is set, to Redis.
+func openStore(ctx context.Context) (*userStore, error) {
 	// Get YDB connection details from environment variables
 	ydbEndpoint := os.Getenv("YDB_ENDPOINT")
 	ydbDatabase := os.Getenv("YDB_DATABASE")
-
 	if ydbEndpoint == "" || ydbDatabase == "" {
-		log.Printf("Error: YDB_ENDPOINT or YDB_DATABASE environment variables not set")
-		rw.WriteHeader(http.StatusInternalServerError)
-		rw.Write([]byte(`{"error": "YDB configuration not found"}`))
-		return
+		return nil, errors.New("YDB_ENDPOINT or YDB_DATABASE environment variables not set")
 	}
 
-	// Construct DSN from environment variables
-	dsn := ydbEndpoint + "?database=" + ydbDatabase
-
 	// создаем объект подключения db, является входной точкой для сервисов YDB
-	db, err := ydb.Open(ctx, dsn,
+	db, err := ydb.Open(ctx, ydbEndpoint+"?database="+ydbDatabase,
 		yc.WithMetadataCredentials(), // аутентификация изнутри виртуальной машины в Яндекс Облаке или из Яндекс Функции
 	)
+	if err != nil {
+		return nil, err
+	}
+
+	opts := cache.Options[int32, User]{
+		Prefix: "users:",
+		TTL:    envDuration("CACHE_TTL", cache.DefaultTTL),
+		Load: func(ctx context.Context, id int32) (User, error) {
+			return loadUser(ctx, db, id)
+		},
+	}
+	// Without Redis every request reads YDB, as before
+	if addr := os.Getenv("REDIS_ADDR"); addr != "" {
+		opts.Redis = redis.NewClient(&redis.Options{
+			Addr:     addr,
+			Username: "default",
+			Password: os.Getenv("REDIS_PASSWORD"),
+		})
+	}
+	return &userStore{db: db, users: cache.New(opts)}, nil
+}
+
+// Handler returns the user by ?id=, 3 by default.
+//
+//goland:noinspection GoUnusedExportedFunction
+func Handler(rw http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	if r.Method != http.MethodGet {
+		rw.Header().Set("Allow", http.MethodGet)
+		writeError(rw, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	s, err := store()
 	if err != nil {
 		log.Printf("Error connecting to YDB: %v", err)
-		rw.WriteHeader(http.StatusInternalServerError)
-		rw.Write([]byte(`{"error": "Failed to connect to YDB"}`))
+		writeError(rw, http.StatusInternalServerError, "Failed to connect to YDB")
 		return
 	}
-	// закрытие драйвера по окончании работы программы обязательно
-	defer db.Close(ctx)
-
-	var (
-		readTx = table.TxControl(
-			table.BeginTx(
-				table.WithOnlineReadOnly(),
-			),
-			table.CommitTx(),
-		)
-	)
-	var user struct {
-		ID   int32   `json:"id"`
-		Name *string `json:"name"` // optional
+
+	id := int32(defaultUserID)
+	if v := r.URL.Query().Get("id"); v != "" {
+		parsed, err := strconv.ParseInt(v, 10, 32)
+		if err != nil {
+			writeError(rw, http.StatusBadRequest, "Invalid id")
+			return
+		}
+		id = int32(parsed)
+	}
+
+	user, err := s.users.Get(ctx, id)
+	if errors.Is(err, cache.ErrNotFound) {
+		writeError(rw, http.StatusNotFound, "User not found")
+		return
 	}
-	err = db.Table().Do(ctx,
-		func(ctx context.Context, s table.Session) (err error) {
-			var (
-				res  result.Result
-				id   int32   // переменная для required результатов
-				name *string // указатель - для опциональных результатов
-			)
-			_, res, err = s.Execute(
-				ctx,
-				readTx,
-				`
-        DECLARE $id AS Int32;
-        SELECT
-          id,
-          name,
-        FROM
-          users
-        WHERE
-          id = $id;
-      `,
-				table.NewQueryParameters(
-					table.ValueParam("$id", types.Int32Value(3)), // подстановка в условие запроса
-				),
-			)
-			if err != nil {
-				return err
-			}
-			defer res.Close() // закрытие result'а обязательно
-			log.Printf("> select_simple_transaction:\n")
-			for res.NextResultSet(ctx) {
-				for res.NextRow() {
-					// в ScanNamed передаем имена колонок из строки сканирования,
-					// адреса (и типы данных), куда следует присвоить результаты запроса
-					err = res.ScanNamed(
-						named.Required("id", &id),
-						named.Optional("name", &name),
-					)
-					if err != nil {
-						return err
-					}
-					log.Printf(
-						"  > %d %s %s\n",
-						id, *name,
-					)
-					user.ID = id
-					user.Name = name
-				}
-			}
-			return res.Err()
-		},
-	)
 	if err != nil {
 		log.Printf("Error executing query: %v", err)
-		rw.WriteHeader(http.StatusInternalServerError)
-		rw.Write([]byte(`{"error": "Failed to execute query"}`))
+		writeError(rw, http.StatusInternalServerError, "Failed to execute query")
 		return
 	}
+	writeJSON(rw, user)
+}
+
+// envDuration parses the duration in the environment variable, or returns the default.
+func envDuration(name string, def time.Duration) time.Duration {
+	if v := os.Getenv(name); v != "" {
+		if d, err := time.ParseDuration(v); err == nil {
+			return d
+		}
+		log.Printf("invalid %s %q, using %s", name, v, def)
+	}
+	return def
+}
 
-	jsonData, err := json.Marshal(user)
+func writeJSON(rw http.ResponseWriter, v any) {
+	jsonData, err := json.Marshal(v)
 	if err != nil {
 		log.Printf("Error marshaling response: %v", err)
-		rw.WriteHeader(http.StatusInternalServerError)
-		rw.Write([]byte(`{"error": "Failed to marshal response"}`))
+		writeError(rw, http.StatusInternalServerError, "Failed to marshal response")
 		return
 	}
 
 	rw.Header().Set("Content-Type", "application/json")
-	rw.WriteHeader(200)
-	_, err = rw.Write(jsonData)
-	if err != nil {
+	rw.WriteHeader(http.StatusOK)
+	if _, err = rw.Write(jsonData); err != nil {
 		log.Printf("Error writing response: %v", err)
-		return
 	}
 }
+
+func writeError(rw http.ResponseWriter, status int, message string) {
+	body, _ := json.Marshal(map[string]string{"error": message})
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(status)
+	_, _ = rw.Write(body)
+}
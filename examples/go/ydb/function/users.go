@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/ydb-platform/ydb-go-sdk/v3"
+	"github.com/ydb-platform/ydb-go-sdk/v3/table"
+	"github.com/ydb-platform/ydb-go-sdk/v3/table/result"
+	"github.com/ydb-platform/ydb-go-sdk/v3/table/result/named"
+	"github.com/ydb-platform/ydb-go-sdk/v3/table/types"
+
+	"ydb/cache"
+)
+
+type User struct {
+	ID   int32   `json:"id"`
+	Name *string `json:"name"` // optional
+}
+
+var readTx = table.TxControl(
+	table.BeginTx(
+		table.WithOnlineReadOnly(),
+	),
+	table.CommitTx(),
+)
+
+// loadUser reads the user from the users table, or returns cache.ErrNotFound.
+func loadUser(ctx context.Context, db *ydb.Driver, id int32) (User, error) {
+	var (
+		user  User
+		found bool
+	)
+	err := db.Table().Do(ctx,
+		func(ctx context.Context, s table.Session) (err error) {
+			var res result.Result
+			_, res, err = s.Execute(
+				ctx,
+				readTx,
+				`
+        DECLARE $id AS Int32;
+        SELECT
+          id,
+          name,
+        FROM
+          users
+        WHERE
+          id = $id;
+      `,
+				table.NewQueryParameters(
+					table.ValueParam("$id", types.Int32Value(id)), // подстановка в условие запроса
+				),
+			)
+			if err != nil {
+				return err
+			}
+			defer res.Close() // закрытие result'а обязательно
+			for res.NextResultSet(ctx) {
+				for res.NextRow() {
+					// в ScanNamed передаем имена колонок из строки сканирования,
+					// адреса (и типы данных), куда следует присвоить результаты запроса
+					err = res.ScanNamed(
+						named.Required("id", &user.ID),
+						named.Optional("name", &user.Name),
+					)
+					if err != nil {
+						return err
+					}
+					found = true
+				}
+			}
+			return res.Err()
+		},
+		table.WithIdempotent(),
+	)
+	if err != nil {
+		return User{}, fmt.Errorf("failed to read user %d: %w", id, err)
+	}
+	if !found {
+		return User{}, fmt.Errorf("user %d: %w", id, cache.ErrNotFound)
+	}
+	return user, nil
+}
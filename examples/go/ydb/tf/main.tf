@@ -18,8 +18,18 @@ resource "yandex_function" "ydb_function" {
   }
   
   environment = {
-    YDB_DATABASE = yandex_ydb_database_serverless.db.database_path
-    YDB_ENDPOINT = yandex_ydb_database_serverless.db.ydb_api_endpoint
+    YDB_DATABASE   = yandex_ydb_database_serverless.db.database_path
+    YDB_ENDPOINT   = yandex_ydb_database_serverless.db.ydb_api_endpoint
+    REDIS_ADDR     = var.redis_addr
+    REDIS_PASSWORD = var.redis_password
+    CACHE_TTL      = var.cache_ttl
+  }
+
+  dynamic "connectivity" {
+    for_each = var.redis_network_id == "" ? [] : [var.redis_network_id]
+    content {
+      network_id = connectivity.value
+    }
   }
   
   depends_on = [
@@ -9,4 +9,26 @@ variable "folder_id" {
 variable "zone" {
   type    = string
   default = "ru-central1-a"
-} 
\ No newline at end of file
+} 
+variable "redis_addr" {
+  description = "host:port of the Redis caching the users, none to read YDB directly"
+  type        = string
+  default     = ""
+}
+
+variable "redis_password" {
+  type      = string
+  default   = ""
+  sensitive = true
+}
+
+variable "redis_network_id" {
+  description = "Network the function connects to reach the Redis at redis_addr"
+  type        = string
+  default     = ""
+}
+
+variable "cache_ttl" {
+  type    = string
+  default = "5m"
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/ydb-platform/ydb-go-sdk/v3/table"
+	"github.com/ydb-platform/ydb-go-sdk/v3/table/types"
+)
+
+const (
+	// windowSize is the length of the tumbling windows the actions are counted in.
+	windowSize = time.Minute
+	// defaultAllowedLateness is how long after the end of a window its events are still counted.
+	defaultAllowedLateness = 2 * time.Minute
+)
+
+// allowedLateness returns how long after the end of a window its events are still counted,
+// from YDS_ALLOWED_LATENESS.
+func allowedLateness() time.Duration {
+	lateness, err := time.ParseDuration(os.Getenv("YDS_ALLOWED_LATENESS"))
+	if err != nil || lateness < 0 {
+		return defaultAllowedLateness
+	}
+	return lateness
+}
+
+// windowOf returns the window the event belongs to and whether the event arrived too late to be counted in it.
+//
+// Events are windowed by the time they were produced at. The arrival time is the CreatedAt of the message,
+// the time it was written to the topic. An event that arrives later than the allowed lateness after the end
+// of its window is late. Producer clocks ahead of the topic are not trusted: such events are windowed by their
+// arrival time.
+func windowOf(event Event, metadata EventMetadata, lateness time.Duration) (time.Time, bool) {
+	arrived := metadata.CreatedAt
+	if arrived.IsZero() {
+		arrived = time.Now()
+	}
+	produced := time.Unix(event.Timestamp, 0)
+	if event.Timestamp == 0 || produced.After(arrived) {
+		produced = arrived
+	}
+	start := produced.UTC().Truncate(windowSize)
+	return start, arrived.After(start.Add(windowSize + lateness))
+}
+
+// countEvent adds the event to the count of its action in its window for its user.
+// Late events are added to the late count of the window instead, so the counts of closed windows do not change.
+func countEvent(ctx context.Context, tx table.TransactionActor, event Event, metadata EventMetadata) error {
+	start, late := windowOf(event, metadata, allowedLateness())
+	var count, lateCount uint64 = 1, 0
+	if late {
+		count, lateCount = 0, 1
+		log.Printf("Event of user %s is late for the window at %v", event.UserID, start)
+	}
+
+	res, err := tx.Execute(ctx, `
+		DECLARE $user_id AS Utf8;
+		DECLARE $window_start AS Timestamp;
+		DECLARE $action AS Utf8;
+		DECLARE $count AS Uint64;
+		DECLARE $late AS Uint64;
+		DECLARE $updated_at AS Timestamp;
+		UPSERT INTO action_counts
+		SELECT
+			$user_id AS user_id,
+			$window_start AS window_start,
+			$action AS action,
+			COALESCE(MAX(count), 0ul) + $count AS count,
+			COALESCE(MAX(late), 0ul) + $late AS late,
+			$updated_at AS updated_at
+		FROM action_counts
+		WHERE user_id = $user_id AND window_start = $window_start AND action = $action;
+	`, table.NewQueryParameters(
+		table.ValueParam("$user_id", types.TextValue(event.UserID)),
+		table.ValueParam("$window_start", types.TimestampValueFromTime(start)),
+		table.ValueParam("$action", types.TextValue(event.Action)),
+		table.ValueParam("$count", types.Uint64Value(count)),
+		table.ValueParam("$late", types.Uint64Value(lateCount)),
+		table.ValueParam("$updated_at", types.TimestampValueFromTime(time.Now().UTC())),
+	))
+	if err != nil {
+		return fmt.Errorf("failed to count event: %w", err)
+	}
+	return res.Close()
+}
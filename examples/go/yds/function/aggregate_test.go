@@ -0,0 +1,79 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestWindowOf(t *testing.T) {
+	at := func(clock string) time.Time {
+		t, err := time.Parse(time.DateTime, "2025-01-01 "+clock)
+		if err != nil {
+			panic(err)
+		}
+		return t
+	}
+	tests := []struct {
+		name     string
+		produced time.Time // Zero for an event without a timestamp
+		arrived  time.Time
+		lateness time.Duration
+		start    time.Time
+		late     bool
+	}{
+		{
+			name:     "on time",
+			produced: at("12:00:30"),
+			arrived:  at("12:00:40"),
+			lateness: 2 * time.Minute,
+			start:    at("12:00:00"),
+		},
+		{
+			name:     "within the allowed lateness",
+			produced: at("12:00:30"),
+			arrived:  at("12:03:00"),
+			lateness: 2 * time.Minute,
+			start:    at("12:00:00"),
+		},
+		{
+			name:     "after the allowed lateness",
+			produced: at("12:00:30"),
+			arrived:  at("12:03:01"),
+			lateness: 2 * time.Minute,
+			start:    at("12:00:00"),
+			late:     true,
+		},
+		{
+			name:     "no lateness allowed",
+			produced: at("12:00:59"),
+			arrived:  at("12:01:01"),
+			start:    at("12:00:00"),
+			late:     true,
+		},
+		{
+			name:     "producer clock ahead of the topic",
+			produced: at("12:05:00"),
+			arrived:  at("12:00:10"),
+			lateness: 2 * time.Minute,
+			start:    at("12:00:00"),
+		},
+		{
+			name:     "no timestamp",
+			arrived:  at("12:07:45"),
+			lateness: 2 * time.Minute,
+			start:    at("12:07:00"),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var event Event
+			if !tt.produced.IsZero() {
+				event.Timestamp = tt.produced.Unix()
+			}
+			start, late := windowOf(event, EventMetadata{CreatedAt: tt.arrived}, tt.lateness)
+			if !start.Equal(tt.start) || late != tt.late {
+				t.Errorf("windowOf = %v, %v, want %v, %v", start, late, tt.start, tt.late)
+			}
+		})
+	}
+}
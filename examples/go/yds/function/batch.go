@@ -0,0 +1,307 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+
+	"github.com/ydb-platform/ydb-go-sdk/v3"
+	"github.com/ydb-platform/ydb-go-sdk/v3/topic/topicoptions"
+	"github.com/ydb-platform/ydb-go-sdk/v3/topic/topictypes"
+	"github.com/ydb-platform/ydb-go-sdk/v3/topic/topicwriter"
+	"github.com/ydb-platform/ydb-go-sdk/v3/trace"
+)
+
+const (
+	// maxBatchRecords is the largest number of records accepted in one request.
+	maxBatchRecords = 1000
+	// maxBatchBytes is the largest request body accepted.
+	maxBatchBytes = 8 << 20
+	// batchWriters is the number of users whose records are written at the same time.
+	batchWriters = 8
+	// producerIDPrefix is prepended to the user ID to get the producer ID of the user's records.
+	producerIDPrefix = "user-"
+)
+
+// BatchProducerHandler handles HTTP requests to write many events to the YDS stream at once.
+// The body is either a JSON array of events or NDJSON, one event per line.
+// All records are validated before anything is written: if any record is invalid,
+// the response is 400 with the errors of every invalid record, and nothing is written.
+//
+// Records are written per user, with the user ID as the producer ID, which is the message group of the topic:
+// the records of a user are written in one batch of their own and stay in one partition in the order of the
+// request. The response tells the producer ID and the sequence number of every record.
+// YDS_WRITER_CODEC (raw, gzip or zstd) or the codec query parameter selects the compression.
+func BatchProducerHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	// Check required environment variables
+	ydbEndpoint := os.Getenv("YDB_ENDPOINT")
+	topicName := os.Getenv("YDS_TOPIC_ID")
+	if ydbEndpoint == "" || topicName == "" {
+		http.Error(w, "YDB_ENDPOINT and YDS_TOPIC_ID environment variables must be set", http.StatusInternalServerError)
+		return
+	}
+
+	codecName := r.URL.Query().Get("codec")
+	if codecName == "" {
+		codecName = os.Getenv("YDS_WRITER_CODEC")
+	}
+	codec, err := parseCodec(codecName)
+	if err != nil {
+		writeBatchResponse(w, BatchProducerResponse{StatusCode: http.StatusBadRequest, Message: err.Error()})
+		return
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxBatchBytes))
+	if err != nil {
+		writeBatchResponse(w, BatchProducerResponse{StatusCode: http.StatusRequestEntityTooLarge, Message: err.Error()})
+		return
+	}
+	requests, err := parseBatch(body)
+	if err != nil {
+		writeBatchResponse(w, BatchProducerResponse{StatusCode: http.StatusBadRequest, Message: err.Error()})
+		return
+	}
+
+	// Validate all records first, so an invalid record fails the request before anything is written
+	records := make([]batchRecord, len(requests))
+	var invalid []RecordError
+	for i, req := range requests {
+		event, data, err := encodeEvent(req)
+		if err != nil {
+			var validationErr *ValidationError
+			if !errors.As(err, &validationErr) {
+				log.Printf("Error encoding record %d: %v", i, err)
+				http.Error(w, "Internal server error", http.StatusInternalServerError)
+				return
+			}
+			invalid = append(invalid, RecordError{Index: i, Errors: validationErr.Fields})
+			continue
+		}
+		records[i] = batchRecord{index: i, event: event, data: data}
+	}
+	if len(invalid) > 0 {
+		writeBatchResponse(w, BatchProducerResponse{
+			StatusCode: http.StatusBadRequest,
+			Message:    fmt.Sprintf("%d of %d records are invalid", len(invalid), len(requests)),
+			Invalid:    invalid,
+		})
+		return
+	}
+
+	written, failed := writeBatch(ctx, ydbEndpoint, topicName, codec, records)
+	response := BatchProducerResponse{
+		StatusCode: http.StatusOK,
+		Message:    fmt.Sprintf("Written %d records", len(written)),
+		StreamID:   topicName,
+		Records:    written,
+		Failed:     failed,
+	}
+	if len(failed) > 0 {
+		response.StatusCode = http.StatusInternalServerError
+		response.Message = fmt.Sprintf("Written %d of %d records", len(written), len(records))
+	}
+	writeBatchResponse(w, response)
+}
+
+// batchRecord is a validated record waiting to be written.
+type batchRecord struct {
+	index int
+	event Event
+	data  []byte
+}
+
+// parseBatch parses a JSON array or NDJSON body into requests.
+func parseBatch(body []byte) ([]ProducerRequest, error) {
+	body = bytes.TrimSpace(body)
+	var requests []ProducerRequest
+	if bytes.HasPrefix(body, []byte("[")) {
+		if err := json.Unmarshal(body, &requests); err != nil {
+			return nil, fmt.Errorf("invalid JSON array: %w", err)
+		}
+	} else {
+		scanner := bufio.NewScanner(bytes.NewReader(body))
+		scanner.Buffer(nil, maxBatchBytes)
+		for line := 1; scanner.Scan(); line++ {
+			text := bytes.TrimSpace(scanner.Bytes())
+			if len(text) == 0 {
+				continue
+			}
+			var req ProducerRequest
+			if err := json.Unmarshal(text, &req); err != nil {
+				return nil, fmt.Errorf("invalid JSON on line %d: %w", line, err)
+			}
+			requests = append(requests, req)
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, err
+		}
+	}
+	if len(requests) == 0 {
+		return nil, errors.New("the batch is empty")
+	}
+	if len(requests) > maxBatchRecords {
+		return nil, fmt.Errorf("the batch has %d records, the limit is %d", len(requests), maxBatchRecords)
+	}
+	return requests, nil
+}
+
+// writeBatch writes the records grouped by user, with a writer per user, and returns the written records
+// and the errors of the records that could not be written.
+func writeBatch(
+	ctx context.Context,
+	ydbEndpoint, topicName string,
+	codec topictypes.Codec,
+	records []batchRecord,
+) ([]WrittenRecord, []RecordError) {
+	var users []string
+	byUser := map[string][]batchRecord{}
+	for _, record := range records {
+		if _, ok := byUser[record.event.UserID]; !ok {
+			users = append(users, record.event.UserID)
+		}
+		byUser[record.event.UserID] = append(byUser[record.event.UserID], record)
+	}
+
+	fail := func(records []batchRecord, err error) []RecordError {
+		failed := make([]RecordError, len(records))
+		for i, record := range records {
+			failed[i] = RecordError{Index: record.index, Errors: []FieldError{{Field: "/", Message: err.Error()}}}
+		}
+		return failed
+	}
+
+	db, err := ydb.Open(ctx, ydbEndpoint, ydbOptions()...)
+	if err != nil {
+		return nil, fail(records, fmt.Errorf("failed to connect to YDB: %w", err))
+	}
+	defer db.Close(ctx)
+
+	var (
+		mu      sync.Mutex
+		wg      sync.WaitGroup
+		written []WrittenRecord
+		failed  []RecordError
+	)
+	slots := make(chan struct{}, batchWriters)
+	for _, user := range users {
+		wg.Add(1)
+		slots <- struct{}{}
+		go func(records []batchRecord) {
+			defer wg.Done()
+			defer func() { <-slots }()
+			result, err := writeUserRecords(ctx, db, topicName, codec, records)
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				log.Printf("Error writing records of user %s: %v", records[0].event.UserID, err)
+				failed = append(failed, fail(records, err)...)
+				return
+			}
+			written = append(written, result...)
+		}(byUser[user])
+	}
+	wg.Wait()
+	sort.Slice(written, func(i, j int) bool { return written[i].Index < written[j].Index })
+	sort.Slice(failed, func(i, j int) bool { return failed[i].Index < failed[j].Index })
+	return written, failed
+}
+
+// producerLocks holds a mutex per producer ID, so the requests of the instance write the records of a user
+// one after another.
+var producerLocks sync.Map
+
+// writeUserRecords writes the records of a single user in one batch and waits for the server to acknowledge them.
+// Sequence numbers are assigned explicitly after the last one the topic has for the producer, so they can be
+// returned to the client.
+//
+// The topic skips messages whose sequence number is not greater than the last one of the producer. Writes of
+// the same user by this instance wait for each other, but a concurrent request served by another instance can
+// still take the same numbers. The skipped messages are counted from the acknowledgements, and the records
+// fail then instead of being reported as written.
+func writeUserRecords(
+	ctx context.Context,
+	db *ydb.Driver,
+	topicName string,
+	codec topictypes.Codec,
+	records []batchRecord,
+) ([]WrittenRecord, error) {
+	event := records[0].event
+	producerID := producerIDPrefix + event.UserID
+	lock, _ := producerLocks.LoadOrStore(producerID, &sync.Mutex{})
+	lock.(*sync.Mutex).Lock()
+	defer lock.(*sync.Mutex).Unlock()
+
+	var skipped atomic.Int64
+	opts := append([]topicoptions.WriterOption{
+		topicoptions.WithWriterProducerID(producerID),
+		topicoptions.WithWriterSetAutoSeqNo(false),
+		topicoptions.WithWriterWaitServerAck(true),
+		topicoptions.WithWriterTrace(trace.Topic{
+			OnWriterReceiveResult: func(info trace.TopicWriterResultMessagesInfo) {
+				if info.Acks != nil {
+					skipped.Add(int64(info.Acks.GetAcks().SkipCount))
+				}
+			},
+		}),
+	}, writerCodecOptions(codec)...)
+	writer, err := db.Topic().StartWriter(topicName, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create topic writer: %w", err)
+	}
+	defer writer.Close(ctx)
+
+	info, err := writer.WaitInitInfo(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize topic writer: %w", err)
+	}
+
+	messages := make([]topicwriter.Message, len(records))
+	written := make([]WrittenRecord, len(records))
+	for i, record := range records {
+		seqNo := info.LastSeqNum + int64(i) + 1
+		messages[i] = topicwriter.Message{
+			SeqNo: seqNo,
+			Data:  bytes.NewReader(record.data),
+			Metadata: map[string][]byte{
+				"user_id":        []byte(record.event.UserID),
+				"action":         []byte(record.event.Action),
+				"schema_version": []byte(strconv.Itoa(record.event.Version)),
+			},
+		}
+		written[i] = WrittenRecord{
+			Index:      record.index,
+			UserID:     record.event.UserID,
+			ProducerID: producerID,
+			SeqNo:      seqNo,
+		}
+	}
+	// With WaitServerAck the write returns once the server has acknowledged every message
+	if err = writer.Write(ctx, messages...); err != nil {
+		return nil, fmt.Errorf("failed to write messages to topic: %w", err)
+	}
+	if n := skipped.Load(); n > 0 {
+		return nil, fmt.Errorf("the topic skipped %d of %d records as duplicates: another request wrote records "+
+			"of producer %s at the same time, the others may have been written", n, len(records), producerID)
+	}
+	return written, nil
+}
+
+// writeBatchResponse writes the response as JSON with its status code
+func writeBatchResponse(w http.ResponseWriter, response BatchProducerResponse) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(response.StatusCode)
+	json.NewEncoder(w).Encode(response)
+}
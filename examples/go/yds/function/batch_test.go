@@ -0,0 +1,78 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseBatch(t *testing.T) {
+	tests := []struct {
+		name  string
+		body  string
+		users []string // The users of the parsed requests, in order
+		err   string   // A part of the error, if the body is rejected
+	}{
+		{
+			name:  "JSON array",
+			body:  `[{"user_id":"a","action":"login"},{"user_id":"b","action":"view"}]`,
+			users: []string{"a", "b"},
+		},
+		{
+			name:  "NDJSON with blank lines",
+			body:  "{\"user_id\":\"a\",\"action\":\"login\"}\n\n  {\"user_id\":\"b\",\"action\":\"view\"}\n",
+			users: []string{"a", "b"},
+		},
+		{
+			name:  "surrounding whitespace",
+			body:  "\n  [{\"user_id\":\"a\",\"action\":\"login\"}]  \n",
+			users: []string{"a"},
+		},
+		{
+			name: "invalid JSON array",
+			body: `[{"user_id":"a"},`,
+			err:  "invalid JSON array",
+		},
+		{
+			name: "invalid NDJSON line",
+			body: "{\"user_id\":\"a\"}\n{\"user_id\":\n",
+			err:  "invalid JSON on line 2",
+		},
+		{
+			name: "empty body",
+			body: "  \n",
+			err:  "the batch is empty",
+		},
+		{
+			name: "empty array",
+			body: "[]",
+			err:  "the batch is empty",
+		},
+		{
+			name: "too many records",
+			body: strings.Repeat("{\"user_id\":\"a\"}\n", maxBatchRecords+1),
+			err:  "the limit is 1000",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			requests, err := parseBatch([]byte(tt.body))
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("got error %v, want one with %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(requests) != len(tt.users) {
+				t.Fatalf("got %d requests, want %d", len(requests), len(tt.users))
+			}
+			for i, user := range tt.users {
+				if requests[i].UserID != user {
+					t.Errorf("request %d is of user %q, want %q", i, requests[i].UserID, user)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,261 @@
+//go:build cli
+
+// The command line tool for the operations on the topics that do not run in a function.
+// The functions are built without the cli tag, so the file is not part of them. Run it with
+//
+//	go run -tags cli . <command> [flags]
+//
+// Commands:
+//
+//	replay  re-publishes dead-lettered records back to the main topic
+//	read    reads the main topic with its own consumer and processes the records like ConsumerHandler
+//
+// The tool connects to YDB_ENDPOINT with the IAM token from IAM_TOKEN, the service account key file
+// from YDB_SERVICE_ACCOUNT_KEY_FILE_CREDENTIALS, or the metadata service when it runs in Yandex Cloud.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/ydb-platform/ydb-go-sdk/v3"
+	"github.com/ydb-platform/ydb-go-sdk/v3/topic/topicoptions"
+	"github.com/ydb-platform/ydb-go-sdk/v3/topic/topicreader"
+	"github.com/ydb-platform/ydb-go-sdk/v3/topic/topicwriter"
+	yc "github.com/ydb-platform/ydb-go-yc"
+)
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// The code shared with the functions connects with the credentials of the tool as well
+	ydbOptions = cliOptions
+
+	var err error
+	switch os.Args[1] {
+	case "replay":
+		err = replayCommand(ctx, os.Args[2:])
+	case "read":
+		err = readCommand(ctx, os.Args[2:])
+	default:
+		usage()
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: go run -tags cli . replay|read [flags]")
+	os.Exit(2)
+}
+
+// cliOptions returns the options to connect to YDB with the credentials available outside a function.
+func cliOptions() []ydb.Option {
+	credentials := yc.WithMetadataCredentials()
+	if token := os.Getenv("IAM_TOKEN"); token != "" {
+		credentials = ydb.WithAccessTokenCredentials(token)
+	} else if keyFile := os.Getenv("YDB_SERVICE_ACCOUNT_KEY_FILE_CREDENTIALS"); keyFile != "" {
+		credentials = yc.WithServiceAccountKeyFileCredentials(keyFile)
+	}
+	return []ydb.Option{credentials, yc.WithInternalCA()}
+}
+
+// openDB connects to YDB with the credentials available outside a function.
+func openDB(ctx context.Context, endpoint string) (*ydb.Driver, error) {
+	if endpoint == "" {
+		return nil, errors.New("YDB endpoint is not set, use -endpoint or YDB_ENDPOINT")
+	}
+	db, err := ydb.Open(ctx, endpoint, ydbOptions()...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to YDB: %w", err)
+	}
+	return db, nil
+}
+
+// replayCommand reads the dead-letter topic with its own consumer and writes the original data of every
+// record back to the main topic. A record is committed only after it is written, so an interrupted replay
+// continues where it stopped. The replay ends when no record arrives for the idle timeout.
+func replayCommand(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("replay", flag.ExitOnError)
+	endpoint := flags.String("endpoint", os.Getenv("YDB_ENDPOINT"), "YDB endpoint")
+	from := flags.String("from", os.Getenv("YDS_DEAD_LETTER_TOPIC_ID"), "dead-letter topic")
+	to := flags.String("to", os.Getenv("YDS_TOPIC_ID"), "main topic the records are re-published to")
+	consumer := flags.String("consumer", "replay", "consumer of the dead-letter topic")
+	limit := flags.Int("limit", 0, "maximum number of records to replay, 0 for all")
+	idle := flags.Duration("idle", 10*time.Second, "stop when no record arrives for this long")
+	match := flags.String("match", "",
+		"replay only records whose error contains this text, the others are committed as skipped")
+	dryRun := flags.Bool("dry-run", false, "print the records without re-publishing or committing them")
+	_ = flags.Parse(args)
+	if *from == "" || *to == "" {
+		return errors.New("both -from and -to topics are required")
+	}
+
+	db, err := openDB(ctx, *endpoint)
+	if err != nil {
+		return err
+	}
+	defer db.Close(context.Background())
+
+	reader, err := db.Topic().StartReader(*consumer, topicoptions.ReadTopic(*from),
+		topicoptions.WithReaderCommitMode(topicoptions.CommitModeSync),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to start reader: %w", err)
+	}
+	defer reader.Close(context.Background())
+
+	writer, err := db.Topic().StartWriter(*to)
+	if err != nil {
+		return fmt.Errorf("failed to start writer: %w", err)
+	}
+	defer writer.Close(context.Background())
+
+	replayed, skipped := 0, 0
+	for *limit == 0 || replayed < *limit {
+		readCtx, cancel := context.WithTimeout(ctx, *idle)
+		msg, err := reader.ReadMessage(readCtx)
+		cancel()
+		if err != nil {
+			if errors.Is(err, context.DeadlineExceeded) && ctx.Err() == nil {
+				break
+			}
+			return fmt.Errorf("failed to read dead letter: %w", err)
+		}
+
+		var letter DeadLetter
+		if err = json.NewDecoder(msg).Decode(&letter); err != nil {
+			log.Printf("Skipping malformed dead letter at offset %d: %v", msg.Offset, err)
+			skipped++
+		} else if *match != "" && !strings.Contains(letter.Error, *match) {
+			skipped++
+		} else if *dryRun {
+			log.Printf("Would replay event %s (%s): %s", letter.EventMetadata.EventID, letter.Error, letter.Data)
+			replayed++
+		} else {
+			err = writer.Write(ctx, topicwriter.Message{
+				Data: strings.NewReader(letter.Data),
+				Metadata: map[string][]byte{
+					"replayed-event-id": []byte(letter.EventMetadata.EventID),
+				},
+			})
+			if err != nil {
+				return fmt.Errorf("failed to re-publish event %s: %w", letter.EventMetadata.EventID, err)
+			}
+			replayed++
+		}
+		if *dryRun {
+			continue
+		}
+		if err = reader.Commit(ctx, msg); err != nil {
+			return fmt.Errorf("failed to commit dead letter: %w", err)
+		}
+	}
+	log.Printf("Replayed %d dead letters, skipped %d", replayed, skipped)
+	return nil
+}
+
+// readCommand reads the main topic with its own consumer instead of the trigger, for the loads the trigger
+// batching limits do not fit. The records are processed by the same code as in ConsumerHandler: they are
+// validated, deduplicated, processed, rejected or dead-lettered.
+//
+// A batch is committed after all of its records are processed and its rejected and failed records are stored.
+// If a batch fails, the command exits without committing it, and the batch is read again after a restart.
+// On SIGTERM or interrupt the current batch is finished and committed, then the command stops.
+func readCommand(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("read", flag.ExitOnError)
+	endpoint := flags.String("endpoint", os.Getenv("YDB_ENDPOINT"), "YDB endpoint")
+	topic := flags.String("topic", os.Getenv("YDS_TOPIC_ID"), "topic to read")
+	consumer := flags.String("consumer", "reader", "consumer of the topic")
+	batchSize := flags.Int("batch", 100, "maximum number of records in a batch")
+	batchTimeout := flags.Duration("batch-timeout", 30*time.Second,
+		"time to process and commit a batch, it is finished within it after a shutdown signal as well")
+	_ = flags.Parse(args)
+	if *topic == "" {
+		return errors.New("-topic is required")
+	}
+
+	db, err := openDB(ctx, *endpoint)
+	if err != nil {
+		return err
+	}
+	store := &Store{db: db}
+	defer store.Close(context.Background())
+	// The rejected and dead-lettered records are written to the topics from the environment, like in the function
+	if err = os.Setenv("YDB_ENDPOINT", *endpoint); err != nil {
+		return err
+	}
+
+	opts := append([]topicoptions.ReaderOption{
+		topicoptions.WithReaderCommitMode(topicoptions.CommitModeSync),
+		topicoptions.WithReaderBatchMaxCount(*batchSize),
+	}, readerCodecOptions()...)
+	reader, err := db.Topic().StartReader(*consumer, topicoptions.ReadTopic(*topic), opts...)
+	if err != nil {
+		return fmt.Errorf("failed to start reader: %w", err)
+	}
+	defer reader.Close(context.Background())
+
+	log.Printf("Reading %s with consumer %s", *topic, *consumer)
+	for {
+		batch, err := reader.ReadMessagesBatch(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				log.Printf("Stopped reading %s", *topic)
+				return nil
+			}
+			return fmt.Errorf("failed to read batch: %w", err)
+		}
+
+		// Finish the batch even if a shutdown signal arrives while it is processed
+		batchCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), *batchTimeout)
+		err = consumeBatch(batchCtx, store, reader, batch)
+		cancel()
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// consumeBatch processes the records of the batch and commits it.
+// A record is identified by its topic, partition and offset, which stay the same when it is read again.
+func consumeBatch(ctx context.Context, store *Store, reader *topicreader.Reader, batch *topicreader.Batch) error {
+	consumer := newBatchConsumer(store)
+	for _, msg := range batch.Messages {
+		data, err := io.ReadAll(msg)
+		if err != nil {
+			return fmt.Errorf("failed to read message at offset %d: %w", msg.Offset, err)
+		}
+		metadata := EventMetadata{
+			EventID:   fmt.Sprintf("%s/%d/%d", batch.Topic(), batch.PartitionID(), msg.Offset),
+			EventType: "yds.topic.read",
+			CreatedAt: msg.WrittenAt,
+		}
+		if err = consumer.Consume(ctx, string(data), metadata); err != nil {
+			return fmt.Errorf("failed to process %s: %w", metadata.EventID, err)
+		}
+	}
+	if err := consumer.Flush(ctx); err != nil {
+		return err
+	}
+	if err := reader.Commit(ctx, batch); err != nil {
+		return fmt.Errorf("failed to commit batch: %w", err)
+	}
+	log.Print(consumer.Summary(len(batch.Messages)))
+	return nil
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/ydb-platform/ydb-go-sdk/v3/topic/topicoptions"
+	"github.com/ydb-platform/ydb-go-sdk/v3/topic/topictypes"
+)
+
+// codecs are the topic codecs the producer can write with.
+var codecs = map[string]topictypes.Codec{
+	"raw":  topictypes.CodecRaw,
+	"gzip": topictypes.CodecGzip,
+	"zstd": topictypes.CodecZstd,
+}
+
+// parseCodec returns the topic codec by its name, raw if the name is empty.
+func parseCodec(name string) (topictypes.Codec, error) {
+	if name == "" {
+		return topictypes.CodecRaw, nil
+	}
+	codec, ok := codecs[strings.ToLower(name)]
+	if !ok {
+		return 0, fmt.Errorf("unknown codec %q, expected raw, gzip or zstd", name)
+	}
+	return codec, nil
+}
+
+// writerCodecOptions returns the writer options to compress messages with the codec.
+// The SDK has no zstd encoder of its own, so it is provided here.
+func writerCodecOptions(codec topictypes.Codec) []topicoptions.WriterOption {
+	opts := []topicoptions.WriterOption{topicoptions.WithWriterCodec(codec)}
+	if codec == topictypes.CodecZstd {
+		opts = append(opts, topicoptions.WithWriterAddEncoder(topictypes.CodecZstd,
+			func(w io.Writer) (io.WriteCloser, error) {
+				return zstd.NewWriter(w)
+			},
+		))
+	}
+	return opts
+}
+
+// readerCodecOptions returns the reader options to decode messages written with any of the codecs.
+// The SDK decodes gzip by itself, zstd needs a decoder.
+func readerCodecOptions() []topicoptions.ReaderOption {
+	return []topicoptions.ReaderOption{
+		topicoptions.WithAddDecoder(topictypes.CodecZstd, func(r io.Reader) (io.Reader, error) {
+			decoder, err := zstd.NewReader(r, zstd.WithDecoderConcurrency(1))
+			if err != nil {
+				return nil, err
+			}
+			return decoder.IOReadCloser(), nil
+		}),
+	}
+}
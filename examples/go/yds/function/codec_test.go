@@ -0,0 +1,33 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/ydb-platform/ydb-go-sdk/v3/topic/topictypes"
+)
+
+func TestParseCodec(t *testing.T) {
+	tests := []struct {
+		name    string
+		codec   topictypes.Codec
+		wantErr bool
+	}{
+		{name: "", codec: topictypes.CodecRaw},
+		{name: "raw", codec: topictypes.CodecRaw},
+		{name: "gzip", codec: topictypes.CodecGzip},
+		{name: "ZSTD", codec: topictypes.CodecZstd},
+		{name: "lzop", wantErr: true},
+	}
+	for _, tt := range tests {
+		codec, err := parseCodec(tt.name)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("parseCodec(%q) = %v, want an error", tt.name, codec)
+			}
+			continue
+		}
+		if err != nil || codec != tt.codec {
+			t.Errorf("parseCodec(%q) = %v, %v, want %v", tt.name, codec, err, tt.codec)
+		}
+	}
+}
@@ -3,102 +3,313 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
+	"os"
 	"time"
+
+	"github.com/ydb-platform/ydb-go-sdk/v3/table"
+	"github.com/ydb-platform/ydb-go-sdk/v3/table/types"
 )
 
-// ConsumerHandler handles YDS trigger events
+// ConsumerHandler handles YDS trigger events.
+// Every record is validated against the schema of its action. Invalid records are not processed,
+// they are routed to the topic from YDS_INVALID_TOPIC_ID with the reasons they were rejected.
+// Records that fail processing are written to the dead-letter topic from YDS_DEAD_LETTER_TOPIC_ID.
+// With YDS_RETRY_TRANSIENT=true a transient failure fails the whole batch instead, so the trigger retries it.
+// Every event is processed at most once: messages of redelivered batches that have already been processed
+// are skipped as duplicates, see Store.ProcessOnce.
 func ConsumerHandler(ctx context.Context, event *YDSEvent) (*YDSResponse, error) {
 	log.Printf("Received YDS event with %d messages", len(event.Messages))
 
+	store, err := openStore(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer store.Close(ctx)
+
+	batch := newBatchConsumer(store)
 	// Process each message in the batch
 	for i, message := range event.Messages {
 		log.Printf("Processing message %d: %s", i+1, message.Details.Data)
-
-		// Parse the message data
-		var eventData map[string]interface{}
-		if err := json.Unmarshal([]byte(message.Details.Data), &eventData); err != nil {
-			log.Printf("Error parsing message data: %v", err)
-			continue
+		if err = batch.Consume(ctx, message.Details.Data, message.EventMetadata); err != nil {
+			// Fail the whole batch, the trigger delivers it again
+			return nil, fmt.Errorf("failed to process message %d, retrying the batch: %w", i+1, err)
 		}
+	}
+
+	// Fail the batch if the rejected or failed records cannot be stored, so the trigger delivers it again
+	if err = batch.Flush(ctx); err != nil {
+		return nil, err
+	}
 
-		// Process the event
-		err := processEvent(ctx, eventData, message.EventMetadata)
+	return &YDSResponse{
+		StatusCode:   200,
+		Message:      batch.Summary(len(event.Messages)),
+		Processed:    batch.processed,
+		Duplicates:   batch.duplicates,
+		Rejected:     len(batch.rejected),
+		DeadLettered: len(batch.failed),
+	}, nil
+}
+
+// eventStore records the processed events, see Store. Tests replace it.
+type eventStore interface {
+	Processed(ctx context.Context, eventID string) (bool, error)
+	ProcessOnce(
+		ctx context.Context,
+		eventID string,
+		process func(ctx context.Context, tx table.TransactionActor) error,
+	) (bool, error)
+}
+
+// batchConsumer processes the records of a batch, read by the trigger or by the read command,
+// and keeps the outcome of every record.
+type batchConsumer struct {
+	store      eventStore
+	processed  int
+	duplicates int
+	rejected   []RejectedRecord
+	failed     []DeadLetter
+
+	// routeRejected and sendDeadLetters store the records in Flush, replaced by tests
+	routeRejected   func(ctx context.Context, rejected []RejectedRecord) error
+	sendDeadLetters func(ctx context.Context, letters []DeadLetter) error
+}
+
+func newBatchConsumer(store eventStore) *batchConsumer {
+	return &batchConsumer{store: store, routeRejected: routeRejected, sendDeadLetters: sendDeadLetters}
+}
+
+// Consume validates and processes a single record. Invalid records and records that fail processing
+// are kept until Flush. An error means the record has to be delivered again, and so the whole batch.
+func (b *batchConsumer) Consume(ctx context.Context, data string, metadata EventMetadata) error {
+	// Parse and validate the message data
+	eventData, err := schemas.Decode([]byte(data))
+	if err != nil {
+		var validationErr *ValidationError
+		if !errors.As(err, &validationErr) {
+			return fmt.Errorf("failed to validate event %s: %w", metadata.EventID, err)
+		}
+		// An earlier delivery of the batch has routed the record already
+		processed, err := b.store.Processed(ctx, metadata.EventID)
 		if err != nil {
-			log.Printf("Error processing event: %v", err)
-			continue
+			return err
 		}
+		if processed {
+			b.duplicates++
+			log.Printf("Skipped event %s, it has already been rejected", metadata.EventID)
+			return nil
+		}
+		log.Printf("Rejected event %s: %v", metadata.EventID, validationErr)
+		b.rejected = append(b.rejected, RejectedRecord{
+			Data:          data,
+			Errors:        validationErr.Fields,
+			EventMetadata: metadata,
+		})
+		return nil
+	}
 
-		log.Printf("Successfully processed message %d", i+1)
+	// Process the event, unless an earlier delivery of it has been processed
+	first, err := b.store.ProcessOnce(ctx, metadata.EventID,
+		func(ctx context.Context, tx table.TransactionActor) error {
+			return processEvent(ctx, tx, eventData, metadata)
+		},
+	)
+	if err != nil {
+		if IsTransient(err) && retryTransient() {
+			return err
+		}
+		log.Printf("Error processing event: %v", err)
+		b.failed = append(b.failed, DeadLetter{
+			Data:          data,
+			Error:         err.Error(),
+			EventMetadata: metadata,
+			FailedAt:      time.Now().UTC(),
+		})
+		return nil
+	}
+	if !first {
+		b.duplicates++
+		log.Printf("Skipped event %s, it has already been processed", metadata.EventID)
+		return nil
 	}
 
-	return &YDSResponse{
-		StatusCode: 200,
-		Message:    fmt.Sprintf("Processed %d messages successfully", len(event.Messages)),
-	}, nil
+	b.processed++
+	log.Printf("Successfully processed event %s", metadata.EventID)
+	return nil
 }
 
-// processEvent processes a single event from the stream
-func processEvent(ctx context.Context, eventData map[string]interface{}, metadata EventMetadata) error {
-	// Extract event information
-	message, _ := eventData["message"].(string)
-	userID, _ := eventData["user_id"].(string)
-	action, _ := eventData["action"].(string)
-	timestamp, _ := eventData["timestamp"].(float64)
+// Flush stores the rejected and dead-lettered records, and then records their events as processed,
+// so a redelivery of the batch skips them instead of storing them again.
+// An error means they are not stored, and the batch has to be delivered again.
+func (b *batchConsumer) Flush(ctx context.Context) error {
+	if err := b.routeRejected(ctx, b.rejected); err != nil {
+		return err
+	}
+	if err := b.sendDeadLetters(ctx, b.failed); err != nil {
+		return err
+	}
+	for _, record := range b.rejected {
+		if err := b.markProcessed(ctx, record.EventMetadata.EventID); err != nil {
+			return fmt.Errorf("failed to record rejected event %s: %w", record.EventMetadata.EventID, err)
+		}
+	}
+	for _, letter := range b.failed {
+		if err := b.markProcessed(ctx, letter.EventMetadata.EventID); err != nil {
+			return fmt.Errorf("failed to record dead-lettered event %s: %w", letter.EventMetadata.EventID, err)
+		}
+	}
+	return nil
+}
+
+// markProcessed records the event ID as processed. The event has no effects, only its ID is recorded.
+func (b *batchConsumer) markProcessed(ctx context.Context, eventID string) error {
+	if eventID == "" {
+		return nil
+	}
+	_, err := b.store.ProcessOnce(ctx, eventID,
+		func(ctx context.Context, tx table.TransactionActor) error { return nil },
+	)
+	return err
+}
+
+// Summary describes the outcome of the batch of total records.
+func (b *batchConsumer) Summary(total int) string {
+	return fmt.Sprintf("Processed %d of %d messages, skipped %d duplicates, rejected %d, dead-lettered %d",
+		b.processed, total, b.duplicates, len(b.rejected), len(b.failed))
+}
+
+// routeRejected writes the rejected records to the topic from YDS_INVALID_TOPIC_ID.
+// Without the topic the records are only logged.
+func routeRejected(ctx context.Context, rejected []RejectedRecord) error {
+	if len(rejected) == 0 {
+		return nil
+	}
+	messages := make([][]byte, len(rejected))
+	for i, record := range rejected {
+		data, err := json.Marshal(record)
+		if err != nil {
+			return fmt.Errorf("failed to marshal rejected record: %w", err)
+		}
+		messages[i] = data
+	}
+
+	ydbEndpoint := os.Getenv("YDB_ENDPOINT")
+	topicName := os.Getenv("YDS_INVALID_TOPIC_ID")
+	if ydbEndpoint == "" || topicName == "" {
+		for _, data := range messages {
+			log.Printf("Rejected record (YDS_INVALID_TOPIC_ID is not set): %s", data)
+		}
+		return nil
+	}
+	if err := writeToTopic(ctx, ydbEndpoint, topicName, messages...); err != nil {
+		return fmt.Errorf("failed to route %d rejected records: %w", len(rejected), err)
+	}
+	log.Printf("Routed %d rejected records to %s", len(rejected), topicName)
+	return nil
+}
+
+// processEvent processes a single event from the stream.
+// Its effects are made through tx, so they commit together with the record of the processed event.
+func processEvent(ctx context.Context, tx table.TransactionActor, event Event, metadata EventMetadata) error {
+	userID, action, message := event.UserID, event.Action, event.Message
+	timestamp := time.Unix(event.Timestamp, 0)
 
 	log.Printf("Processing event - User: %s, Action: %s, Message: %s", userID, action, message)
 
 	// Simulate some processing logic
 	switch action {
 	case "login":
-		log.Printf("User %s logged in at %v", userID, time.Unix(int64(timestamp), 0))
+		log.Printf("User %s logged in at %v", userID, timestamp)
 	case "logout":
-		log.Printf("User %s logged out at %v", userID, time.Unix(int64(timestamp), 0))
+		log.Printf("User %s logged out at %v", userID, timestamp)
 	case "purchase":
-		log.Printf("User %s made a purchase: %s", userID, message)
+		if event.Version >= 2 {
+			log.Printf("User %s made a purchase: %s for %v %v",
+				userID, message, event.Properties["amount"], event.Properties["currency"])
+		} else {
+			log.Printf("User %s made a purchase: %s", userID, message)
+		}
 	case "view":
 		log.Printf("User %s viewed: %s", userID, message)
 	default:
 		log.Printf("Unknown action '%s' from user %s", action, userID)
 	}
 
-	// Simulate processing time
-	time.Sleep(50 * time.Millisecond)
+	// Store the event in the user's history
+	res, err := tx.Execute(ctx, `
+		DECLARE $user_id AS Utf8;
+		DECLARE $event_id AS Utf8;
+		DECLARE $action AS Utf8;
+		DECLARE $message AS Utf8;
+		DECLARE $produced_at AS Timestamp;
+		DECLARE $created_at AS Timestamp;
+		UPSERT INTO user_events (user_id, event_id, action, message, produced_at, created_at)
+		VALUES ($user_id, $event_id, $action, $message, $produced_at, $created_at);
+	`, table.NewQueryParameters(
+		table.ValueParam("$user_id", types.TextValue(userID)),
+		table.ValueParam("$event_id", types.TextValue(metadata.EventID)),
+		table.ValueParam("$action", types.TextValue(action)),
+		table.ValueParam("$message", types.TextValue(message)),
+		table.ValueParam("$produced_at", types.TimestampValueFromTime(timestamp)),
+		table.ValueParam("$created_at", types.TimestampValueFromTime(metadata.CreatedAt)),
+	))
+	if err != nil {
+		return fmt.Errorf("failed to store event: %w", err)
+	}
+	if err = res.Close(); err != nil {
+		return fmt.Errorf("failed to store event: %w", err)
+	}
 
-	// In a real implementation, you might:
-	// - Store data in a database
+	// Count the action in the user's window
+	if err = countEvent(ctx, tx, event, metadata); err != nil {
+		return err
+	}
+
+	// In a real implementation, you might also:
 	// - Send notifications
-	// - Update analytics
 	// - Trigger other workflows
 	// - Send data to external systems
 
 	return nil
 }
 
-// BatchProcessor processes multiple events in a batch
-func BatchProcessor(ctx context.Context, events []map[string]interface{}) error {
+// BatchProcessor processes multiple events in a batch, storing and counting every event once.
+// It returns the errors of the events that could not be processed; the other events are processed anyway.
+func BatchProcessor(ctx context.Context, store eventStore, events []ConsumedEvent) error {
 	log.Printf("Processing batch of %d events", len(events))
 
 	// Group events by user for batch processing
-	userEvents := make(map[string][]map[string]interface{})
+	var users []string
+	userEvents := make(map[string][]ConsumedEvent)
 	for _, event := range events {
-		if userID, ok := event["user_id"].(string); ok {
-			userEvents[userID] = append(userEvents[userID], event)
+		if _, ok := userEvents[event.UserID]; !ok {
+			users = append(users, event.UserID)
 		}
+		userEvents[event.UserID] = append(userEvents[event.UserID], event)
 	}
 
 	// Process events by user
-	for userID, events := range userEvents {
+	var errs []error
+	for _, userID := range users {
+		events := userEvents[userID]
 		log.Printf("Processing %d events for user %s", len(events), userID)
 
 		// Process user's events
 		for _, event := range events {
-			if err := processEvent(ctx, event, EventMetadata{}); err != nil {
+			_, err := store.ProcessOnce(ctx, event.Metadata.EventID,
+				func(ctx context.Context, tx table.TransactionActor) error {
+					return processEvent(ctx, tx, event.Event, event.Metadata)
+				},
+			)
+			if err != nil {
 				log.Printf("Error processing event for user %s: %v", userID, err)
+				errs = append(errs, fmt.Errorf("event %s of user %s: %w", event.Metadata.EventID, userID, err))
 			}
 		}
 	}
 
-	return nil
+	return errors.Join(errs...)
 }
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/ydb-platform/ydb-go-sdk/v3/table"
+)
+
+// fakeStore records the processed event IDs in memory. It does not run the processing itself,
+// whose effects need YDB.
+type fakeStore struct {
+	mu        sync.Mutex
+	processed map[string]bool
+	// fail holds the error the next processing of an event fails with
+	fail map[string]error
+}
+
+func newFakeStore(processed ...string) *fakeStore {
+	s := &fakeStore{processed: map[string]bool{}, fail: map[string]error{}}
+	for _, id := range processed {
+		s.processed[id] = true
+	}
+	return s
+}
+
+func (s *fakeStore) Processed(_ context.Context, eventID string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.processed[eventID], nil
+}
+
+func (s *fakeStore) ProcessOnce(
+	_ context.Context,
+	eventID string,
+	_ func(ctx context.Context, tx table.TransactionActor) error,
+) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err, ok := s.fail[eventID]; ok {
+		delete(s.fail, eventID)
+		return false, err
+	}
+	if s.processed[eventID] {
+		return false, nil
+	}
+	s.processed[eventID] = true
+	return true, nil
+}
+
+// stored keeps the records a consumer flushes.
+type stored struct {
+	rejected []RejectedRecord
+	letters  []DeadLetter
+}
+
+// newTestConsumer creates a consumer flushing its records into stored, failing with the errors if they are set.
+func newTestConsumer(store eventStore, out *stored, routeErr, sendErr error) *batchConsumer {
+	b := newBatchConsumer(store)
+	b.routeRejected = func(_ context.Context, rejected []RejectedRecord) error {
+		if routeErr != nil {
+			return routeErr
+		}
+		out.rejected = append(out.rejected, rejected...)
+		return nil
+	}
+	b.sendDeadLetters = func(_ context.Context, letters []DeadLetter) error {
+		if sendErr != nil {
+			return sendErr
+		}
+		out.letters = append(out.letters, letters...)
+		return nil
+	}
+	return b
+}
+
+const (
+	validRecord   = `{"action":"login","user_id":"user123","timestamp":1735689600}`
+	invalidRecord = `{"action":"login","user_id":"","timestamp":1735689600}`
+)
+
+// consumeAll consumes the records by their event IDs.
+func consumeAll(t *testing.T, b *batchConsumer, records map[string]string) {
+	t.Helper()
+	for _, id := range []string{"e1", "e2", "e3", "e4"} {
+		if data, ok := records[id]; ok {
+			if err := b.Consume(context.Background(), data, EventMetadata{EventID: id}); err != nil {
+				t.Fatalf("Consume(%s): %v", id, err)
+			}
+		}
+	}
+}
+
+func TestConsumeSkipsProcessedEvents(t *testing.T) {
+	b := newTestConsumer(newFakeStore("e1"), &stored{}, nil, nil)
+	consumeAll(t, b, map[string]string{"e1": validRecord, "e2": validRecord})
+	if b.processed != 1 || b.duplicates != 1 {
+		t.Errorf("processed %d, skipped %d", b.processed, b.duplicates)
+	}
+}
+
+func TestRedeliveredBatchIsNotStoredAgain(t *testing.T) {
+	store := newFakeStore()
+	store.fail["e2"] = errors.New("permanent failure")
+	records := map[string]string{"e1": validRecord, "e2": validRecord, "e3": invalidRecord}
+
+	out := &stored{}
+	b := newTestConsumer(store, out, nil, nil)
+	consumeAll(t, b, records)
+	if err := b.Flush(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if len(out.letters) != 1 || out.letters[0].EventMetadata.EventID != "e2" {
+		t.Errorf("dead letters %+v", out.letters)
+	}
+	if len(out.rejected) != 1 || out.rejected[0].EventMetadata.EventID != "e3" {
+		t.Errorf("rejected %+v", out.rejected)
+	}
+
+	// The trigger delivers the same batch again
+	b = newTestConsumer(store, out, nil, nil)
+	consumeAll(t, b, records)
+	if err := b.Flush(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if b.duplicates != 3 || len(out.letters) != 1 || len(out.rejected) != 1 {
+		t.Errorf("skipped %d, %d dead letters, %d rejected in total", b.duplicates, len(out.letters), len(out.rejected))
+	}
+}
+
+func TestFlushRecordsEventsOnlyOnceStored(t *testing.T) {
+	for name, errs := range map[string][2]error{
+		"routing fails":        {errors.New("invalid topic unavailable"), nil},
+		"dead-lettering fails": {nil, errors.New("dead-letter topic unavailable")},
+	} {
+		t.Run(name, func(t *testing.T) {
+			store := newFakeStore()
+			store.fail["e2"] = errors.New("permanent failure")
+			b := newTestConsumer(store, &stored{}, errs[0], errs[1])
+			consumeAll(t, b, map[string]string{"e2": validRecord, "e3": invalidRecord})
+			if err := b.Flush(context.Background()); err == nil {
+				t.Fatal("Flush succeeded")
+			}
+			// Neither record is stored, so the redelivery must handle them again
+			if store.processed["e2"] || store.processed["e3"] {
+				t.Errorf("events recorded as processed: %v", store.processed)
+			}
+		})
+	}
+}
+
+func TestConsumeRetriesTransientFailures(t *testing.T) {
+	t.Setenv("YDS_RETRY_TRANSIENT", "true")
+	store := newFakeStore()
+	store.fail["e1"] = Transient(errors.New("downstream timeout"))
+	b := newTestConsumer(store, &stored{}, nil, nil)
+	if err := b.Consume(context.Background(), validRecord, EventMetadata{EventID: "e1"}); !IsTransient(err) {
+		t.Errorf("got %v, want the transient error", err)
+	}
+	if len(b.failed) != 0 {
+		t.Errorf("the record is dead-lettered")
+	}
+}
+
+func TestBatchProcessorReturnsErrors(t *testing.T) {
+	store := newFakeStore()
+	store.fail["e2"] = errors.New("permanent failure")
+	events := []ConsumedEvent{
+		{Event: Event{UserID: "user123"}, Metadata: EventMetadata{EventID: "e1"}},
+		{Event: Event{UserID: "user456"}, Metadata: EventMetadata{EventID: "e2"}},
+		{Event: Event{UserID: "user123"}, Metadata: EventMetadata{EventID: "e3"}},
+	}
+	err := BatchProcessor(context.Background(), store, events)
+	if err == nil || !strings.Contains(err.Error(), "event e2 of user user456") {
+		t.Fatalf("got %v", err)
+	}
+	if !store.processed["e1"] || !store.processed["e3"] {
+		t.Errorf("the other events are not processed: %v", store.processed)
+	}
+}
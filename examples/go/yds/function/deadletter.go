@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"time"
+)
+
+// DeadLetter is a record the consumer failed to process, written to the dead-letter topic.
+// It keeps everything needed to process the record again with the replay command.
+type DeadLetter struct {
+	Data          string        `json:"data"`           // The original data of the record
+	Error         string        `json:"error"`          // The error the processing failed with
+	EventMetadata EventMetadata `json:"event_metadata"` // The metadata of the trigger message
+	FailedAt      time.Time     `json:"failed_at"`      // When the processing failed
+}
+
+// TransientError marks a processing failure that may succeed if the record is processed again later,
+// e.g. a timeout of a downstream service.
+type TransientError struct {
+	Err error
+}
+
+func (e *TransientError) Error() string { return "transient: " + e.Err.Error() }
+
+func (e *TransientError) Unwrap() error { return e.Err }
+
+// Transient wraps the error as a TransientError.
+func Transient(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &TransientError{Err: err}
+}
+
+// IsTransient reports whether the error is worth retrying: a TransientError or an exceeded deadline.
+func IsTransient(err error) bool {
+	var transient *TransientError
+	return errors.As(err, &transient) || errors.Is(err, context.DeadlineExceeded)
+}
+
+// retryTransient reports whether YDS_RETRY_TRANSIENT asks to fail the batch on transient errors,
+// so the trigger delivers it again, instead of dead-lettering the record.
+func retryTransient() bool {
+	retry, _ := strconv.ParseBool(os.Getenv("YDS_RETRY_TRANSIENT"))
+	return retry
+}
+
+// sendDeadLetters writes the failed records to the topic from YDS_DEAD_LETTER_TOPIC_ID.
+// Without the topic the records are only logged.
+func sendDeadLetters(ctx context.Context, letters []DeadLetter) error {
+	if len(letters) == 0 {
+		return nil
+	}
+	messages := make([][]byte, len(letters))
+	for i, letter := range letters {
+		data, err := json.Marshal(letter)
+		if err != nil {
+			return fmt.Errorf("failed to marshal dead letter: %w", err)
+		}
+		messages[i] = data
+	}
+
+	ydbEndpoint := os.Getenv("YDB_ENDPOINT")
+	topicName := os.Getenv("YDS_DEAD_LETTER_TOPIC_ID")
+	if ydbEndpoint == "" || topicName == "" {
+		for _, data := range messages {
+			log.Printf("Dead letter (YDS_DEAD_LETTER_TOPIC_ID is not set): %s", data)
+		}
+		return nil
+	}
+	if err := writeToTopic(ctx, ydbEndpoint, topicName, messages...); err != nil {
+		return fmt.Errorf("failed to write %d dead letters: %w", len(letters), err)
+	}
+	log.Printf("Wrote %d dead letters to %s", len(letters), topicName)
+	return nil
+}
@@ -31,20 +31,95 @@ type YDSDetails struct {
 
 // YDSResponse represents the response from the consumer function
 type YDSResponse struct {
-	StatusCode int    `json:"status_code"`
-	Message    string `json:"message"`
+	StatusCode   int    `json:"status_code"`
+	Message      string `json:"message"`
+	Processed    int    `json:"processed"`
+	Duplicates   int    `json:"duplicates"`
+	Rejected     int    `json:"rejected"`
+	DeadLettered int    `json:"dead_lettered"`
+}
+
+// Event is a user event written to the topic by the producer and read by the consumer.
+// Every action has its own JSON Schema in the schemas directory, see SchemaRegistry.
+type Event struct {
+	Version    int            `json:"version,omitempty"`    // The version of the schema of the action
+	Action     string         `json:"action"`               // The action, it selects the schema
+	UserID     string         `json:"user_id"`              // The user who performed the action
+	Message    string         `json:"message,omitempty"`    // The description of the action
+	Timestamp  int64          `json:"timestamp"`            // Unix time the event was produced at
+	Properties map[string]any `json:"properties,omitempty"` // Action-specific fields described by the schema
+}
+
+// ConsumedEvent is a valid event read from the topic, with the metadata of its message.
+type ConsumedEvent struct {
+	Event
+	Metadata EventMetadata
+}
+
+// RejectedRecord is a record of the topic that is not a valid event.
+// The consumer routes rejected records to a separate topic instead of processing them.
+type RejectedRecord struct {
+	Data          string        `json:"data"`           // The original data of the record
+	Errors        []FieldError  `json:"errors"`         // Why the record was rejected
+	EventMetadata EventMetadata `json:"event_metadata"` // The metadata of the trigger message
 }
 
 // ProducerRequest represents the request to the producer function
 type ProducerRequest struct {
-	Message string `json:"message"`
-	UserID  string `json:"user_id"`
-	Action  string `json:"action"`
+	Version    int            `json:"version,omitempty"` // The schema version, the latest one by default
+	Message    string         `json:"message"`
+	UserID     string         `json:"user_id"`
+	Action     string         `json:"action"`
+	Properties map[string]any `json:"properties,omitempty"`
 }
 
 // ProducerResponse represents the response from the producer function
 type ProducerResponse struct {
-	StatusCode int    `json:"status_code"`
-	Message    string `json:"message"`
-	StreamID   string `json:"stream_id,omitempty"`
+	StatusCode int          `json:"status_code"`
+	Message    string       `json:"message"`
+	StreamID   string       `json:"stream_id,omitempty"`
+	Errors     []FieldError `json:"errors,omitempty"` // The invalid fields of a rejected request
+}
+
+// WrittenRecord tells where a record of a batch was written
+type WrittenRecord struct {
+	Index      int    `json:"index"`       // The position of the record in the request
+	UserID     string `json:"user_id"`     // The user of the record
+	ProducerID string `json:"producer_id"` // The producer ID, the message group of the record
+	SeqNo      int64  `json:"seq_no"`      // The sequence number of the record within its producer
+}
+
+// RecordError describes a record of a batch that was not written
+type RecordError struct {
+	Index  int          `json:"index"`  // The position of the record in the request
+	Errors []FieldError `json:"errors"` // Why the record was not written
+}
+
+// BatchProducerResponse represents the response from the batch producer function
+type BatchProducerResponse struct {
+	StatusCode int             `json:"status_code"`
+	Message    string          `json:"message"`
+	StreamID   string          `json:"stream_id,omitempty"`
+	Records    []WrittenRecord `json:"records,omitempty"` // The written records
+	Invalid    []RecordError   `json:"invalid,omitempty"` // The records rejected by validation, nothing is written
+	Failed     []RecordError   `json:"failed,omitempty"`  // The valid records that could not be written
+}
+
+// WindowCount is the number of actions of a user in a window
+type WindowCount struct {
+	WindowStart time.Time `json:"window_start"`
+	WindowEnd   time.Time `json:"window_end"`
+	Action      string    `json:"action"`
+	Count       uint64    `json:"count"` // The events counted in time
+	Late        uint64    `json:"late"`  // The events that arrived after the window was closed
+}
+
+// AggregatesResponse represents the response from the aggregates function
+type AggregatesResponse struct {
+	StatusCode int           `json:"status_code"`
+	Message    string        `json:"message"`
+	UserID     string        `json:"user_id,omitempty"`
+	From       time.Time     `json:"from"`
+	To         time.Time     `json:"to"`
+	Windows    []WindowCount `json:"windows,omitempty"`
 }
@@ -5,6 +5,8 @@ go 1.23.0
 toolchain go1.23.9
 
 require (
+	github.com/klauspost/compress v1.18.0
+	github.com/santhosh-tekuri/jsonschema/v6 v6.0.3
 	github.com/ydb-platform/ydb-go-sdk/v3 v3.112.0
 	github.com/ydb-platform/ydb-go-yc v0.12.3
 )
@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
@@ -12,7 +13,6 @@ import (
 
 	"github.com/ydb-platform/ydb-go-sdk/v3"
 	"github.com/ydb-platform/ydb-go-sdk/v3/topic/topicwriter"
-	yc "github.com/ydb-platform/ydb-go-yc"
 )
 
 // ProducerHandler handles HTTP requests to write data to YDS stream
@@ -35,30 +35,25 @@ func ProducerHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Validate request
-	if req.Message == "" {
-		http.Error(w, "Message is required", http.StatusBadRequest)
-		return
-	}
-
-	// Create event data
-	eventData := map[string]interface{}{
-		"message":   req.Message,
-		"user_id":   req.UserID,
-		"action":    req.Action,
-		"timestamp": time.Now().Unix(),
-	}
-
-	// Convert to JSON
-	jsonData, err := json.Marshal(eventData)
+	// Build the event and validate it against the schema of its action, so invalid events never reach the topic
+	_, jsonData, err := encodeEvent(req)
 	if err != nil {
-		log.Printf("Error marshaling event data: %v", err)
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		var validationErr *ValidationError
+		if !errors.As(err, &validationErr) {
+			log.Printf("Error encoding event: %v", err)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			return
+		}
+		writeResponse(w, ProducerResponse{
+			StatusCode: http.StatusBadRequest,
+			Message:    "Invalid event",
+			Errors:     validationErr.Fields,
+		})
 		return
 	}
 
 	// Write to YDS topic
-	err = writeToTopic(ctx, ydbEndpoint, topicName, string(jsonData))
+	err = writeToTopic(ctx, ydbEndpoint, topicName, jsonData)
 	if err != nil {
 		log.Printf("Error writing to topic: %v", err)
 		http.Error(w, "Failed to write to topic", http.StatusInternalServerError)
@@ -66,22 +61,49 @@ func ProducerHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Return success response
-	response := ProducerResponse{
-		StatusCode: 200,
+	writeResponse(w, ProducerResponse{
+		StatusCode: http.StatusOK,
 		Message:    "Data written to topic successfully",
 		StreamID:   topicName,
+	})
+}
+
+// encodeEvent builds the event from the request and validates it.
+// The latest schema version of the action is used unless the request asks for another one.
+// Invalid events are reported with a *ValidationError.
+func encodeEvent(req ProducerRequest) (Event, []byte, error) {
+	event := Event{
+		Version:    req.Version,
+		Action:     req.Action,
+		UserID:     req.UserID,
+		Message:    req.Message,
+		Timestamp:  time.Now().Unix(),
+		Properties: req.Properties,
+	}
+	if event.Version == 0 {
+		event.Version = schemas.Latest(event.Action)
 	}
 
+	data, err := json.Marshal(event)
+	if err != nil {
+		return Event{}, nil, fmt.Errorf("failed to marshal event: %w", err)
+	}
+	if _, err = schemas.Decode(data); err != nil {
+		return Event{}, nil, err
+	}
+	return event, data, nil
+}
+
+// writeResponse writes the response as JSON with its status code
+func writeResponse(w http.ResponseWriter, response ProducerResponse) {
 	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
+	w.WriteHeader(response.StatusCode)
 	json.NewEncoder(w).Encode(response)
 }
 
-// writeToTopic writes data to the YDB topic using the YDB Go SDK
-func writeToTopic(ctx context.Context, ydbEndpoint, topicName, data string) error {
-	db, err := ydb.Open(ctx, ydbEndpoint,
-		yc.WithMetadataCredentials(),
-	)
+// writeToTopic writes the messages to the YDB topic using the YDB Go SDK
+func writeToTopic(ctx context.Context, ydbEndpoint, topicName string, messages ...[]byte) error {
+	db, err := ydb.Open(ctx, ydbEndpoint, ydbOptions()...)
 	if err != nil {
 		return fmt.Errorf("failed to connect to YDB: %w", err)
 	}
@@ -93,8 +115,11 @@ func writeToTopic(ctx context.Context, ydbEndpoint, topicName, data string) erro
 	}
 	defer writer.Close(ctx)
 
-	msg := topicwriter.Message{Data: bytes.NewReader([]byte(data))}
-	if err := writer.Write(ctx, msg); err != nil {
+	batch := make([]topicwriter.Message, len(messages))
+	for i, data := range messages {
+		batch[i] = topicwriter.Message{Data: bytes.NewReader(data)}
+	}
+	if err := writer.Write(ctx, batch...); err != nil {
 		return fmt.Errorf("failed to write message to topic: %w", err)
 	}
 
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/ydb-platform/ydb-go-sdk/v3/table"
+	"github.com/ydb-platform/ydb-go-sdk/v3/table/result/named"
+	"github.com/ydb-platform/ydb-go-sdk/v3/table/types"
+)
+
+const (
+	// defaultQueryRange is the range of windows returned when the request has no from.
+	defaultQueryRange = time.Hour
+	// maxQueryRange is the longest range of windows a request may ask for.
+	maxQueryRange = 24 * time.Hour
+)
+
+// AggregatesHandler handles HTTP requests for the action counts of a user.
+// Query parameters: user_id (required), from and to (RFC 3339, the last hour by default)
+// and action to return the counts of a single action.
+func AggregatesHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	if r.Method != http.MethodGet {
+		writeAggregatesResponse(w, AggregatesResponse{StatusCode: http.StatusMethodNotAllowed, Message: "Only GET is allowed"})
+		return
+	}
+	query, err := parseAggregatesQuery(r)
+	if err != nil {
+		writeAggregatesResponse(w, AggregatesResponse{StatusCode: http.StatusBadRequest, Message: err.Error()})
+		return
+	}
+
+	store, err := openStore(ctx)
+	if err != nil {
+		log.Printf("Error opening store: %v", err)
+		writeAggregatesResponse(w, AggregatesResponse{StatusCode: http.StatusInternalServerError, Message: "Failed to connect to YDB"})
+		return
+	}
+	defer store.Close(ctx)
+
+	windows, err := store.Counts(ctx, query)
+	if err != nil {
+		log.Printf("Error querying counts: %v", err)
+		writeAggregatesResponse(w, AggregatesResponse{StatusCode: http.StatusInternalServerError, Message: "Failed to query counts"})
+		return
+	}
+	writeAggregatesResponse(w, AggregatesResponse{
+		StatusCode: http.StatusOK,
+		Message:    fmt.Sprintf("Found %d windows", len(windows)),
+		UserID:     query.UserID,
+		From:       query.From,
+		To:         query.To,
+		Windows:    windows,
+	})
+}
+
+// AggregatesQuery selects the windows returned by AggregatesHandler.
+type AggregatesQuery struct {
+	UserID string
+	Action string    // All actions if empty
+	From   time.Time // Inclusive, the start of the first window
+	To     time.Time // Exclusive
+}
+
+func parseAggregatesQuery(r *http.Request) (AggregatesQuery, error) {
+	params := r.URL.Query()
+	query := AggregatesQuery{
+		UserID: params.Get("user_id"),
+		Action: params.Get("action"),
+		To:     time.Now().UTC(),
+	}
+	if query.UserID == "" {
+		return query, errors.New("user_id is required")
+	}
+	if to := params.Get("to"); to != "" {
+		t, err := time.Parse(time.RFC3339, to)
+		if err != nil {
+			return query, fmt.Errorf("invalid to: %w", err)
+		}
+		query.To = t.UTC()
+	}
+	query.From = query.To.Add(-defaultQueryRange)
+	if from := params.Get("from"); from != "" {
+		t, err := time.Parse(time.RFC3339, from)
+		if err != nil {
+			return query, fmt.Errorf("invalid from: %w", err)
+		}
+		query.From = t.UTC()
+	}
+	query.From = query.From.Truncate(windowSize)
+	if !query.From.Before(query.To) {
+		return query, errors.New("from must be before to")
+	}
+	if query.To.Sub(query.From) > maxQueryRange {
+		return query, fmt.Errorf("the range is longer than %v", maxQueryRange)
+	}
+	return query, nil
+}
+
+// Counts returns the windows of the user in the range of the query, ordered by the start of the window.
+func (s *Store) Counts(ctx context.Context, query AggregatesQuery) ([]WindowCount, error) {
+	readTx := table.TxControl(
+		table.BeginTx(
+			table.WithOnlineReadOnly(),
+		),
+		table.CommitTx(),
+	)
+	var windows []WindowCount
+	err := s.db.Table().Do(ctx, func(ctx context.Context, session table.Session) error {
+		windows = windows[:0]
+		_, res, err := session.Execute(ctx, readTx, `
+			DECLARE $user_id AS Utf8;
+			DECLARE $action AS Utf8;
+			DECLARE $from AS Timestamp;
+			DECLARE $to AS Timestamp;
+			SELECT window_start, action, count, late
+			FROM action_counts
+			WHERE user_id = $user_id AND window_start >= $from AND window_start < $to
+				AND ($action = ""u OR action = $action)
+			ORDER BY window_start, action;
+		`, table.NewQueryParameters(
+			table.ValueParam("$user_id", types.TextValue(query.UserID)),
+			table.ValueParam("$action", types.TextValue(query.Action)),
+			table.ValueParam("$from", types.TimestampValueFromTime(query.From)),
+			table.ValueParam("$to", types.TimestampValueFromTime(query.To)),
+		))
+		if err != nil {
+			return err
+		}
+		defer res.Close()
+		for res.NextResultSet(ctx) {
+			for res.NextRow() {
+				var window WindowCount
+				err = res.ScanNamed(
+					named.Required("window_start", &window.WindowStart),
+					named.Required("action", &window.Action),
+					named.OptionalWithDefault("count", &window.Count),
+					named.OptionalWithDefault("late", &window.Late),
+				)
+				if err != nil {
+					return err
+				}
+				window.WindowEnd = window.WindowStart.Add(windowSize)
+				windows = append(windows, window)
+			}
+		}
+		return res.Err()
+	}, table.WithIdempotent())
+	return windows, err
+}
+
+// writeAggregatesResponse writes the response as JSON with its status code
+func writeAggregatesResponse(w http.ResponseWriter, response AggregatesResponse) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(response.StatusCode)
+	json.NewEncoder(w).Encode(response)
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseAggregatesQuery(t *testing.T) {
+	at := func(value string) time.Time {
+		t, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			panic(err)
+		}
+		return t
+	}
+	tests := []struct {
+		name  string
+		query string
+		want  AggregatesQuery
+		err   string // A part of the error, if the query is rejected
+	}{
+		{
+			name:  "range and action",
+			query: "user_id=user123&action=view&from=2025-01-01T12:00:00Z&to=2025-01-01T13:00:00Z",
+			want: AggregatesQuery{
+				UserID: "user123",
+				Action: "view",
+				From:   at("2025-01-01T12:00:00Z"),
+				To:     at("2025-01-01T13:00:00Z"),
+			},
+		},
+		{
+			name:  "the last hour before to",
+			query: "user_id=user123&to=2025-01-01T13:00:30Z",
+			want: AggregatesQuery{
+				UserID: "user123",
+				From:   at("2025-01-01T12:00:00Z"),
+				To:     at("2025-01-01T13:00:30Z"),
+			},
+		},
+		{
+			name:  "from truncated to its window and converted to UTC",
+			query: "user_id=user123&from=2025-01-01T15:00:45%2B03:00&to=2025-01-01T12:30:00Z",
+			want: AggregatesQuery{
+				UserID: "user123",
+				From:   at("2025-01-01T12:00:00Z"),
+				To:     at("2025-01-01T12:30:00Z"),
+			},
+		},
+		{
+			name:  "no user",
+			query: "from=2025-01-01T12:00:00Z",
+			err:   "user_id is required",
+		},
+		{
+			name:  "invalid from",
+			query: "user_id=user123&from=yesterday",
+			err:   "invalid from",
+		},
+		{
+			name:  "invalid to",
+			query: "user_id=user123&to=1735736400",
+			err:   "invalid to",
+		},
+		{
+			name:  "from after to",
+			query: "user_id=user123&from=2025-01-01T13:00:00Z&to=2025-01-01T12:00:00Z",
+			err:   "from must be before to",
+		},
+		{
+			name:  "range too long",
+			query: "user_id=user123&from=2025-01-01T00:00:00Z&to=2025-01-02T00:01:00Z",
+			err:   "the range is longer than",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			query, err := parseAggregatesQuery(httptest.NewRequest("GET", "/?"+tt.query, nil))
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("got error %v, want one with %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if query.UserID != tt.want.UserID || query.Action != tt.want.Action ||
+				!query.From.Equal(tt.want.From) || !query.To.Equal(tt.want.To) {
+				t.Errorf("got %+v, want %+v", query, tt.want)
+			}
+			if query.From.Location() != time.UTC || query.To.Location() != time.UTC {
+				t.Errorf("the range %v - %v is not in UTC", query.From, query.To)
+			}
+		})
+	}
+
+	t.Run("the last hour by default", func(t *testing.T) {
+		before := time.Now()
+		query, err := parseAggregatesQuery(httptest.NewRequest("GET", "/?user_id=user123", nil))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if query.To.Before(before) || query.To.Sub(query.From) < defaultQueryRange ||
+			query.To.Sub(query.From) >= defaultQueryRange+windowSize {
+			t.Errorf("got the range %v - %v", query.From, query.To)
+		}
+	})
+}
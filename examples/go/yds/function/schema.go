@@ -0,0 +1,169 @@
+package main
+
+import (
+	"bytes"
+	"embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"sort"
+	"strings"
+
+	"github.com/santhosh-tekuri/jsonschema/v6"
+)
+
+// schemaFiles holds a JSON Schema per action and version, named <action>.v<version>.json.
+//
+//go:embed schemas/*.json
+var schemaFiles embed.FS
+
+// schemas is the registry of the embedded schemas, shared by the producer and the consumer.
+var schemas = mustLoadSchemas(schemaFiles)
+
+// FieldError is a validation error of a single field of an event.
+type FieldError struct {
+	Field   string `json:"field"`   // The JSON pointer of the field, e.g. /properties/amount.
+	Message string `json:"message"` // The reason the value is invalid.
+}
+
+// ValidationError is returned for records that are not valid events.
+type ValidationError struct {
+	Fields []FieldError
+}
+
+func (e *ValidationError) Error() string {
+	parts := make([]string, len(e.Fields))
+	for i, f := range e.Fields {
+		parts[i] = f.Field + ": " + f.Message
+	}
+	return "invalid event: " + strings.Join(parts, "; ")
+}
+
+func invalid(field, format string, args ...any) *ValidationError {
+	return &ValidationError{Fields: []FieldError{{Field: field, Message: fmt.Sprintf(format, args...)}}}
+}
+
+// SchemaRegistry holds the compiled schemas by action and version.
+type SchemaRegistry struct {
+	schemas map[string]map[int]*jsonschema.Schema
+}
+
+func mustLoadSchemas(files fs.FS) *SchemaRegistry {
+	r, err := LoadSchemas(files)
+	if err != nil {
+		panic(err)
+	}
+	return r
+}
+
+// LoadSchemas compiles all schemas in the schemas directory of files.
+func LoadSchemas(files fs.FS) (*SchemaRegistry, error) {
+	names, err := fs.Glob(files, "schemas/*.json")
+	if err != nil {
+		return nil, err
+	}
+	r := &SchemaRegistry{schemas: map[string]map[int]*jsonschema.Schema{}}
+	compiler := jsonschema.NewCompiler()
+	for _, name := range names {
+		var action string
+		var version int
+		base := strings.TrimPrefix(name, "schemas/")
+		if _, err = fmt.Sscanf(strings.Replace(base, ".v", " ", 1), "%s %d.json", &action, &version); err != nil {
+			return nil, fmt.Errorf("schema %s is not named <action>.v<version>.json", name)
+		}
+		data, err := fs.ReadFile(files, name)
+		if err != nil {
+			return nil, err
+		}
+		doc, err := jsonschema.UnmarshalJSON(bytes.NewReader(data))
+		if err != nil {
+			return nil, fmt.Errorf("schema %s: %w", name, err)
+		}
+		if err = compiler.AddResource(name, doc); err != nil {
+			return nil, fmt.Errorf("schema %s: %w", name, err)
+		}
+		schema, err := compiler.Compile(name)
+		if err != nil {
+			return nil, fmt.Errorf("schema %s: %w", name, err)
+		}
+		if r.schemas[action] == nil {
+			r.schemas[action] = map[int]*jsonschema.Schema{}
+		}
+		r.schemas[action][version] = schema
+	}
+	return r, nil
+}
+
+// Actions returns the sorted names of the known actions.
+func (r *SchemaRegistry) Actions() []string {
+	actions := make([]string, 0, len(r.schemas))
+	for action := range r.schemas {
+		actions = append(actions, action)
+	}
+	sort.Strings(actions)
+	return actions
+}
+
+// Latest returns the latest schema version of the action, or 0 if the action is unknown.
+func (r *SchemaRegistry) Latest(action string) int {
+	latest := 0
+	for version := range r.schemas[action] {
+		latest = max(latest, version)
+	}
+	return latest
+}
+
+// Decode parses the record and validates it against the schema of its action and version.
+// Records without a version were written before the schemas were versioned and are checked against version 1.
+// Invalid records are reported with a *ValidationError listing the invalid fields.
+func (r *SchemaRegistry) Decode(data []byte) (Event, error) {
+	var event Event
+	if err := json.Unmarshal(data, &event); err != nil {
+		return Event{}, invalid("/", "invalid JSON: %v", err)
+	}
+	if event.Version == 0 {
+		event.Version = 1
+	}
+	versions, ok := r.schemas[event.Action]
+	if !ok {
+		return Event{}, invalid("/action", "unknown action %q, expected one of %s",
+			event.Action, strings.Join(r.Actions(), ", "))
+	}
+	schema, ok := versions[event.Version]
+	if !ok {
+		return Event{}, invalid("/version", "unknown version %d of action %s", event.Version, event.Action)
+	}
+
+	doc, err := jsonschema.UnmarshalJSON(bytes.NewReader(data))
+	if err != nil {
+		return Event{}, invalid("/", "invalid JSON: %v", err)
+	}
+	if err = schema.Validate(doc); err != nil {
+		var validationErr *jsonschema.ValidationError
+		if !errors.As(err, &validationErr) {
+			return Event{}, err
+		}
+		return Event{}, &ValidationError{Fields: fieldErrors(validationErr)}
+	}
+	return event, nil
+}
+
+// fieldErrors flattens the validation error into the errors of individual fields.
+func fieldErrors(err *jsonschema.ValidationError) []FieldError {
+	var fields []FieldError
+	for _, unit := range err.BasicOutput().Errors {
+		if unit.Error == nil {
+			continue
+		}
+		field := unit.InstanceLocation
+		if field == "" {
+			field = "/"
+		}
+		fields = append(fields, FieldError{Field: field, Message: unit.Error.String()})
+	}
+	if len(fields) == 0 {
+		fields = append(fields, FieldError{Field: "/", Message: err.Error()})
+	}
+	return fields
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestSchemaRegistryDecode(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		version int      // The version of the decoded event
+		fields  []string // The invalid fields, if the record is invalid
+	}{
+		{
+			name:    "unversioned record checked against v1",
+			data:    `{"action":"login","user_id":"user123","timestamp":1735689600}`,
+			version: 1,
+		},
+		{
+			name:    "latest version",
+			data:    `{"version":2,"action":"purchase","user_id":"user123","message":"Book","timestamp":1735689600,"properties":{"amount":9.5,"currency":"EUR"}}`,
+			version: 2,
+		},
+		{
+			name:   "invalid JSON",
+			data:   `{"action":`,
+			fields: []string{"/"},
+		},
+		{
+			name:   "unknown action",
+			data:   `{"action":"jump","user_id":"user123","timestamp":1735689600}`,
+			fields: []string{"/action"},
+		},
+		{
+			name:   "unknown version",
+			data:   `{"version":7,"action":"login","user_id":"user123","timestamp":1735689600}`,
+			fields: []string{"/version"},
+		},
+		{
+			name:   "missing required field",
+			data:   `{"version":2,"action":"purchase","user_id":"user123","message":"Book","timestamp":1735689600}`,
+			fields: []string{"/"},
+		},
+		{
+			name:   "invalid nested field",
+			data:   `{"version":2,"action":"purchase","user_id":"user123","message":"Book","timestamp":1735689600,"properties":{"amount":9.5,"currency":"euro"}}`,
+			fields: []string{"/properties/currency"},
+		},
+		{
+			name:   "every invalid field is reported",
+			data:   `{"action":"login","user_id":"","timestamp":-1}`,
+			fields: []string{"/timestamp", "/user_id"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			event, err := schemas.Decode([]byte(tt.data))
+			if tt.fields == nil {
+				if err != nil {
+					t.Fatal(err)
+				}
+				if event.Version != tt.version {
+					t.Errorf("version %d, want %d", event.Version, tt.version)
+				}
+				return
+			}
+			var validationErr *ValidationError
+			if !errors.As(err, &validationErr) {
+				t.Fatalf("got %v, want a validation error", err)
+			}
+			for _, field := range tt.fields {
+				found := false
+				for _, f := range validationErr.Fields {
+					found = found || f.Field == field
+				}
+				if !found {
+					t.Errorf("no error of %s in %v", field, validationErr)
+				}
+			}
+		})
+	}
+}
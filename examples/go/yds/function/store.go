@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/ydb-platform/ydb-go-sdk/v3"
+	"github.com/ydb-platform/ydb-go-sdk/v3/table"
+	"github.com/ydb-platform/ydb-go-sdk/v3/table/types"
+	yc "github.com/ydb-platform/ydb-go-yc"
+)
+
+// ydbOptions returns the options of the connections to YDB. The functions authenticate with the metadata service,
+// the command line tool replaces them with the credentials it is given.
+var ydbOptions = func() []ydb.Option {
+	return []ydb.Option{yc.WithMetadataCredentials()}
+}
+
+// Store keeps the effects of the consumer in YDB together with the IDs of the events that caused them.
+// The tables are created by Terraform, see tf/yds.tf.
+type Store struct {
+	db *ydb.Driver
+}
+
+// openStore connects to the database from YDB_ENDPOINT.
+func openStore(ctx context.Context) (*Store, error) {
+	ydbEndpoint := os.Getenv("YDB_ENDPOINT")
+	if ydbEndpoint == "" {
+		return nil, errors.New("YDB_ENDPOINT environment variable must be set")
+	}
+	db, err := ydb.Open(ctx, ydbEndpoint, ydbOptions()...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to YDB: %w", err)
+	}
+	return &Store{db: db}, nil
+}
+
+// Close closes the connection to YDB.
+func (s *Store) Close(ctx context.Context) error {
+	return s.db.Close(ctx)
+}
+
+// Processed reports whether the event ID is recorded in the processed_events table. Events without an ID
+// are never recorded.
+func (s *Store) Processed(ctx context.Context, eventID string) (bool, error) {
+	if eventID == "" {
+		return false, nil
+	}
+	found := false
+	err := s.db.Table().DoTx(ctx, func(ctx context.Context, tx table.TransactionActor) error {
+		res, err := tx.Execute(ctx, `
+			DECLARE $event_id AS Utf8;
+			SELECT event_id FROM processed_events WHERE event_id = $event_id;
+		`, table.NewQueryParameters(
+			table.ValueParam("$event_id", types.TextValue(eventID)),
+		))
+		if err != nil {
+			return err
+		}
+		found = res.NextResultSet(ctx) && res.NextRow()
+		return res.Close()
+	}, table.WithIdempotent())
+	if err != nil {
+		return false, Transient(fmt.Errorf("failed to check event %s in YDB: %w", eventID, err))
+	}
+	return found, nil
+}
+
+// ProcessOnce runs process in a transaction that also records the event ID in the processed_events table.
+// If the ID is already recorded, an earlier delivery of the event has been processed: process is not called
+// and ProcessOnce returns false. The check, the effects and the record commit together, so the effects
+// of an event happen once however many times the event is delivered.
+//
+// The transaction is retried on conflicts, so process must make its effects only through tx.
+// Events without an ID are processed in a transaction as well, but are not deduplicated.
+// YDB errors are transient, other errors of process are returned as they are.
+func (s *Store) ProcessOnce(
+	ctx context.Context,
+	eventID string,
+	process func(ctx context.Context, tx table.TransactionActor) error,
+) (bool, error) {
+	var processErr error
+	duplicate := false
+	err := s.db.Table().DoTx(ctx, func(ctx context.Context, tx table.TransactionActor) error {
+		processErr, duplicate = nil, false
+		if eventID != "" {
+			res, err := tx.Execute(ctx, `
+				DECLARE $event_id AS Utf8;
+				SELECT event_id FROM processed_events WHERE event_id = $event_id;
+			`, table.NewQueryParameters(
+				table.ValueParam("$event_id", types.TextValue(eventID)),
+			))
+			if err != nil {
+				return err
+			}
+			duplicate = res.NextResultSet(ctx) && res.NextRow()
+			if err = res.Close(); err != nil {
+				return err
+			}
+			if duplicate {
+				return nil
+			}
+		}
+
+		if processErr = process(ctx, tx); processErr != nil {
+			return processErr
+		}
+
+		if eventID == "" {
+			return nil
+		}
+		res, err := tx.Execute(ctx, `
+			DECLARE $event_id AS Utf8;
+			DECLARE $processed_at AS Timestamp;
+			UPSERT INTO processed_events (event_id, processed_at) VALUES ($event_id, $processed_at);
+		`, table.NewQueryParameters(
+			table.ValueParam("$event_id", types.TextValue(eventID)),
+			table.ValueParam("$processed_at", types.TimestampValueFromTime(time.Now().UTC())),
+		))
+		if err != nil {
+			return err
+		}
+		return res.Close()
+	}, table.WithIdempotent())
+	if processErr != nil && !ydb.IsYdbError(processErr) {
+		return false, processErr
+	}
+	if err != nil {
+		return false, Transient(fmt.Errorf("failed to process event %s in YDB: %w", eventID, err))
+	}
+	return !duplicate, nil
+}
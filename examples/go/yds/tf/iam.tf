@@ -10,6 +10,12 @@ resource "yandex_iam_service_account" "consumer_sa" {
   folder_id = var.folder_id
 }
 
+# Service account for aggregates function
+resource "yandex_iam_service_account" "aggregates_sa" {
+  name      = "yds-aggregates-sa"
+  folder_id = var.folder_id
+}
+
 # Service account for trigger
 resource "yandex_iam_service_account" "trigger_sa" {
   name      = "yds-trigger-sa"
@@ -33,7 +39,7 @@ resource "yandex_resourcemanager_folder_iam_binding" "producer_sa" {
 # IAM bindings for consumer service account
 resource "yandex_resourcemanager_folder_iam_binding" "consumer_sa" {
   for_each = toset([
-    "ydb.viewer",
+    "ydb.editor",
     "functions.functionInvoker",
   ])
   role      = each.value
@@ -44,6 +50,19 @@ resource "yandex_resourcemanager_folder_iam_binding" "consumer_sa" {
   sleep_after = 5
 }
 
+# IAM bindings for aggregates service account, it only reads the counts
+resource "yandex_resourcemanager_folder_iam_binding" "aggregates_sa" {
+  for_each = toset([
+    "ydb.viewer",
+  ])
+  role      = each.value
+  folder_id = var.folder_id
+  members   = [
+    "serviceAccount:${yandex_iam_service_account.aggregates_sa.id}",
+  ]
+  sleep_after = 5
+}
+
 # IAM bindings for trigger service account
 resource "yandex_resourcemanager_folder_iam_binding" "trigger_sa" {
   for_each = toset([
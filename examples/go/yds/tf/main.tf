@@ -36,6 +36,34 @@ resource "yandex_function" "producer_function" {
   ]
 }
 
+# Batch producer function - writes arrays or NDJSON of events to YDS stream in one batch
+resource "yandex_function" "batch_producer_function" {
+  name               = "yds-batch-producer"
+  user_hash          = archive_file.function_files.output_sha256
+  runtime            = local.runtime
+  entrypoint         = "main.BatchProducerHandler"
+  memory             = "256"
+  execution_timeout  = "30"
+  service_account_id = yandex_iam_service_account.producer_sa.id
+
+  content {
+    zip_filename = archive_file.function_files.output_path
+  }
+
+  environment = {
+    YDB_ENDPOINT     = yandex_ydb_database_serverless.yds_db.ydb_full_endpoint
+    YDS_TOPIC_ID     = yandex_ydb_topic.main_topic.name
+    YDS_WRITER_CODEC = var.writer_codec
+  }
+
+  depends_on = [
+    yandex_ydb_database_serverless.yds_db,
+    yandex_ydb_topic.main_topic,
+    yandex_iam_service_account.producer_sa,
+    yandex_resourcemanager_folder_iam_binding.producer_sa,
+  ]
+}
+
 # Consumer function - triggered by YDS events
 resource "yandex_function" "consumer_function" {
   name               = "yds-consumer"
@@ -50,14 +78,53 @@ resource "yandex_function" "consumer_function" {
     zip_filename = archive_file.function_files.output_path
   }
 
+  environment = {
+    YDB_ENDPOINT             = yandex_ydb_database_serverless.yds_db.ydb_full_endpoint
+    YDS_INVALID_TOPIC_ID     = yandex_ydb_topic.invalid_topic.name
+    YDS_DEAD_LETTER_TOPIC_ID = yandex_ydb_topic.dead_letter_topic.name
+    YDS_RETRY_TRANSIENT      = tostring(var.retry_transient)
+    YDS_ALLOWED_LATENESS     = var.allowed_lateness
+  }
+
   depends_on = [
     yandex_ydb_database_serverless.yds_db,
     yandex_ydb_topic.main_topic,
+    yandex_ydb_topic.invalid_topic,
+    yandex_ydb_topic.dead_letter_topic,
+    yandex_ydb_table.processed_events,
+    yandex_ydb_table.user_events,
+    yandex_ydb_table.action_counts,
     yandex_iam_service_account.consumer_sa,
     yandex_resourcemanager_folder_iam_binding.consumer_sa,
   ]
 }
 
+# Aggregates function - returns the action counts of a user by one-minute windows
+resource "yandex_function" "aggregates_function" {
+  name               = "yds-aggregates"
+  user_hash          = archive_file.function_files.output_sha256
+  runtime            = local.runtime
+  entrypoint         = "main.AggregatesHandler"
+  memory             = "128"
+  execution_timeout  = "10"
+  service_account_id = yandex_iam_service_account.aggregates_sa.id
+
+  content {
+    zip_filename = archive_file.function_files.output_path
+  }
+
+  environment = {
+    YDB_ENDPOINT = yandex_ydb_database_serverless.yds_db.ydb_full_endpoint
+  }
+
+  depends_on = [
+    yandex_ydb_database_serverless.yds_db,
+    yandex_ydb_table.action_counts,
+    yandex_iam_service_account.aggregates_sa,
+    yandex_resourcemanager_folder_iam_binding.aggregates_sa,
+  ]
+}
+
 # YDS Trigger - links topic to consumer function
 resource "yandex_function_trigger" "yds_trigger" {
   name = "yds-trigger"
@@ -89,4 +156,20 @@ resource "yandex_function_iam_binding" "producer_binding" {
   function_id = yandex_function.producer_function.id
   role        = "functions.functionInvoker"
   members = ["system:allUsers"]
-} 
\ No newline at end of file
+}
+
+# IAM binding for making batch producer function public
+resource "yandex_function_iam_binding" "batch_producer_binding" {
+  function_id = yandex_function.batch_producer_function.id
+  role        = "functions.functionInvoker"
+  members = ["system:allUsers"]
+}
+
+# The aggregates function returns the counts of any user, so it is private: only the members of
+# aggregates_invokers and the accounts with the functions.functionInvoker role in the folder can call it
+resource "yandex_function_iam_binding" "aggregates_binding" {
+  count       = length(var.aggregates_invokers) > 0 ? 1 : 0
+  function_id = yandex_function.aggregates_function.id
+  role        = "functions.functionInvoker"
+  members     = var.aggregates_invokers
+}
@@ -6,6 +6,14 @@ output "producer_function_url" {
   value = "https://functions.yandexcloud.net/${yandex_function.producer_function.id}"
 }
 
+output "batch_producer_function_url" {
+  value = "https://functions.yandexcloud.net/${yandex_function.batch_producer_function.id}"
+}
+
+output "aggregates_function_url" {
+  value = "https://functions.yandexcloud.net/${yandex_function.aggregates_function.id}"
+}
+
 output "consumer_function_id" {
   value = yandex_function.consumer_function.id
 }
@@ -18,10 +26,22 @@ output "yds_topic_name" {
   value = yandex_ydb_topic.main_topic.name
 }
 
+output "yds_invalid_topic_name" {
+  value = yandex_ydb_topic.invalid_topic.name
+}
+
+output "yds_dead_letter_topic_name" {
+  value = yandex_ydb_topic.dead_letter_topic.name
+}
+
 output "yds_database_id" {
   value = yandex_ydb_database_serverless.yds_db.id
 }
 
+output "yds_database_endpoint" {
+  value = yandex_ydb_database_serverless.yds_db.ydb_full_endpoint
+}
+
 output "yds_database_path" {
   value = yandex_ydb_database_serverless.yds_db.database_path
 }
@@ -13,4 +13,40 @@ variable "zone" {
 
 variable "yc_token" {
   type = string
-}
\ No newline at end of file
+}
+
+variable "retry_transient" {
+  description = "Fail the consumer batch on transient errors, so the trigger retries it, instead of dead-lettering the record"
+  type        = bool
+  default     = false
+}
+
+variable "writer_codec" {
+  description = "Codec the batch producer compresses messages with: raw, gzip or zstd"
+  type        = string
+  default     = "raw"
+}
+
+variable "dedup_ttl" {
+  description = "How long the consumer remembers processed events to skip their redeliveries, ISO 8601 duration"
+  type        = string
+  default     = "P7D"
+}
+
+variable "allowed_lateness" {
+  description = "How long after the end of a window its events are still counted, Go duration"
+  type        = string
+  default     = "2m"
+}
+
+variable "aggregates_ttl" {
+  description = "How long the action counts are kept, ISO 8601 duration"
+  type        = string
+  default     = "P30D"
+}
+
+variable "aggregates_invokers" {
+  description = "Who may call the aggregates function besides the folder's invokers, e.g. serviceAccount:<id>"
+  type        = list(string)
+  default     = []
+}
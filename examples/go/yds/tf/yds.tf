@@ -12,9 +12,148 @@ resource "yandex_ydb_topic" "main_topic" {
   name = "yds-demo-topic"
   supported_codecs = [
     "raw",
+    "gzip",
+    "zstd",
   ]
   database_endpoint      = yandex_ydb_database_serverless.yds_db.ydb_full_endpoint
   description            = "Demo topic for YDS trigger example"
   partitions_count       = 1
   retention_period_hours = 24
-} 
\ No newline at end of file
+
+  # The read command of the consumer reads the topic with its own consumer, instead of the trigger
+  consumer {
+    name             = "reader"
+    supported_codecs = ["raw", "gzip", "zstd"]
+  }
+}
+
+# YDB Topic for records rejected by the consumer schema validation
+resource "yandex_ydb_topic" "invalid_topic" {
+  name = "yds-demo-invalid"
+  supported_codecs = [
+    "raw",
+  ]
+  database_endpoint      = yandex_ydb_database_serverless.yds_db.ydb_full_endpoint
+  description            = "Records of the demo topic that do not match their schema"
+  partitions_count       = 1
+  retention_period_hours = 168
+}
+
+# YDB Topic for records the consumer failed to process
+resource "yandex_ydb_topic" "dead_letter_topic" {
+  name = "yds-demo-dead-letter"
+  supported_codecs = [
+    "raw",
+  ]
+  database_endpoint      = yandex_ydb_database_serverless.yds_db.ydb_full_endpoint
+  description            = "Records of the demo topic the consumer failed to process"
+  partitions_count       = 1
+  retention_period_hours = 168
+
+  # The replay command reads the dead letters with its own consumer
+  consumer {
+    name             = "replay"
+    supported_codecs = ["raw"]
+  }
+}
+
+# YDB table of the events the consumer has processed, used to skip redelivered events.
+# Rows expire after the TTL, a redelivery later than that is processed again.
+resource "yandex_ydb_table" "processed_events" {
+  path              = "processed_events"
+  connection_string = yandex_ydb_database_serverless.yds_db.ydb_full_endpoint
+
+  column {
+    name     = "event_id"
+    type     = "Utf8"
+    not_null = true
+  }
+  column {
+    name     = "processed_at"
+    type     = "Timestamp"
+    not_null = true
+  }
+
+  primary_key = ["event_id"]
+
+  ttl {
+    column_name     = "processed_at"
+    expire_interval = var.dedup_ttl
+  }
+}
+
+# YDB table of the events processed by the consumer, the effect of processing
+resource "yandex_ydb_table" "user_events" {
+  path              = "user_events"
+  connection_string = yandex_ydb_database_serverless.yds_db.ydb_full_endpoint
+
+  column {
+    name     = "user_id"
+    type     = "Utf8"
+    not_null = true
+  }
+  column {
+    name     = "event_id"
+    type     = "Utf8"
+    not_null = true
+  }
+  column {
+    name = "action"
+    type = "Utf8"
+  }
+  column {
+    name = "message"
+    type = "Utf8"
+  }
+  column {
+    name = "produced_at"
+    type = "Timestamp"
+  }
+  column {
+    name = "created_at"
+    type = "Timestamp"
+  }
+
+  primary_key = ["user_id", "event_id"]
+}
+
+# YDB table of the actions of every user counted in one-minute tumbling windows
+resource "yandex_ydb_table" "action_counts" {
+  path              = "action_counts"
+  connection_string = yandex_ydb_database_serverless.yds_db.ydb_full_endpoint
+
+  column {
+    name     = "user_id"
+    type     = "Utf8"
+    not_null = true
+  }
+  column {
+    name     = "window_start"
+    type     = "Timestamp"
+    not_null = true
+  }
+  column {
+    name     = "action"
+    type     = "Utf8"
+    not_null = true
+  }
+  column {
+    name = "count"
+    type = "Uint64"
+  }
+  column {
+    name = "late"
+    type = "Uint64"
+  }
+  column {
+    name = "updated_at"
+    type = "Timestamp"
+  }
+
+  primary_key = ["user_id", "window_start", "action"]
+
+  ttl {
+    column_name     = "window_start"
+    expire_interval = var.aggregates_ttl
+  }
+}
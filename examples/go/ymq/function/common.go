@@ -2,8 +2,15 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/config"
@@ -12,13 +19,49 @@ import (
 	smithyendpoints "github.com/aws/smithy-go/endpoints"
 )
 
-func sendMessageToQueue(
-	ctx context.Context,
-	ymqName string,
-	message string,
-	origin string,
-	delay int32,
-) (*sqs.SendMessageOutput, error) {
+const (
+	// maxBatchEntries is the largest number of entries SendMessageBatch accepts in one request.
+	maxBatchEntries = 10
+	// defaultEndpoint is the message queue used unless YMQ_ENDPOINT says otherwise.
+	defaultEndpoint = "https://message-queue.api.cloud.yandex.net"
+)
+
+var (
+	queueClientMu sync.Mutex
+	// sharedQueueClient is shared by the invocations of a function instance, so the queue URLs stay cached
+	// between them. It is nil until a client is created.
+	sharedQueueClient *QueueClient
+)
+
+// queueClient returns the shared client, creating it on the first call. A failed creation is not kept:
+// the next invocation tries again.
+func queueClient() (*QueueClient, error) {
+	queueClientMu.Lock()
+	defer queueClientMu.Unlock()
+	if sharedQueueClient != nil {
+		return sharedQueueClient, nil
+	}
+	client, err := NewQueueClient(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	sharedQueueClient = client
+	return sharedQueueClient, nil
+}
+
+// QueueClient sends messages to the queues of Yandex Message Queue by their names.
+// The URLs of the queues are resolved once and cached. It is safe for concurrent use.
+type QueueClient struct {
+	sqs *sqs.Client
+	// payloads keeps the bodies of messages too large for the queue, if set.
+	payloads *PayloadStore
+
+	mu   sync.Mutex
+	urls map[string]string
+}
+
+// NewQueueClient creates a client of Yandex Message Queue.
+func NewQueueClient(ctx context.Context) (*QueueClient, error) {
 	// Load the SDK's configuration from environment and shared config
 	// In the serverless environment, the configuration is loaded from the environment variables
 	// AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY.
@@ -26,48 +69,292 @@ func sendMessageToQueue(
 		ctx,
 	)
 	if err != nil {
-		panic("configuration error, " + err.Error())
+		return nil, fmt.Errorf("configuration error: %w", err)
 	}
 	// Create an Amazon SQS service client
 	client := sqs.NewFromConfig(cfg, func(o *sqs.Options) {
 		o.Region = "ru-central1"
-		o.EndpointResolverV2 = &resolverV2{}
+		o.EndpointResolverV2 = &resolverV2{endpoint: endpoint()}
 	})
+	payloads, err := payloadStoreFromEnv(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return NewQueueClientFromSQS(client).WithPayloadStore(payloads), nil
+}
 
-	gQInput := &sqs.GetQueueUrlInput{
-		QueueName: &ymqName,
+// NewQueueClientFromSQS creates a client that sends messages with the given SQS client.
+func NewQueueClientFromSQS(client *sqs.Client) *QueueClient {
+	return &QueueClient{sqs: client, urls: map[string]string{}}
+}
+
+// WithPayloadStore makes the client offload the bodies of messages over the threshold of the store to its bucket,
+// sending pointer messages instead, and fetch the bodies of pointer messages it receives.
+// A nil store turns offloading off.
+func (c *QueueClient) WithPayloadStore(store *PayloadStore) *QueueClient {
+	c.payloads = store
+	return c
+}
+
+// QueueURL returns the URL of the queue, asking it from the service only the first time.
+func (c *QueueClient) QueueURL(ctx context.Context, queueName string) (string, error) {
+	c.mu.Lock()
+	queueURL, ok := c.urls[queueName]
+	c.mu.Unlock()
+	if ok {
+		return queueURL, nil
 	}
 
-	urlRes, err := client.GetQueueUrl(ctx, gQInput)
+	urlRes, err := c.sqs.GetQueueUrl(ctx, &sqs.GetQueueUrlInput{
+		QueueName: &queueName,
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to get the URL of queue %s: %w", queueName, err)
+	}
+
+	c.mu.Lock()
+	c.urls[queueName] = *urlRes.QueueUrl
+	c.mu.Unlock()
+	return *urlRes.QueueUrl, nil
+}
+
+// Attributes are the message attributes of a message, by their names.
+type Attributes map[string]types.MessageAttributeValue
 
+// StringAttribute returns an attribute of the String type.
+func StringAttribute(value string) types.MessageAttributeValue {
+	return types.MessageAttributeValue{
+		DataType:    aws.String("String"),
+		StringValue: aws.String(value),
+	}
+}
+
+// NumberAttribute returns an attribute of the Number type.
+func NumberAttribute[T int | int32 | int64 | uint | uint32 | uint64 | float32 | float64](value T) types.MessageAttributeValue {
+	var s string
+	switch v := any(value).(type) {
+	case float32:
+		s = strconv.FormatFloat(float64(v), 'f', -1, 32)
+	case float64:
+		s = strconv.FormatFloat(v, 'f', -1, 64)
+	default:
+		s = fmt.Sprint(v)
+	}
+	return types.MessageAttributeValue{
+		DataType:    aws.String("Number"),
+		StringValue: aws.String(s),
+	}
+}
+
+// BinaryAttribute returns an attribute of the Binary type.
+func BinaryAttribute(value []byte) types.MessageAttributeValue {
+	return types.MessageAttributeValue{
+		DataType:    aws.String("Binary"),
+		BinaryValue: value,
+	}
+}
+
+// Message is a message to send to a queue.
+type Message struct {
+	Body       string
+	Attributes Attributes
+	Delay      int32 // Seconds the message is invisible after it is sent, not supported by FIFO queues
+
+	// GroupID is the message group of a message of a FIFO queue, required by them.
+	// The messages of a group are delivered in the order they were sent.
+	GroupID string
+	// DeduplicationID identifies the message of a FIFO queue: messages with the ID of a message sent within
+	// the last five minutes are accepted but not delivered. If it is empty, the queue has to deduplicate
+	// messages by their content.
+	DeduplicationID string
+}
+
+// IsFIFO reports whether the queue is a FIFO queue, by its name.
+func IsFIFO(queueName string) bool {
+	return strings.HasSuffix(queueName, ".fifo")
+}
+
+// Send sends a single message to the queue.
+func (c *QueueClient) Send(ctx context.Context, queueName string, message Message) (*sqs.SendMessageOutput, error) {
+	queueURL, err := c.QueueURL(ctx, queueName)
 	if err != nil {
-		fmt.Printf("Got an error getting the queue URL: %s", err)
 		return nil, err
 	}
-
-	sMInput := &sqs.SendMessageInput{
-		MessageAttributes: map[string]types.MessageAttributeValue{
-			"Origin": {
-				DataType:    aws.String("String"),
-				StringValue: aws.String(origin),
-			},
-		},
-		MessageBody:  aws.String(message),
-		QueueUrl:     urlRes.QueueUrl,
-		DelaySeconds: delay,
+	if message, err = c.offload(ctx, message); err != nil {
+		return nil, err
+	}
+	resp, err := c.sqs.SendMessage(ctx, &sqs.SendMessageInput{
+		MessageAttributes:      message.Attributes,
+		MessageBody:            aws.String(message.Body),
+		QueueUrl:               aws.String(queueURL),
+		DelaySeconds:           message.Delay,
+		MessageGroupId:         optional(message.GroupID),
+		MessageDeduplicationId: optional(message.DeduplicationID),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to send message to queue %s: %w", queueName, err)
 	}
-	resp, err := client.SendMessage(ctx, sMInput)
 	return resp, nil
 }
 
+// BatchResult is the outcome of every message of SendBatch.
+// Messages are identified by their index in the batch.
+type BatchResult struct {
+	Sent   []SentMessage   `json:"sent,omitempty"`
+	Failed []FailedMessage `json:"failed,omitempty"`
+}
+
+// SentMessage is a message of a batch the queue has accepted.
+type SentMessage struct {
+	Index     int    `json:"index"`
+	MessageID string `json:"message_id"`
+}
+
+// FailedMessage is a message of a batch the queue has not accepted.
+type FailedMessage struct {
+	Index       int    `json:"index"`
+	Code        string `json:"code"`
+	Message     string `json:"message"`
+	SenderFault bool   `json:"sender_fault"` // The message itself is wrong, sending it again does not help
+}
+
+// SendBatch sends the messages to the queue with SendMessageBatch, in chunks of 10 messages.
+// The queue accepts or rejects every message on its own, the result reports the outcome of each of them.
+// If a whole chunk cannot be sent, its messages are reported as failed and the error is returned
+// together with the result of the other chunks.
+func (c *QueueClient) SendBatch(ctx context.Context, queueName string, messages []Message) (*BatchResult, error) {
+	queueURL, err := c.QueueURL(ctx, queueName)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &BatchResult{}
+	var errs []error
+	// The bodies over the threshold are offloaded first, the messages that fail to are not sent
+	indexes := make([]int, 0, len(messages))
+	prepared := make([]Message, 0, len(messages))
+	for i, message := range messages {
+		message, err := c.offload(ctx, message)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("message %d: %w", i, err))
+			result.Failed = append(result.Failed, FailedMessage{Index: i, Code: "OffloadFailed", Message: err.Error()})
+			continue
+		}
+		indexes = append(indexes, i)
+		prepared = append(prepared, message)
+	}
+
+	for start := 0; start < len(prepared); start += maxBatchEntries {
+		end := min(start+maxBatchEntries, len(prepared))
+		entries := make([]types.SendMessageBatchRequestEntry, 0, end-start)
+		for i := start; i < end; i++ {
+			entries = append(entries, types.SendMessageBatchRequestEntry{
+				Id:                     aws.String(strconv.Itoa(indexes[i])),
+				MessageBody:            aws.String(prepared[i].Body),
+				MessageAttributes:      prepared[i].Attributes,
+				DelaySeconds:           prepared[i].Delay,
+				MessageGroupId:         optional(prepared[i].GroupID),
+				MessageDeduplicationId: optional(prepared[i].DeduplicationID),
+			})
+		}
+
+		resp, err := c.sqs.SendMessageBatch(ctx, &sqs.SendMessageBatchInput{
+			QueueUrl: aws.String(queueURL),
+			Entries:  entries,
+		})
+		if err != nil {
+			errs = append(errs, fmt.Errorf("failed to send messages %d-%d to queue %s: %w", indexes[start], indexes[end-1], queueName, err))
+			for i := start; i < end; i++ {
+				result.Failed = append(result.Failed, FailedMessage{Index: indexes[i], Code: "RequestFailed", Message: err.Error()})
+			}
+			continue
+		}
+		for _, entry := range resp.Successful {
+			index, _ := strconv.Atoi(aws.ToString(entry.Id))
+			result.Sent = append(result.Sent, SentMessage{Index: index, MessageID: aws.ToString(entry.MessageId)})
+		}
+		for _, entry := range resp.Failed {
+			index, _ := strconv.Atoi(aws.ToString(entry.Id))
+			result.Failed = append(result.Failed, FailedMessage{
+				Index:       index,
+				Code:        aws.ToString(entry.Code),
+				Message:     aws.ToString(entry.Message),
+				SenderFault: entry.SenderFault,
+			})
+		}
+	}
+	sort.Slice(result.Sent, func(i, j int) bool { return result.Sent[i].Index < result.Sent[j].Index })
+	sort.Slice(result.Failed, func(i, j int) bool { return result.Failed[i].Index < result.Failed[j].Index })
+	return result, errors.Join(errs...)
+}
+
+// Delete deletes a received message from the queue by its receipt handle.
+func (c *QueueClient) Delete(ctx context.Context, queueName, receiptHandle string) error {
+	queueURL, err := c.QueueURL(ctx, queueName)
+	if err != nil {
+		return err
+	}
+	_, err = c.sqs.DeleteMessage(ctx, &sqs.DeleteMessageInput{
+		QueueUrl:      aws.String(queueURL),
+		ReceiptHandle: aws.String(receiptHandle),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to delete message from queue %s: %w", queueName, err)
+	}
+	return nil
+}
+
+// ChangeVisibility hides a received message for the timeout, counted from now. A zero timeout makes it
+// visible at once.
+func (c *QueueClient) ChangeVisibility(ctx context.Context, queueName, receiptHandle string, timeout time.Duration) error {
+	queueURL, err := c.QueueURL(ctx, queueName)
+	if err != nil {
+		return err
+	}
+	_, err = c.sqs.ChangeMessageVisibility(ctx, &sqs.ChangeMessageVisibilityInput{
+		QueueUrl:          aws.String(queueURL),
+		ReceiptHandle:     aws.String(receiptHandle),
+		VisibilityTimeout: int32(timeout / time.Second),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to change visibility of message in queue %s: %w", queueName, err)
+	}
+	return nil
+}
+
+// offload replaces the message with a pointer message if its body is to be offloaded.
+func (c *QueueClient) offload(ctx context.Context, message Message) (Message, error) {
+	if c.payloads == nil {
+		return message, nil
+	}
+	return c.payloads.offload(ctx, message)
+}
+
+// optional returns nil for an empty string, so the parameter is not sent.
+func optional(s string) *string {
+	if s == "" {
+		return nil
+	}
+	return aws.String(s)
+}
+
 type resolverV2 struct {
-	// you could inject additional application context here as well
+	endpoint string // The URL of the message queue.
+}
+
+// endpoint returns the message queue URL from YMQ_ENDPOINT, Yandex Message Queue by default.
+// Tests point it to a local server.
+func endpoint() string {
+	if e := os.Getenv("YMQ_ENDPOINT"); e != "" {
+		return e
+	}
+	return defaultEndpoint
 }
 
-func (*resolverV2) ResolveEndpoint(_ context.Context, _ sqs.EndpointParameters) (
+func (r *resolverV2) ResolveEndpoint(_ context.Context, _ sqs.EndpointParameters) (
 	smithyendpoints.Endpoint, error,
 ) {
-	u, err := url.Parse("https://message-queue.api.cloud.yandex.net")
+	u, err := url.Parse(r.endpoint)
 	if err != nil {
 		return smithyendpoints.Endpoint{}, err
 	}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"testing"
+
+	"sls-ymq-handler/sqslocal"
+)
+
+// startLocalQueue starts the local queue server with the queues and points the functions to it.
+func startLocalQueue(t *testing.T, queues ...string) *sqslocal.Server {
+	t.Helper()
+	srv := sqslocal.NewServer(queues...)
+	t.Cleanup(srv.Close)
+	t.Setenv("YMQ_ENDPOINT", srv.URL())
+	t.Setenv("AWS_ACCESS_KEY_ID", "local")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "local")
+	t.Setenv("AWS_REGION", "ru-central1")
+
+	// Every test gets a client of its own server
+	queueClientMu.Lock()
+	previous := sharedQueueClient
+	sharedQueueClient = nil
+	queueClientMu.Unlock()
+	t.Cleanup(func() {
+		queueClientMu.Lock()
+		defer queueClientMu.Unlock()
+		sharedQueueClient = previous
+	})
+	return srv
+}
+
+// createFIFOQueue creates a FIFO queue with content-based deduplication.
+func createFIFOQueue(t *testing.T, srv *sqslocal.Server, name string) {
+	t.Helper()
+	_, err := srv.CreateQueue(name, map[string]string{"FifoQueue": "true", "ContentBasedDeduplication": "true"})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestQueueClientRetriesAfterFailure(t *testing.T) {
+	startLocalQueue(t)
+	t.Setenv("AWS_PROFILE", "missing")
+	if _, err := queueClient(); err == nil {
+		t.Fatal("the client is created with a missing profile")
+	}
+
+	// The failure is not kept, the next call creates the client
+	os.Unsetenv("AWS_PROFILE")
+	client, err := queueClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again, _ := queueClient(); again != client {
+		t.Error("the client is not shared")
+	}
+}
+
+func TestSendBatchChunks(t *testing.T) {
+	srv := startLocalQueue(t, "batch")
+	client, err := queueClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	messages := make([]Message, 25)
+	for i := range messages {
+		messages[i] = Message{
+			Body:       strconv.Itoa(i),
+			Attributes: Attributes{"Index": NumberAttribute(i), "Raw": BinaryAttribute([]byte{byte(i + 1)})},
+		}
+	}
+	// An attribute without a value is rejected by the queue
+	messages[13].Attributes["Empty"] = StringAttribute("")
+
+	result, err := client.SendBatch(context.Background(), "batch", messages)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Sent) != 24 || len(result.Failed) != 1 {
+		t.Fatalf("sent %d, failed %d", len(result.Sent), len(result.Failed))
+	}
+	if f := result.Failed[0]; f.Index != 13 || !f.SenderFault || f.Code != "InvalidParameterValue" {
+		t.Errorf("unexpected failure: %+v", f)
+	}
+	for i, sent := range result.Sent {
+		if i > 0 && sent.Index <= result.Sent[i-1].Index {
+			t.Fatalf("the sent messages are not ordered by index")
+		}
+	}
+	stored := srv.Messages("batch")
+	if len(stored) != 24 || stored[0].Attributes["Index"].StringValue != "0" || stored[0].Attributes["Raw"].BinaryValue[0] != 1 {
+		t.Errorf("unexpected stored messages: %+v", stored)
+	}
+}
+
+func TestSendUnknownQueue(t *testing.T) {
+	startLocalQueue(t)
+	client, err := queueClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = client.Send(context.Background(), "missing", Message{Body: "x"}); err == nil {
+		t.Errorf("sending to a missing queue succeeded")
+	}
+	if _, err = client.SendBatch(context.Background(), "missing", []Message{{Body: "x"}}); err == nil {
+		t.Errorf("sending a batch to a missing queue succeeded")
+	}
+}
+
+func TestSendFIFO(t *testing.T) {
+	srv := startLocalQueue(t)
+	createFIFOQueue(t, srv, "orders.fifo")
+	client, err := queueClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	messages := []Message{
+		{Body: `{"n":1}`, GroupID: "a"},
+		{Body: `{"n":2}`, GroupID: "a"},
+		{Body: `{"n":1}`, GroupID: "a"},                           // a duplicate by content
+		{Body: `{"n":3}`, GroupID: "b", DeduplicationID: "fixed"}, // explicit deduplication ID
+		{Body: `{"n":4}`, GroupID: "b", DeduplicationID: "fixed"},
+		{Body: `{"n":5}`}, // no group
+	}
+	result, err := client.SendBatch(ctx, "orders.fifo", messages)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Failed) != 1 || result.Failed[0].Index != 5 {
+		t.Fatalf("unexpected failures: %+v", result.Failed)
+	}
+	stored := srv.Messages("orders.fifo")
+	var bodies []string
+	for _, m := range stored {
+		bodies = append(bodies, m.GroupID+m.Body)
+	}
+	if fmt.Sprint(bodies) != `[a{"n":1} a{"n":2} b{"n":3}]` {
+		t.Errorf("unexpected stored messages: %v", bodies)
+	}
+}
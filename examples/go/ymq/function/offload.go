@@ -0,0 +1,229 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strconv"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+const (
+	// maxMessageSize is the largest message the queue accepts, its body and attributes together.
+	maxMessageSize = 256 * 1024
+	// defaultStorageEndpoint is the object storage used unless S3_ENDPOINT says otherwise.
+	defaultStorageEndpoint = "https://storage.yandexcloud.net"
+
+	// PayloadSizeAttribute marks a pointer message with the size of the offloaded body, as the extended clients
+	// of the AWS SDKs do, so they can read the messages of this one and the other way around.
+	PayloadSizeAttribute = "ExtendedPayloadSize"
+	// payloadPointerClass is the first element of the body of a pointer message.
+	payloadPointerClass = "software.amazon.payloadoffloading.PayloadS3Pointer"
+)
+
+// objectAPI is the part of the S3 client the payload store uses.
+type objectAPI interface {
+	PutObject(ctx context.Context, params *s3.PutObjectInput, optFns ...func(*s3.Options)) (*s3.PutObjectOutput, error)
+	GetObject(ctx context.Context, params *s3.GetObjectInput, optFns ...func(*s3.Options)) (*s3.GetObjectOutput, error)
+	DeleteObject(ctx context.Context, params *s3.DeleteObjectInput, optFns ...func(*s3.Options)) (*s3.DeleteObjectOutput, error)
+}
+
+// PayloadStore keeps the bodies of messages too large for the queue in a bucket of Object Storage.
+// The messages carry a pointer to the object instead of the body.
+type PayloadStore struct {
+	objects objectAPI
+	bucket  string
+	// Threshold is the size of a message, its body and attributes together, above which its body is offloaded.
+	Threshold int
+	// DeleteProcessed makes the Receiver delete the object of a message once the message is processed.
+	DeleteProcessed bool
+}
+
+// payloadPointer is the location of an offloaded body.
+type payloadPointer struct {
+	Bucket string `json:"s3BucketName"`
+	Key    string `json:"s3Key"`
+}
+
+// NewPayloadStore creates a store of the bodies in the bucket, offloading the bodies of messages larger
+// than the queue accepts.
+func NewPayloadStore(objects objectAPI, bucket string) *PayloadStore {
+	return &PayloadStore{objects: objects, bucket: bucket, Threshold: maxMessageSize}
+}
+
+// payloadStoreFromEnv creates the store from YMQ_PAYLOAD_BUCKET, YMQ_PAYLOAD_THRESHOLD and YMQ_PAYLOAD_DELETE,
+// or returns nil if no bucket is set. The storage is accessed with the credentials of the queue.
+func payloadStoreFromEnv(cfg aws.Config) (*PayloadStore, error) {
+	bucket := os.Getenv("YMQ_PAYLOAD_BUCKET")
+	if bucket == "" {
+		return nil, nil
+	}
+	objects := s3.NewFromConfig(cfg, func(o *s3.Options) {
+		o.Region = "ru-central1"
+		o.BaseEndpoint = aws.String(storageEndpoint())
+		o.UsePathStyle = true
+	})
+	store := NewPayloadStore(objects, bucket)
+	if v := os.Getenv("YMQ_PAYLOAD_THRESHOLD"); v != "" {
+		threshold, err := strconv.Atoi(v)
+		if err != nil || threshold < 0 || threshold > maxMessageSize {
+			return nil, fmt.Errorf("invalid YMQ_PAYLOAD_THRESHOLD %q", v)
+		}
+		store.Threshold = threshold
+	}
+	if v := os.Getenv("YMQ_PAYLOAD_DELETE"); v != "" {
+		var err error
+		if store.DeleteProcessed, err = strconv.ParseBool(v); err != nil {
+			return nil, fmt.Errorf("invalid YMQ_PAYLOAD_DELETE: %w", err)
+		}
+	}
+	return store, nil
+}
+
+// storageEndpoint returns the object storage URL from S3_ENDPOINT, Yandex Object Storage by default.
+func storageEndpoint() string {
+	if e := os.Getenv("S3_ENDPOINT"); e != "" {
+		return e
+	}
+	return defaultStorageEndpoint
+}
+
+// offload puts the body of the message to the bucket if the message is over the threshold, and returns
+// the pointer message to send instead. Smaller messages are returned as they are.
+func (p *PayloadStore) offload(ctx context.Context, message Message) (Message, error) {
+	if messageSize(message) <= p.Threshold {
+		return message, nil
+	}
+	key, err := randomID()
+	if err != nil {
+		return Message{}, err
+	}
+	pointer := payloadPointer{Bucket: p.bucket, Key: "ymq/" + key}
+	_, err = p.objects.PutObject(ctx, &s3.PutObjectInput{
+		Bucket: aws.String(pointer.Bucket),
+		Key:    aws.String(pointer.Key),
+		Body:   bytes.NewReader([]byte(message.Body)),
+	})
+	if err != nil {
+		return Message{}, fmt.Errorf("failed to offload the body to bucket %s: %w", p.bucket, err)
+	}
+
+	body, err := json.Marshal([]any{payloadPointerClass, pointer})
+	if err != nil {
+		return Message{}, err
+	}
+	attributes := Attributes{}
+	for name, attr := range message.Attributes {
+		attributes[name] = attr
+	}
+	attributes[PayloadSizeAttribute] = NumberAttribute(len(message.Body))
+	message.Attributes = attributes
+	message.Body = string(body)
+	return message, nil
+}
+
+// fetch returns the offloaded body the pointer message points to. A missing object fails permanently.
+func (p *PayloadStore) fetch(ctx context.Context, body string) (string, error) {
+	pointer, err := parsePayloadPointer(body)
+	if err != nil {
+		return "", Permanent(err)
+	}
+	out, err := p.objects.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(pointer.Bucket),
+		Key:    aws.String(pointer.Key),
+	})
+	if err != nil {
+		var noSuchKey *types.NoSuchKey
+		if errors.As(err, &noSuchKey) {
+			err = Permanent(err)
+		}
+		return "", fmt.Errorf("failed to fetch the body from %s/%s: %w", pointer.Bucket, pointer.Key, err)
+	}
+	defer out.Body.Close()
+	data, err := io.ReadAll(out.Body)
+	if err != nil {
+		return "", fmt.Errorf("failed to fetch the body from %s/%s: %w", pointer.Bucket, pointer.Key, err)
+	}
+	return string(data), nil
+}
+
+// remove deletes the offloaded body the pointer message points to.
+func (p *PayloadStore) remove(ctx context.Context, body string) error {
+	pointer, err := parsePayloadPointer(body)
+	if err != nil {
+		return err
+	}
+	_, err = p.objects.DeleteObject(ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(pointer.Bucket),
+		Key:    aws.String(pointer.Key),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to delete the body %s/%s: %w", pointer.Bucket, pointer.Key, err)
+	}
+	return nil
+}
+
+// parsePayloadPointer parses the body of a pointer message.
+func parsePayloadPointer(body string) (payloadPointer, error) {
+	var parts []json.RawMessage
+	var class string
+	var pointer payloadPointer
+	if json.Unmarshal([]byte(body), &parts) != nil || len(parts) != 2 ||
+		json.Unmarshal(parts[0], &class) != nil || class != payloadPointerClass ||
+		json.Unmarshal(parts[1], &pointer) != nil || pointer.Bucket == "" || pointer.Key == "" {
+		return payloadPointer{}, fmt.Errorf("invalid payload pointer %q", body)
+	}
+	return pointer, nil
+}
+
+// messageSize returns the size of the message as the queue counts it: the body, and the names, types
+// and values of the attributes.
+func messageSize(message Message) int {
+	size := len(message.Body)
+	for name, attr := range message.Attributes {
+		size += len(name) + len(aws.ToString(attr.DataType)) + len(aws.ToString(attr.StringValue)) + len(attr.BinaryValue)
+	}
+	return size
+}
+
+// Body returns the body of a received message, fetching it from the bucket if it was offloaded.
+func (c *QueueClient) Body(ctx context.Context, message YMQMessage) (string, error) {
+	body := message.Details.Message.Body
+	if _, ok := message.Details.Message.MessageAttributes[PayloadSizeAttribute]; !ok {
+		return body, nil
+	}
+	return c.fetchPayload(ctx, body)
+}
+
+// ReleasePayload deletes the offloaded body of a processed message if the store is configured to.
+// Failures are only logged: the object is left in the bucket.
+func (c *QueueClient) ReleasePayload(ctx context.Context, message YMQMessage) {
+	if _, ok := message.Details.Message.MessageAttributes[PayloadSizeAttribute]; ok {
+		c.releasePayload(ctx, message.Details.Message.Body)
+	}
+}
+
+// fetchPayload returns the offloaded body of the pointer message.
+func (c *QueueClient) fetchPayload(ctx context.Context, body string) (string, error) {
+	if c.payloads == nil {
+		return "", Permanent(errors.New("the body is offloaded, but YMQ_PAYLOAD_BUCKET is not set"))
+	}
+	return c.payloads.fetch(ctx, body)
+}
+
+func (c *QueueClient) releasePayload(ctx context.Context, body string) {
+	if c.payloads == nil || !c.payloads.DeleteProcessed {
+		return
+	}
+	if err := c.payloads.remove(ctx, body); err != nil {
+		log.Printf("%v", err)
+	}
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// memoryObjects is an in-memory bucket for the payload store.
+type memoryObjects struct {
+	mu      sync.Mutex
+	objects map[string][]byte
+}
+
+func (m *memoryObjects) PutObject(_ context.Context, in *s3.PutObjectInput, _ ...func(*s3.Options)) (*s3.PutObjectOutput, error) {
+	data, err := io.ReadAll(in.Body)
+	if err != nil {
+		return nil, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.objects[aws.ToString(in.Bucket)+"/"+aws.ToString(in.Key)] = data
+	return &s3.PutObjectOutput{}, nil
+}
+
+func (m *memoryObjects) GetObject(_ context.Context, in *s3.GetObjectInput, _ ...func(*s3.Options)) (*s3.GetObjectOutput, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	data, ok := m.objects[aws.ToString(in.Bucket)+"/"+aws.ToString(in.Key)]
+	if !ok {
+		return nil, &types.NoSuchKey{}
+	}
+	return &s3.GetObjectOutput{Body: io.NopCloser(bytes.NewReader(data))}, nil
+}
+
+func (m *memoryObjects) DeleteObject(_ context.Context, in *s3.DeleteObjectInput, _ ...func(*s3.Options)) (*s3.DeleteObjectOutput, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.objects, aws.ToString(in.Bucket)+"/"+aws.ToString(in.Key))
+	return &s3.DeleteObjectOutput{}, nil
+}
+
+func (m *memoryObjects) len() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return len(m.objects)
+}
+
+// withPayloadStore makes the shared client offload bodies over the threshold to an in-memory bucket.
+func withPayloadStore(t *testing.T, threshold int, deleteProcessed bool) (*QueueClient, *memoryObjects) {
+	t.Helper()
+	client, err := queueClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+	objects := &memoryObjects{objects: map[string][]byte{}}
+	store := NewPayloadStore(objects, "payloads")
+	store.Threshold = threshold
+	store.DeleteProcessed = deleteProcessed
+	return client.WithPayloadStore(store), objects
+}
+
+func TestSendOffloadsLargeBodies(t *testing.T) {
+	srv := startLocalQueue(t, "input")
+	client, objects := withPayloadStore(t, 100, false)
+	ctx := context.Background()
+
+	large := strings.Repeat("x", 200)
+	if _, err := client.Send(ctx, "input", Message{Body: "small"}); err != nil {
+		t.Fatal(err)
+	}
+	result, err := client.SendBatch(ctx, "input", []Message{{Body: large}, {Body: "small"}})
+	if err != nil || len(result.Sent) != 2 {
+		t.Fatalf("sent %+v, %v", result, err)
+	}
+
+	stored := srv.Messages("input")
+	if stored[0].Body != "small" || stored[2].Body != "small" {
+		t.Errorf("small bodies are offloaded: %+v", stored)
+	}
+	pointer, err := parsePayloadPointer(stored[1].Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pointer.Bucket != "payloads" || stored[1].Attributes[PayloadSizeAttribute].StringValue != "200" {
+		t.Errorf("unexpected pointer message %+v", stored[1])
+	}
+	if objects.len() != 1 {
+		t.Errorf("%d objects are stored, expected 1", objects.len())
+	}
+}
+
+func TestReceiverFetchesOffloadedBodies(t *testing.T) {
+	srv := startLocalQueue(t, "input", "response")
+	client, objects := withPayloadStore(t, 100, true)
+	t.Setenv("YMQ_NAME", "response")
+
+	name := strings.Repeat("n", 150)
+	if _, err := client.Send(context.Background(), "input", Message{Body: `{"name": "` + name + `"}`}); err != nil {
+		t.Fatal(err)
+	}
+	deliver(t, srv, "input")
+
+	// The request's object is deleted once it is processed, the reply is offloaded in its turn
+	reply := srv.Messages("response")
+	if len(reply) != 1 || objects.len() != 1 {
+		t.Fatalf("%d replies, %d objects", len(reply), objects.len())
+	}
+	body, err := client.fetchPayload(context.Background(), reply[0].Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got map[string]string
+	if err = json.Unmarshal([]byte(body), &got); err != nil || got["name"] != name {
+		t.Errorf("unexpected reply %s", body)
+	}
+}
+
+func TestReceiverDeadLettersMissingPayloads(t *testing.T) {
+	srv := startLocalQueue(t, "input", "response", "dead")
+	client, objects := withPayloadStore(t, 10, false)
+	t.Setenv("YMQ_NAME", "response")
+	t.Setenv("YMQ_DLQ_NAME", "dead")
+
+	if _, err := client.Send(context.Background(), "input", Message{Body: `{"name": "lost"}`}); err != nil {
+		t.Fatal(err)
+	}
+	objects.objects = map[string][]byte{}
+	deliver(t, srv, "input")
+
+	if n := len(srv.Messages("dead")); n != 1 {
+		t.Errorf("%d messages are dead-lettered, expected 1", n)
+	}
+}
+
+func TestParsePayloadPointer(t *testing.T) {
+	// The body of a pointer message of the extended clients of the AWS SDKs
+	pointer, err := parsePayloadPointer(`["software.amazon.payloadoffloading.PayloadS3Pointer",{"s3BucketName":"b","s3Key":"k"}]`)
+	if err != nil || pointer != (payloadPointer{Bucket: "b", Key: "k"}) {
+		t.Errorf("parsed %+v, %v", pointer, err)
+	}
+	for _, body := range []string{`{"name": "x"}`, `["other",{"s3BucketName":"b","s3Key":"k"}]`, `["software.amazon.payloadoffloading.PayloadS3Pointer",{}]`} {
+		if _, err = parsePayloadPointer(body); err == nil {
+			t.Errorf("%s is parsed as a pointer", body)
+		}
+	}
+}
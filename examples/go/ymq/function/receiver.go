@@ -4,7 +4,12 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"log"
 	"os"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
 	"time"
 )
 
@@ -27,12 +32,13 @@ type MessageAttributeValue struct {
 type YMQMessageDetails struct {
 	QueueId string `json:"queue_id"`
 	Message struct {
-		MessageId              string
-		Md5OfBody              string
-		Body                   string
-		Attributes             map[string]string
-		MessageAttributes      map[string]*MessageAttributeValue
-		Md5OfMessageAttributes string
+		MessageId              string                            `json:"message_id"`
+		ReceiptHandle          string                            `json:"receipt_handle"`
+		Md5OfBody              string                            `json:"md5_of_body"`
+		Body                   string                            `json:"body"`
+		Attributes             map[string]string                 `json:"attributes"`
+		MessageAttributes      map[string]*MessageAttributeValue `json:"message_attributes"`
+		Md5OfMessageAttributes string                            `json:"md5_of_message_attributes"`
 	} `json:"message"`
 }
 
@@ -46,30 +52,148 @@ type YMQRequest struct {
 }
 
 type YMQResponse struct {
-	StatusCode int
+	StatusCode   int
+	Processed    int // Messages processed successfully
+	Retried      int // Messages to be received again
+	DeadLettered int // Messages moved to the dead-letter queue
 }
 
 type Request struct {
 	Name string `json:"name"`
 }
 
+// Receiver replies to every message of the batch with the name from its body.
+// The messages of a FIFO queue are processed in the order of their group: the groups are processed concurrently,
+// the messages of a group one by one in the order they were sent. If a message fails, the following messages
+// of its group are skipped.
+//
+// The outcome of every message is tracked, and the batch is settled by the retry policy: see retryPolicy.settle.
+//
 //goland:noinspection GoUnusedExportedFunction
 func Receiver(ctx context.Context, event *YMQRequest) (*YMQResponse, error) {
 	ymqName := os.Getenv("YMQ_NAME")
+	policy, err := loadRetryPolicy()
+	if err != nil {
+		return nil, err
+	}
+	client, err := queueClient()
+	if err != nil {
+		return nil, err
+	}
 
-	var req Request
-	for _, message := range event.Messages {
-		if err := json.Unmarshal([]byte(message.Details.Message.Body), &req); err != nil {
-			return nil, fmt.Errorf("an error has occurred when parsing body: %v", err)
+	groups := messageGroups(event.Messages)
+	results := make([][]messageResult, len(groups))
+	var wg sync.WaitGroup
+	for i, group := range groups {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[i] = processGroup(ctx, client, ymqName, policy, group)
+		}()
+	}
+	wg.Wait()
+	return policy.settle(ctx, client, slices.Concat(results...))
+}
+
+// processGroup processes the messages of a group one by one. Once a message fails, the rest are skipped.
+func processGroup(ctx context.Context, client *QueueClient, ymqName string, policy retryPolicy, group []YMQMessage) []messageResult {
+	results := make([]messageResult, len(group))
+	var failed error
+	for i, message := range group {
+		results[i].message = message
+		if failed != nil {
+			results[i].outcome = outcomeSkipped
+			results[i].err = fmt.Errorf("skipped after an earlier message of the group failed: %w", failed)
+			continue
+		}
+		if err := handleMessage(ctx, client, ymqName, message); err != nil {
+			log.Printf("message %s: %v", message.Details.Message.MessageId, err)
+			results[i].err = err
+			results[i].outcome = policy.classify(results[i])
+			// A dead-lettered message does not hold back its group
+			if results[i].outcome == outcomeRetry {
+				failed = err
+			}
 		}
+	}
+	return results
+}
+
+// handleMessage replies to a single message. Bodies offloaded to Object Storage are fetched first. Requests are answered to their ReplyTo queue with their
+// correlation ID, other messages to YMQ_NAME. A message is never answered to the queue it came from,
+// so the Receiver does not trigger itself with its replies.
+func handleMessage(ctx context.Context, client *QueueClient, ymqName string, message YMQMessage) error {
+	body, err := client.Body(ctx, message)
+	if err != nil {
+		return err
+	}
+	var req Request
+	if err := json.Unmarshal([]byte(body), &req); err != nil {
+		return Permanent(fmt.Errorf("an error has occurred when parsing body: %v", err))
+	}
 
-		fmt.Printf("%+v\n", req)
-		req.Name = "test"
-		resp := fmt.Sprintf(`{"result": "success", "name": "%s"}`, req.Name)
-		_, _ = sendMessageToQueue(ctx, ymqName, resp, "From Receiver Function", 0)
+	fmt.Printf("%+v\n", req)
+	resp, err := json.Marshal(map[string]string{"result": "success", "name": req.Name})
+	if err != nil {
+		return err
+	}
+	reply := Message{
+		Body: string(resp),
+		Attributes: Attributes{
+			"Origin": StringAttribute("From Receiver Function"),
+		},
+	}
+
+	replyTo := message.attribute(ReplyToAttribute)
+	if replyTo == "" {
+		replyTo = ymqName
+	}
+	if replyTo == sourceQueue(message) {
+		log.Printf("message %s: not replying to %s, the queue it came from", message.Details.Message.MessageId, replyTo)
+		return nil
+	}
+	if message.attribute(ReplyToAttribute) != "" {
+		return client.Reply(ctx, message, reply)
+	}
+	_, err = client.Send(ctx, replyTo, reply)
+	return err
+}
+
+// messageGroups splits the messages by their message group, ordered by their sequence numbers.
+// Messages of standard queues have no group, each of them is a group of its own.
+func messageGroups(messages []YMQMessage) [][]YMQMessage {
+	var groups [][]YMQMessage
+	byGroup := map[string]int{}
+	for _, message := range messages {
+		groupID := message.Details.Message.Attributes["MessageGroupId"]
+		if groupID == "" {
+			groups = append(groups, []YMQMessage{message})
+			continue
+		}
+		i, ok := byGroup[groupID]
+		if !ok {
+			i = len(groups)
+			byGroup[groupID] = i
+			groups = append(groups, nil)
+		}
+		groups[i] = append(groups[i], message)
+	}
+	for _, group := range groups {
+		sort.SliceStable(group, func(i, j int) bool {
+			return lessSequenceNumber(
+				group[i].Details.Message.Attributes["SequenceNumber"],
+				group[j].Details.Message.Attributes["SequenceNumber"],
+			)
+		})
+	}
+	return groups
+}
 
+// lessSequenceNumber compares the sequence numbers of FIFO messages, decimal numbers too long for uint64.
+func lessSequenceNumber(a, b string) bool {
+	a, b = strings.TrimLeft(a, "0"), strings.TrimLeft(b, "0")
+	if len(a) != len(b) {
+		return len(a) < len(b)
 	}
-	return &YMQResponse{
-		StatusCode: 200,
-	}, nil
+	return a < b
 }
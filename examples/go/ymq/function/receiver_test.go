@@ -0,0 +1,306 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"testing"
+	"time"
+
+	"sls-ymq-handler/sqslocal"
+)
+
+// deliver hands the messages of the queue to the Receiver the way the trigger of the example does,
+// until the queue is empty.
+func deliver(t *testing.T, srv *sqslocal.Server, queueName string) {
+	t.Helper()
+	for {
+		n, err := srv.Deliver(queueName, 5, func(payload []byte) error {
+			var event YMQRequest
+			if err := json.Unmarshal(payload, &event); err != nil {
+				return err
+			}
+			_, err := Receiver(context.Background(), &event)
+			return err
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n == 0 {
+			return
+		}
+	}
+}
+
+// replies returns the names of the replies in the queue in the order they were sent.
+func replies(t *testing.T, srv *sqslocal.Server, queueName string) []string {
+	t.Helper()
+	var names []string
+	for _, m := range srv.Messages(queueName) {
+		var reply map[string]string
+		if err := json.Unmarshal([]byte(m.Body), &reply); err != nil {
+			t.Fatal(err)
+		}
+		if reply["result"] != "success" {
+			t.Errorf("unexpected reply %s", m.Body)
+		}
+		names = append(names, reply["name"])
+	}
+	return names
+}
+
+func TestSenderToReceiver(t *testing.T) {
+	srv := startLocalQueue(t, "input", "response")
+	ctx := context.Background()
+
+	t.Setenv("YMQ_NAME", "input")
+	result, err := Sender(ctx, &HttpEvent{HttpMethod: http.MethodGet})
+	if err != nil || result.StatusCode != 200 {
+		t.Fatalf("sender failed: %v %+v", err, result)
+	}
+	sent := srv.Messages("input")
+	if len(sent) != 1 || sent[0].Attributes["Origin"].StringValue != "From Sender Function" {
+		t.Fatalf("unexpected sent messages: %+v", sent)
+	}
+
+	// The message is delayed, as the trigger waits for the delay
+	t.Setenv("YMQ_NAME", "response")
+	deliver(t, srv, "input")
+	if got := replies(t, srv, "response"); len(got) != 0 {
+		t.Fatalf("the delayed message is delivered: %v", got)
+	}
+	srv.ExpireVisibility("input")
+	deliver(t, srv, "input")
+	if got := fmt.Sprint(replies(t, srv, "response")); got != "[test]" {
+		t.Errorf("unexpected replies %s", got)
+	}
+}
+
+func TestReceiverKeepsGroupOrder(t *testing.T) {
+	srv := startLocalQueue(t, "response")
+	createFIFOQueue(t, srv, "input.fifo")
+	ctx := context.Background()
+
+	t.Setenv("YMQ_NAME", "input.fifo")
+	t.Setenv("YMQ_GROUP_FIELD", "user")
+	body := `[
+		{"user": "alice", "name": "alice-1"},
+		{"user": "bob", "name": "bob-1"},
+		{"user": "alice", "name": "alice-2"},
+		{"user": "alice", "name": "alice-1"},
+		{"user": "bob", "name": "bob-2"},
+		{"user": "alice", "name": "alice-3"},
+		{"name": "nobody"}
+	]`
+	result, err := Sender(ctx, &HttpEvent{HttpMethod: http.MethodPost, Body: body})
+	if err != nil || result.StatusCode != 200 {
+		t.Fatalf("sender failed: %v %+v", err, result)
+	}
+	groups := map[string]int{}
+	for _, m := range srv.Messages("input.fifo") {
+		groups[m.GroupID]++
+	}
+	// The repeated alice-1 is deduplicated by its content
+	if groups["alice"] != 3 || groups["bob"] != 2 || groups[defaultGroupID] != 1 {
+		t.Fatalf("unexpected groups: %v", groups)
+	}
+
+	t.Setenv("YMQ_NAME", "response")
+	deliver(t, srv, "input.fifo")
+
+	order := map[string][]string{}
+	for _, name := range replies(t, srv, "response") {
+		user := name[:len(name)-2]
+		order[user] = append(order[user], name)
+	}
+	if fmt.Sprint(order["alice"]) != "[alice-1 alice-2 alice-3]" || fmt.Sprint(order["bob"]) != "[bob-1 bob-2]" {
+		t.Errorf("the order of the groups is not kept: %v", order)
+	}
+}
+
+func TestMessageGroupsSortBySequenceNumber(t *testing.T) {
+	message := func(id, group, sequence string) YMQMessage {
+		var m YMQMessage
+		m.Details.Message.MessageId = id
+		m.Details.Message.Attributes = map[string]string{"MessageGroupId": group, "SequenceNumber": sequence}
+		return m
+	}
+	groups := messageGroups([]YMQMessage{
+		message("a2", "a", "18446744073709551617"),
+		message("b1", "b", "5"),
+		message("a1", "a", "900"),
+		message("x", "", ""),
+	})
+	var got []string
+	for _, group := range groups {
+		var ids []string
+		for _, m := range group {
+			ids = append(ids, m.Details.Message.MessageId)
+		}
+		got = append(got, fmt.Sprint(ids))
+	}
+	if fmt.Sprint(got) != "[[a1 a2] [b1] [x]]" {
+		t.Errorf("unexpected groups %v", got)
+	}
+}
+
+// deliverOnce hands one batch of the queue to the Receiver and returns its error.
+func deliverOnce(t *testing.T, srv *sqslocal.Server, queueName string) (int, error) {
+	t.Helper()
+	return srv.Deliver(queueName, 10, func(payload []byte) error {
+		var event YMQRequest
+		if err := json.Unmarshal(payload, &event); err != nil {
+			return err
+		}
+		_, err := Receiver(context.Background(), &event)
+		return err
+	})
+}
+
+// send puts the bodies to the queue.
+func send(t *testing.T, queueName string, bodies ...string) {
+	t.Helper()
+	client, err := queueClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, body := range bodies {
+		if _, err = client.Send(context.Background(), queueName, Message{Body: body}); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestReceiverDeadLettersMalformedMessages(t *testing.T) {
+	srv := startLocalQueue(t, "input", "response", "dead")
+	t.Setenv("YMQ_NAME", "response")
+	t.Setenv("YMQ_DLQ_NAME", "dead")
+	send(t, "input", `{"name": "good"}`, `not json`)
+
+	deliver(t, srv, "input")
+	if got := fmt.Sprint(replies(t, srv, "response")); got != "[good]" {
+		t.Errorf("unexpected replies %s", got)
+	}
+	if n := len(srv.Messages("input")); n != 0 {
+		t.Errorf("%d messages are left in the input queue", n)
+	}
+	dead := srv.Messages("dead")
+	if len(dead) != 1 || dead[0].Body != "not json" {
+		t.Fatalf("unexpected dead letters: %+v", dead)
+	}
+	if dead[0].Attributes["SourceQueue"].StringValue != "input" || dead[0].Attributes["FailureReason"].StringValue == "" {
+		t.Errorf("unexpected dead letter attributes: %+v", dead[0].Attributes)
+	}
+}
+
+func TestReceiverRetriesWholeBatchWithoutReceiptHandles(t *testing.T) {
+	srv := startLocalQueue(t, "input", "dead")
+	t.Setenv("YMQ_NAME", "missing")
+	t.Setenv("YMQ_DLQ_NAME", "dead")
+	t.Setenv("YMQ_DELETE_PROCESSED", "true")
+	send(t, "input", `{"name": "a"}`, `not json`)
+
+	// The reply fails, the trigger is to deliver the whole batch again
+	if _, err := deliverOnce(t, srv, "input"); err == nil {
+		t.Fatal("the batch with failed messages succeeded")
+	}
+	if n := len(srv.Messages("input")); n != 2 {
+		t.Errorf("%d messages are left in the input queue, expected 2", n)
+	}
+	if n := len(srv.Messages("dead")); n != 0 {
+		t.Errorf("a failed batch dead-lettered %d messages", n)
+	}
+}
+
+func TestReceiverSettlesMessagesItself(t *testing.T) {
+	srv := startLocalQueue(t, "input", "response", "dead")
+	srv.ReceiptHandles = true
+	t.Setenv("YMQ_NAME", "response")
+	t.Setenv("YMQ_DLQ_NAME", "dead")
+	t.Setenv("YMQ_DELETE_PROCESSED", "true")
+	t.Setenv("YMQ_MAX_RECEIVE_COUNT", "2")
+	send(t, "input", `{"name": "good"}`, `not json`)
+
+	if _, err := deliverOnce(t, srv, "input"); err != nil {
+		t.Fatal(err)
+	}
+	if len(srv.Messages("input")) != 0 || len(srv.Messages("dead")) != 1 {
+		t.Fatalf("the batch is not settled: %+v", srv.Messages("input"))
+	}
+
+	// Replies fail now: the messages are hidden for their backoff until they are dead-lettered.
+	// The batch is settled, so it succeeds and the trigger does not deliver it again
+	t.Setenv("YMQ_NAME", "missing")
+	send(t, "input", `{"name": "retried"}`)
+	if _, err := deliverOnce(t, srv, "input"); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := deliverOnce(t, srv, "input"); n != 0 || err != nil {
+		t.Fatalf("the failed message is visible before its backoff: %d, %v", n, err)
+	}
+	srv.ExpireVisibility("input")
+	if _, err := deliverOnce(t, srv, "input"); err != nil {
+		t.Fatal(err)
+	}
+	dead := srv.Messages("dead")
+	if len(srv.Messages("input")) != 0 || len(dead) != 2 || dead[1].Attributes["ReceiveCount"].StringValue != "2" {
+		t.Errorf("the poison message is not dead-lettered: %+v", dead)
+	}
+}
+
+func TestReceiverSkipsRestOfGroup(t *testing.T) {
+	srv := startLocalQueue(t, "response")
+	srv.ReceiptHandles = true
+	createFIFOQueue(t, srv, "input.fifo")
+	t.Setenv("YMQ_NAME", "response")
+	t.Setenv("YMQ_DELETE_PROCESSED", "true")
+
+	client, err := queueClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, body := range []string{`{"name": "a-1"}`, `not json`, `{"name": "a-3"}`, `{"name": "b-1"}`} {
+		group := "a"
+		if body == `{"name": "b-1"}` {
+			group = "b"
+		}
+		if _, err = client.Send(context.Background(), "input.fifo", Message{Body: body, GroupID: group}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Without a dead-letter queue the malformed message is retried, holding back the rest of its group.
+	// The Receiver has settled every message, so the batch succeeds
+	if _, err = deliverOnce(t, srv, "input.fifo"); err != nil {
+		t.Fatal(err)
+	}
+	// The groups reply concurrently
+	got := replies(t, srv, "response")
+	sort.Strings(got)
+	if fmt.Sprint(got) != "[a-1 b-1]" {
+		t.Errorf("unexpected replies %s", got)
+	}
+	var left []string
+	for _, m := range srv.Messages("input.fifo") {
+		left = append(left, m.Body)
+	}
+	if fmt.Sprint(left) != `[not json {"name": "a-3"}]` {
+		t.Errorf("unexpected messages left: %v", left)
+	}
+}
+
+func TestRetryPolicyBackoff(t *testing.T) {
+	policy := retryPolicy{retryDelay: 10 * time.Second}
+	for receiveCount, want := range map[int]time.Duration{
+		1:  10 * time.Second,
+		2:  20 * time.Second,
+		4:  80 * time.Second,
+		20: maxVisibilityTimeout,
+	} {
+		if got := policy.backoff(receiveCount); got != want {
+			t.Errorf("backoff(%d) = %v, want %v", receiveCount, got, want)
+		}
+	}
+}
@@ -0,0 +1,258 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+)
+
+const (
+	// defaultMaxReceiveCount is how many times a message is received before it is moved to the dead-letter queue,
+	// the maxReceiveCount of the redrive policy of the input queue.
+	defaultMaxReceiveCount = 3
+	// defaultRetryDelay is how long a failed message stays hidden after its first receive.
+	defaultRetryDelay = 10 * time.Second
+	// maxVisibilityTimeout is the longest visibility timeout the queue accepts.
+	maxVisibilityTimeout = 12 * time.Hour
+)
+
+// PermanentError marks a failure that processing the message again does not fix, like a malformed body.
+// Such messages are moved to the dead-letter queue at once.
+type PermanentError struct {
+	Err error
+}
+
+func (e *PermanentError) Error() string { return "permanent: " + e.Err.Error() }
+
+func (e *PermanentError) Unwrap() error { return e.Err }
+
+// Permanent wraps the error as a PermanentError.
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &PermanentError{Err: err}
+}
+
+// IsPermanent reports whether the error is a PermanentError.
+func IsPermanent(err error) bool {
+	var permanent *PermanentError
+	return errors.As(err, &permanent)
+}
+
+// outcome is what happened to a message of a batch.
+type outcome int
+
+const (
+	outcomeProcessed  outcome = iota
+	outcomeRetry              // Failed, processing it later may succeed
+	outcomeSkipped            // Not processed, an earlier message of its group failed
+	outcomeDeadLetter         // Failed permanently or too many times
+)
+
+// messageResult is the outcome of a message of a batch.
+type messageResult struct {
+	message YMQMessage
+	outcome outcome
+	err     error
+}
+
+// receiveCount returns how many times the message has been received, this time included.
+func (r messageResult) receiveCount() int {
+	n, err := strconv.Atoi(r.message.Details.Message.Attributes["ApproximateReceiveCount"])
+	if err != nil || n < 1 {
+		return 1
+	}
+	return n
+}
+
+// retryPolicy decides what happens to the messages of a batch after it is processed.
+type retryPolicy struct {
+	// deleteProcessed makes the Receiver delete processed messages and hide failed ones itself,
+	// so only the failed messages of a batch are received again. It needs the receipt handles of the messages.
+	deleteProcessed bool
+	// maxReceiveCount is how many times a failing message is received before it is dead-lettered.
+	maxReceiveCount int
+	// retryDelay is the visibility timeout of a failed message after its first receive,
+	// doubled with every receive.
+	retryDelay time.Duration
+	// deadLetterQueue is the name of the queue poison messages are moved to. Without it they are left
+	// to the redrive policy of the queue.
+	deadLetterQueue string
+}
+
+// loadRetryPolicy reads the policy from YMQ_DELETE_PROCESSED, YMQ_MAX_RECEIVE_COUNT, YMQ_RETRY_DELAY
+// and YMQ_DLQ_NAME.
+func loadRetryPolicy() (retryPolicy, error) {
+	policy := retryPolicy{
+		maxReceiveCount: defaultMaxReceiveCount,
+		retryDelay:      defaultRetryDelay,
+		deadLetterQueue: os.Getenv("YMQ_DLQ_NAME"),
+	}
+	var err error
+	if v := os.Getenv("YMQ_DELETE_PROCESSED"); v != "" {
+		if policy.deleteProcessed, err = strconv.ParseBool(v); err != nil {
+			return retryPolicy{}, fmt.Errorf("invalid YMQ_DELETE_PROCESSED: %w", err)
+		}
+	}
+	if v := os.Getenv("YMQ_MAX_RECEIVE_COUNT"); v != "" {
+		if policy.maxReceiveCount, err = strconv.Atoi(v); err != nil || policy.maxReceiveCount < 1 {
+			return retryPolicy{}, fmt.Errorf("invalid YMQ_MAX_RECEIVE_COUNT %q", v)
+		}
+	}
+	if v := os.Getenv("YMQ_RETRY_DELAY"); v != "" {
+		if policy.retryDelay, err = time.ParseDuration(v); err != nil || policy.retryDelay < 0 {
+			return retryPolicy{}, fmt.Errorf("invalid YMQ_RETRY_DELAY %q", v)
+		}
+	}
+	return policy, nil
+}
+
+// classify decides the outcome of a failed message: it is dead-lettered if the failure is permanent
+// or it has been received too many times, and retried otherwise.
+// Without a dead-letter queue every failure is retried.
+func (p retryPolicy) classify(result messageResult) outcome {
+	if p.deadLetterQueue == "" {
+		return outcomeRetry
+	}
+	if IsPermanent(result.err) || result.receiveCount() >= p.maxReceiveCount {
+		return outcomeDeadLetter
+	}
+	return outcomeRetry
+}
+
+// backoff returns how long a failed message stays hidden: retryDelay after its first receive,
+// twice as long after every following one, up to the longest visibility timeout.
+func (p retryPolicy) backoff(receiveCount int) time.Duration {
+	delay := p.retryDelay
+	for i := 1; i < receiveCount && delay < maxVisibilityTimeout; i++ {
+		delay *= 2
+	}
+	return min(delay, maxVisibilityTimeout)
+}
+
+// settle finishes the batch by the outcomes of its messages.
+//
+// When the Receiver deletes processed messages itself, the processed and dead-lettered messages are deleted,
+// failed ones are hidden for their backoff and skipped ones are made visible again. Otherwise the trigger
+// deletes the whole batch if the function succeeds and delivers it again if it fails: any message to retry
+// fails the batch, and the poison messages are left to the redrive policy of the queue.
+// The offloaded bodies of processed messages are released, see QueueClient.ReleasePayload.
+// When the trigger deletes the batch, an error is returned if some messages are to be received again. When the
+// Receiver deletes them itself, every message is settled already and no error is returned: the trigger would
+// deliver the whole batch again, the deleted messages too.
+func (p retryPolicy) settle(ctx context.Context, client *QueueClient, results []messageResult) (*YMQResponse, error) {
+	explicit := p.deleteProcessed
+	for _, r := range results {
+		if r.message.Details.Message.ReceiptHandle == "" {
+			if explicit {
+				log.Printf("message %s has no receipt handle, the batch is left to the trigger", r.message.Details.Message.MessageId)
+			}
+			explicit = false
+			break
+		}
+	}
+
+	var retries []error
+	for _, r := range results {
+		if r.outcome == outcomeRetry || r.outcome == outcomeSkipped {
+			retries = append(retries, fmt.Errorf("message %s: %w", r.message.Details.Message.MessageId, r.err))
+		}
+	}
+	if !explicit && len(retries) > 0 {
+		return nil, fmt.Errorf("%d of %d messages are to be retried: %w", len(retries), len(results), errors.Join(retries...))
+	}
+
+	response := &YMQResponse{StatusCode: 200}
+	for i, r := range results {
+		if r.outcome != outcomeDeadLetter {
+			continue
+		}
+		if err := p.deadLetter(ctx, client, r); err != nil {
+			// The message is retried instead, so it is not lost
+			log.Printf("failed to dead-letter message %s: %v", r.message.Details.Message.MessageId, err)
+			if !explicit {
+				return nil, err
+			}
+			results[i].outcome = outcomeRetry
+			retries = append(retries, err)
+		}
+	}
+
+	for _, r := range results {
+		switch r.outcome {
+		case outcomeProcessed:
+			response.Processed++
+			client.ReleasePayload(ctx, r.message)
+		case outcomeDeadLetter:
+			response.DeadLettered++
+		default:
+			response.Retried++
+		}
+		if !explicit {
+			continue
+		}
+
+		var err error
+		queueName := sourceQueue(r.message)
+		handle := r.message.Details.Message.ReceiptHandle
+		switch r.outcome {
+		case outcomeProcessed, outcomeDeadLetter:
+			err = client.Delete(ctx, queueName, handle)
+		case outcomeRetry:
+			err = client.ChangeVisibility(ctx, queueName, handle, p.backoff(r.receiveCount()))
+		case outcomeSkipped:
+			err = client.ChangeVisibility(ctx, queueName, handle, 0)
+		}
+		if err != nil {
+			// The message is received again when its visibility timeout passes
+			log.Printf("failed to settle message %s: %v", r.message.Details.Message.MessageId, err)
+		}
+	}
+
+	if len(retries) > 0 {
+		log.Printf("%d of %d messages are to be retried: %v", len(retries), len(results), errors.Join(retries...))
+	}
+	return response, nil
+}
+
+// deadLetter sends a copy of the message to the dead-letter queue with the reason of its failure.
+func (p retryPolicy) deadLetter(ctx context.Context, client *QueueClient, r messageResult) error {
+	m := r.message.Details.Message
+	attributes := Attributes{}
+	for name, attr := range m.MessageAttributes {
+		attributes[name] = types.MessageAttributeValue{
+			DataType:    aws.String(attr.DataType),
+			StringValue: optional(attr.StringValue),
+			BinaryValue: attr.BinaryValue,
+		}
+	}
+	attributes["FailureReason"] = StringAttribute(r.err.Error())
+	attributes["SourceQueue"] = StringAttribute(sourceQueue(r.message))
+	attributes["ReceiveCount"] = NumberAttribute(r.receiveCount())
+
+	message := Message{Body: m.Body, Attributes: attributes}
+	if IsFIFO(p.deadLetterQueue) {
+		message.GroupID = m.Attributes["MessageGroupId"]
+		if message.GroupID == "" {
+			message.GroupID = defaultGroupID
+		}
+		message.DeduplicationID = m.MessageId
+	}
+	_, err := client.Send(ctx, p.deadLetterQueue, message)
+	return err
+}
+
+// sourceQueue returns the name of the queue the message was received from, the last part of the queue ID.
+func sourceQueue(message YMQMessage) string {
+	queueID := message.Details.QueueId
+	return queueID[strings.LastIndex(queueID, ":")+1:]
+}
@@ -0,0 +1,247 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+)
+
+const (
+	// CorrelationIDAttribute is the message attribute that ties a reply to its request.
+	CorrelationIDAttribute = "CorrelationId"
+	// ReplyToAttribute is the message attribute with the name of the queue the reply to a request goes to.
+	ReplyToAttribute = "ReplyTo"
+
+	// maxWaitTime is the longest long polling the queue supports.
+	maxWaitTime = 20 * time.Second
+	// awaitRetryInterval is how often a waiter checks whether it can poll the reply queue in place of another one.
+	awaitRetryInterval = 100 * time.Millisecond
+	// releaseDelay is how long a reply of another requester stays hidden, so this one does not receive it
+	// again and again while it waits.
+	releaseDelay = time.Second
+)
+
+// ErrNoReplyTo is returned when replying to a message that is not a request.
+var ErrNoReplyTo = errors.New("the message has no " + ReplyToAttribute + " attribute")
+
+// Reply is a reply received from the reply queue.
+type Reply struct {
+	MessageID  string
+	Body       string
+	Attributes Attributes
+}
+
+// Requester sends requests to queues and awaits their replies in its own reply queue, for asynchronous
+// calls between functions. Every request carries a new correlation ID, and its reply the same one.
+//
+// A single Await polls the reply queue at a time and hands the replies of the other pending requests to their
+// waiters. Replies of requests nobody here awaits are made visible again after releaseDelay, so a reply queue
+// can be shared by several instances. It is safe for concurrent use.
+type Requester struct {
+	client     *QueueClient
+	replyQueue string
+
+	polling sync.Mutex
+
+	mu      sync.Mutex
+	waiters map[string]chan Reply
+}
+
+// NewRequester creates a requester awaiting replies in the reply queue.
+func NewRequester(client *QueueClient, replyQueue string) *Requester {
+	return &Requester{client: client, replyQueue: replyQueue, waiters: map[string]chan Reply{}}
+}
+
+// Request sends the message to the queue as a request and returns its correlation ID to await the reply with.
+func (r *Requester) Request(ctx context.Context, queueName string, message Message) (string, error) {
+	correlationID, err := randomID()
+	if err != nil {
+		return "", err
+	}
+	attributes := Attributes{}
+	for name, attr := range message.Attributes {
+		attributes[name] = attr
+	}
+	attributes[CorrelationIDAttribute] = StringAttribute(correlationID)
+	attributes[ReplyToAttribute] = StringAttribute(r.replyQueue)
+	message.Attributes = attributes
+
+	// The waiter is registered first, so a reply received by another Await before this one starts is kept
+	r.waiter(correlationID)
+	if _, err = r.client.Send(ctx, queueName, message); err != nil {
+		r.forget(correlationID)
+		return "", err
+	}
+	return correlationID, nil
+}
+
+// Await waits for the reply to the request with the correlation ID until the context is done.
+// The reply is deleted from the reply queue. A reply arriving after Await has given up is left in the queue.
+func (r *Requester) Await(ctx context.Context, correlationID string) (*Reply, error) {
+	replies := r.waiter(correlationID)
+	defer r.forget(correlationID)
+	for {
+		select {
+		case reply := <-replies:
+			return &reply, nil
+		default:
+		}
+		if r.polling.TryLock() {
+			err := r.poll(ctx)
+			r.polling.Unlock()
+			if err != nil {
+				return nil, err
+			}
+			continue
+		}
+		select {
+		case reply := <-replies:
+			return &reply, nil
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(awaitRetryInterval):
+		}
+	}
+}
+
+// Call sends the request and awaits its reply.
+func (r *Requester) Call(ctx context.Context, queueName string, message Message) (*Reply, error) {
+	correlationID, err := r.Request(ctx, queueName, message)
+	if err != nil {
+		return nil, err
+	}
+	return r.Await(ctx, correlationID)
+}
+
+// poll receives the replies in the reply queue with long polling, and hands them to their waiters.
+func (r *Requester) poll(ctx context.Context) error {
+	queueURL, err := r.client.QueueURL(ctx, r.replyQueue)
+	if err != nil {
+		return err
+	}
+	wait := maxWaitTime
+	if deadline, ok := ctx.Deadline(); ok {
+		wait = min(wait, time.Until(deadline))
+	}
+	out, err := r.client.sqs.ReceiveMessage(ctx, &sqs.ReceiveMessageInput{
+		QueueUrl:              aws.String(queueURL),
+		MaxNumberOfMessages:   maxBatchEntries,
+		WaitTimeSeconds:       int32(max(wait, 0) / time.Second),
+		MessageAttributeNames: []string{"All"},
+	})
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return ctxErr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to receive replies from queue %s: %w", r.replyQueue, err)
+	}
+
+	for _, m := range out.Messages {
+		handle := aws.ToString(m.ReceiptHandle)
+		correlationID := aws.ToString(m.MessageAttributes[CorrelationIDAttribute].StringValue)
+		r.mu.Lock()
+		replies, ok := r.waiters[correlationID]
+		r.mu.Unlock()
+		if !ok {
+			// Another requester awaits it
+			if err = r.client.ChangeVisibility(ctx, r.replyQueue, handle, releaseDelay); err != nil {
+				log.Printf("failed to release reply %s: %v", aws.ToString(m.MessageId), err)
+			}
+			continue
+		}
+		body := aws.ToString(m.Body)
+		_, offloaded := m.MessageAttributes[PayloadSizeAttribute]
+		if offloaded {
+			// The reply is skipped and received again when its visibility timeout passes,
+			// the other replies are still handed to their waiters
+			if body, err = r.client.fetchPayload(ctx, body); err != nil {
+				log.Printf("failed to fetch the body of reply %s: %v", aws.ToString(m.MessageId), err)
+				continue
+			}
+		}
+		if err = r.client.Delete(ctx, r.replyQueue, handle); err != nil {
+			log.Printf("failed to delete reply %s: %v", aws.ToString(m.MessageId), err)
+		}
+		if offloaded {
+			r.client.releasePayload(ctx, aws.ToString(m.Body))
+		}
+		select {
+		case replies <- Reply{MessageID: aws.ToString(m.MessageId), Body: body, Attributes: m.MessageAttributes}:
+		default:
+			// A duplicate of a reply already received
+		}
+	}
+	return nil
+}
+
+// waiter returns the channel the reply to the request is handed to, registering it if needed.
+func (r *Requester) waiter(correlationID string) chan Reply {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	replies, ok := r.waiters[correlationID]
+	if !ok {
+		replies = make(chan Reply, 1)
+		r.waiters[correlationID] = replies
+	}
+	return replies
+}
+
+func (r *Requester) forget(correlationID string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.waiters, correlationID)
+}
+
+// randomID returns a random hex ID, for correlation IDs and object keys.
+func randomID() (string, error) {
+	var id [16]byte
+	if _, err := rand.Read(id[:]); err != nil {
+		return "", fmt.Errorf("failed to generate an ID: %w", err)
+	}
+	return hex.EncodeToString(id[:]), nil
+}
+
+// Reply sends the message to the queue in the ReplyTo attribute of the request, with the correlation ID
+// of the request. Requests without ReplyTo are answered with ErrNoReplyTo.
+func (c *QueueClient) Reply(ctx context.Context, request YMQMessage, message Message) error {
+	replyTo := request.attribute(ReplyToAttribute)
+	if replyTo == "" {
+		return ErrNoReplyTo
+	}
+	correlationID := request.attribute(CorrelationIDAttribute)
+	attributes := Attributes{}
+	for name, attr := range message.Attributes {
+		attributes[name] = attr
+	}
+	if correlationID != "" {
+		attributes[CorrelationIDAttribute] = StringAttribute(correlationID)
+	}
+	message.Attributes = attributes
+	if IsFIFO(replyTo) {
+		// The replies of a request are ordered and deduplicated by its correlation ID
+		message.GroupID = correlationID
+		message.DeduplicationID = correlationID
+		if message.GroupID == "" {
+			message.GroupID = defaultGroupID
+		}
+		message.Delay = 0
+	}
+	_, err := c.Send(ctx, replyTo, message)
+	return err
+}
+
+// attribute returns the string value of the message attribute, or an empty string if the message has none.
+func (m YMQMessage) attribute(name string) string {
+	if attr := m.Details.Message.MessageAttributes[name]; attr != nil {
+		return attr.StringValue
+	}
+	return ""
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"sls-ymq-handler/sqslocal"
+)
+
+// serve delivers the messages of the queue to the Receiver until the test ends, the way the trigger does.
+func serve(t *testing.T, srv *sqslocal.Server, queueName string) {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+	go func() {
+		defer close(done)
+		for ctx.Err() == nil {
+			_, _ = srv.Deliver(queueName, 5, func(payload []byte) error {
+				var event YMQRequest
+				if err := json.Unmarshal(payload, &event); err != nil {
+					return err
+				}
+				_, err := Receiver(ctx, &event)
+				return err
+			})
+			time.Sleep(10 * time.Millisecond)
+		}
+	}()
+}
+
+func TestCallThroughReceiver(t *testing.T) {
+	srv := startLocalQueue(t, "input", "replies", "response")
+	t.Setenv("YMQ_NAME", "response")
+	serve(t, srv, "input")
+	client, err := queueClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	requester := NewRequester(client, "replies")
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	var wg sync.WaitGroup
+	for _, name := range []string{"first", "second", "third"} {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			reply, err := requester.Call(ctx, "input", Message{Body: fmt.Sprintf(`{"name": %q}`, name)})
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			var body map[string]string
+			if err = json.Unmarshal([]byte(reply.Body), &body); err != nil || body["name"] != name {
+				t.Errorf("the reply to %s is %s", name, reply.Body)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if n := len(srv.Messages("replies")); n != 0 {
+		t.Errorf("%d replies are left in the reply queue", n)
+	}
+	// Requests are answered to their ReplyTo only
+	if n := len(srv.Messages("response")); n != 0 {
+		t.Errorf("%d replies are sent to YMQ_NAME", n)
+	}
+}
+
+func TestAwaitLeavesForeignReplies(t *testing.T) {
+	srv := startLocalQueue(t, "replies")
+	client, err := queueClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = client.Send(context.Background(), "replies", Message{
+		Body:       "foreign",
+		Attributes: Attributes{CorrelationIDAttribute: StringAttribute("someone-else")},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 1500*time.Millisecond)
+	defer cancel()
+	_, err = NewRequester(client, "replies").Await(ctx, "mine")
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("await without a reply returned %v", err)
+	}
+	if m := srv.Messages("replies"); len(m) != 1 || m[0].Body != "foreign" {
+		t.Errorf("the foreign reply is not left in the queue: %+v", m)
+	}
+}
+
+func TestAwaitSkipsRepliesWithMissingPayloads(t *testing.T) {
+	startLocalQueue(t, "replies")
+	client, objects := withPayloadStore(t, 10, false)
+	requester := NewRequester(client, "replies")
+	// Another Await waits for the reply whose payload is lost
+	requester.waiter("lost")
+	defer requester.forget("lost")
+
+	ctx := context.Background()
+	for _, correlationID := range []string{"lost", "mine"} {
+		_, err := client.Send(ctx, "replies", Message{
+			Body:       fmt.Sprintf(`{"reply": %q}`, correlationID),
+			Attributes: Attributes{CorrelationIDAttribute: StringAttribute(correlationID)},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if correlationID == "lost" {
+			objects.objects = map[string][]byte{}
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	reply, err := requester.Await(ctx, "mine")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reply.Body != `{"reply": "mine"}` {
+		t.Errorf("unexpected reply %s", reply.Body)
+	}
+}
+
+func TestReceiverDoesNotReplyToItself(t *testing.T) {
+	srv := startLocalQueue(t, "input")
+	t.Setenv("YMQ_NAME", "input")
+	send(t, "input", `{"name": "loop"}`)
+
+	deliver(t, srv, "input")
+	if n := len(srv.Messages("input")); n != 0 {
+		t.Errorf("the receiver replied to its own queue, %d messages are left", n)
+	}
+}
@@ -2,8 +2,13 @@ package main
 
 import (
 	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"net/http"
 	"os"
+	"time"
 )
 
 type RequestContext struct {
@@ -40,17 +45,41 @@ type HttpResult struct {
 	IsBase64Encoded   bool              `json:"isBase64Encoded"`
 }
 
+// Sender puts a test message to the queue. A POST request with a JSON array in the body puts every element
+// of the array to the queue as a separate message, with SendMessageBatch. A GET request with await=true
+// sends the test message as a request and responds with its reply, see call.
+//
+// If YMQ_NAME is a FIFO queue, the group of a message is the value of the YMQ_GROUP_FIELD field of the message,
+// see fifoMessage.
+//
 //goland:noinspection GoUnusedExportedFunction,GoUnusedParameter
 func Sender(ctx context.Context, event *HttpEvent) (*HttpResult, error) {
 	ymqName := os.Getenv("YMQ_NAME")
+	client, err := queueClient()
+	if err != nil {
+		return nil, err
+	}
 
-	resp, err := sendMessageToQueue(
-		ctx,
-		ymqName,
-		`{"name":"test"}`,
-		"From Sender Function",
-		30,
-	)
+	if event.HttpMethod == http.MethodPost {
+		return sendBatch(ctx, client, ymqName, event)
+	}
+	if event.QueryStringParameters["await"] == "true" {
+		return call(ctx, client, ymqName, event)
+	}
+
+	message := Message{
+		Body: `{"name":"test"}`,
+		Attributes: Attributes{
+			"Origin": StringAttribute("From Sender Function"),
+			"SentAt": NumberAttribute(time.Now().Unix()),
+		},
+		Delay: 30,
+	}
+	if IsFIFO(ymqName) {
+		// The request ID deduplicates retries of the same request, while every request sends a new message
+		message = fifoMessage(message, event.RequestContext.RequestId)
+	}
+	resp, err := client.Send(ctx, ymqName, message)
 	if err != nil {
 		fmt.Println("Got an error sending the message:")
 		fmt.Println(err)
@@ -66,3 +95,156 @@ func Sender(ctx context.Context, event *HttpEvent) (*HttpResult, error) {
 		Body:       "Sent message with ID: " + *resp.MessageId,
 	}, nil
 }
+
+// defaultAwaitTimeout is how long call waits for the reply unless YMQ_AWAIT_TIMEOUT says otherwise.
+const defaultAwaitTimeout = 20 * time.Second
+
+// call sends the test message, with the name from the name query parameter, as a request with its replies
+// going to YMQ_REPLY_QUEUE, and responds with the body of the reply. It gives up after YMQ_AWAIT_TIMEOUT.
+func call(ctx context.Context, client *QueueClient, ymqName string, event *HttpEvent) (*HttpResult, error) {
+	replyQueue := os.Getenv("YMQ_REPLY_QUEUE")
+	if replyQueue == "" {
+		return &HttpResult{StatusCode: 500, Body: "YMQ_REPLY_QUEUE is not set"}, nil
+	}
+	timeout := defaultAwaitTimeout
+	if v := os.Getenv("YMQ_AWAIT_TIMEOUT"); v != "" {
+		var err error
+		if timeout, err = time.ParseDuration(v); err != nil {
+			return nil, fmt.Errorf("invalid YMQ_AWAIT_TIMEOUT: %w", err)
+		}
+	}
+
+	name := event.QueryStringParameters["name"]
+	if name == "" {
+		name = "test"
+	}
+	body, err := json.Marshal(Request{Name: name})
+	if err != nil {
+		return nil, err
+	}
+	message := Message{
+		Body: string(body),
+		Attributes: Attributes{
+			"Origin": StringAttribute("From Sender Function"),
+		},
+	}
+	if IsFIFO(ymqName) {
+		message = fifoMessage(message, event.RequestContext.RequestId)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	reply, err := NewRequester(client, replyQueue).Call(ctx, ymqName, message)
+	if errors.Is(err, context.DeadlineExceeded) {
+		return &HttpResult{StatusCode: 504, Body: "No reply within " + timeout.String()}, nil
+	}
+	if err != nil {
+		return &HttpResult{StatusCode: 500, Body: "Got an error calling the receiver: " + err.Error()}, nil
+	}
+	return &HttpResult{
+		StatusCode: 200,
+		Headers:    map[string]string{"Content-Type": "application/json"},
+		Body:       reply.Body,
+	}, nil
+}
+
+// sendBatch puts the elements of the JSON array from the body to the queue and returns the outcome of each of them.
+func sendBatch(ctx context.Context, client *QueueClient, ymqName string, event *HttpEvent) (*HttpResult, error) {
+	body := []byte(event.Body)
+	if event.IsBase64Encoded {
+		decoded, err := base64.StdEncoding.DecodeString(event.Body)
+		if err != nil {
+			return &HttpResult{StatusCode: 400, Body: "Invalid base64 body: " + err.Error()}, nil
+		}
+		body = decoded
+	}
+	var items []json.RawMessage
+	if err := json.Unmarshal(body, &items); err != nil {
+		return &HttpResult{StatusCode: 400, Body: "The body must be a JSON array: " + err.Error()}, nil
+	}
+
+	messages := make([]Message, len(items))
+	for i, item := range items {
+		messages[i] = Message{
+			Body: string(item),
+			Attributes: Attributes{
+				"Origin": StringAttribute("From Sender Function"),
+				"Index":  NumberAttribute(i),
+			},
+		}
+		if IsFIFO(ymqName) {
+			messages[i] = fifoMessage(messages[i], payloadField(item, os.Getenv("YMQ_DEDUP_FIELD")))
+		}
+	}
+	result, err := client.SendBatch(ctx, ymqName, messages)
+	if result == nil {
+		return &HttpResult{
+			StatusCode: 500,
+			Body:       "Got an error sending the messages: " + err.Error(),
+		}, nil
+	}
+	if err != nil {
+		fmt.Println("Got an error sending the messages:")
+		fmt.Println(err)
+	}
+
+	statusCode := 200
+	if len(result.Failed) > 0 {
+		statusCode = 500
+	}
+	data, err := json.Marshal(result)
+	if err != nil {
+		return nil, err
+	}
+	return &HttpResult{
+		StatusCode: statusCode,
+		Headers:    map[string]string{"Content-Type": "application/json"},
+		Body:       string(data),
+	}, nil
+}
+
+// defaultGroupID is the group of the messages without the group field.
+const defaultGroupID = "default"
+
+// fifoMessage prepares the message for a FIFO queue. The group ID is the value of the field of the body
+// named by YMQ_GROUP_FIELD ("group" by default), or defaultGroupID if the body has no such field.
+// FIFO queues do not delay single messages, so the delay is dropped. The message is deduplicated
+// by deduplicationID, or by its content if it is empty.
+func fifoMessage(message Message, deduplicationID string) Message {
+	field := os.Getenv("YMQ_GROUP_FIELD")
+	if field == "" {
+		field = "group"
+	}
+	message.GroupID = payloadField([]byte(message.Body), field)
+	if message.GroupID == "" {
+		message.GroupID = defaultGroupID
+	}
+	message.DeduplicationID = deduplicationID
+	message.Delay = 0
+	return message
+}
+
+// payloadField returns the value of a top-level string or number field of the JSON object,
+// or an empty string if there is no such field.
+func payloadField(body []byte, field string) string {
+	if field == "" {
+		return ""
+	}
+	var object map[string]json.RawMessage
+	if err := json.Unmarshal(body, &object); err != nil {
+		return ""
+	}
+	raw, ok := object[field]
+	if !ok {
+		return ""
+	}
+	var value string
+	if err := json.Unmarshal(raw, &value); err == nil {
+		return value
+	}
+	var number json.Number
+	if err := json.Unmarshal(raw, &number); err == nil {
+		return number.String()
+	}
+	return ""
+}
@@ -0,0 +1,693 @@
+// Package sqslocal is an in-process stand-in for Yandex Message Queue in tests of the ymq functions.
+//
+// It keeps queues in memory and implements the subset of the SQS JSON protocol the functions use:
+// CreateQueue, GetQueueUrl, GetQueueAttributes, SendMessage, SendMessageBatch, ReceiveMessage,
+// DeleteMessage and ChangeMessageVisibility. Requests are not authenticated.
+//
+// FIFO queues, the queues with names ending with .fifo, behave like in YMQ: every message needs a group ID,
+// messages are deduplicated by their deduplication ID or, with content-based deduplication, by their body
+// within a five-minute window, and a group is not received while one of its messages is in flight.
+// Deliver hands messages to a function the way the message queue trigger does.
+package sqslocal
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// accountID is the account part of the queue URLs.
+	accountID = "000000000000"
+	// dedupWindow is how long a FIFO queue remembers deduplication IDs.
+	dedupWindow = 5 * time.Minute
+	// defaultVisibilityTimeout is the visibility timeout of queues created without one.
+	defaultVisibilityTimeout = 30 * time.Second
+	// maxReceive is the largest number of messages received at once.
+	maxReceive = 10
+)
+
+// Attribute is a message attribute.
+type Attribute struct {
+	DataType    string `json:"DataType"`
+	StringValue string `json:"StringValue,omitempty"`
+	BinaryValue []byte `json:"BinaryValue,omitempty"`
+}
+
+// Message is a message stored in a queue.
+type Message struct {
+	ID              string
+	Body            string
+	Attributes      map[string]Attribute
+	GroupID         string
+	DeduplicationID string
+	SequenceNumber  string // Set in FIFO queues only
+	SentAt          time.Time
+	ReceiveCount    int
+	FirstReceivedAt time.Time
+
+	receiptHandle string
+	visibleAt     time.Time
+}
+
+type queue struct {
+	name              string
+	url               string
+	fifo              bool
+	contentDedup      bool
+	visibilityTimeout time.Duration
+	attributes        map[string]string
+	messages          []*Message
+	dedup             map[string]dedupEntry
+	sequence          uint64
+}
+
+type dedupEntry struct {
+	message *Message
+	expires time.Time
+}
+
+// Server is an in-memory stand-in for Yandex Message Queue.
+type Server struct {
+	// ReceiptHandles makes Deliver pass the receipt handles of the messages to the function,
+	// for functions that delete or hide the messages themselves. Deliver does not delete them then.
+	ReceiptHandles bool
+
+	mu     sync.Mutex
+	queues map[string]*queue
+	nextID int
+	http   *httptest.Server
+}
+
+// NewServer starts a server listening on a local port with the queues created with default attributes.
+// Stop it with Close.
+func NewServer(queues ...string) *Server {
+	s := &Server{queues: map[string]*queue{}}
+	s.http = httptest.NewServer(s)
+	for _, name := range queues {
+		if _, err := s.CreateQueue(name, nil); err != nil {
+			panic(err)
+		}
+	}
+	return s
+}
+
+// URL returns the endpoint of the server, e.g. http://127.0.0.1:12345.
+func (s *Server) URL() string {
+	return s.http.URL
+}
+
+// Close stops the server.
+func (s *Server) Close() {
+	s.http.Close()
+}
+
+// CreateQueue creates a queue with the attributes and returns its URL. Existing queues are kept as they are.
+// FIFO queues are created by their name, and FifoQueue=true is only checked to match it.
+// ContentBasedDeduplication and VisibilityTimeout (seconds) are supported, other attributes are only stored.
+func (s *Server) CreateQueue(name string, attributes map[string]string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if q, ok := s.queues[name]; ok {
+		return q.url, nil
+	}
+	fifo := strings.HasSuffix(name, ".fifo")
+	if v, ok := attributes["FifoQueue"]; ok && (v == "true") != fifo {
+		return "", &apiError{"InvalidParameterValue", "FIFO queue names must end with .fifo"}
+	}
+	q := &queue{
+		name:              name,
+		url:               s.http.URL + "/" + accountID + "/" + name,
+		fifo:              fifo,
+		contentDedup:      attributes["ContentBasedDeduplication"] == "true",
+		visibilityTimeout: defaultVisibilityTimeout,
+		attributes:        map[string]string{},
+		dedup:             map[string]dedupEntry{},
+	}
+	if q.contentDedup && !fifo {
+		return "", &apiError{"InvalidParameterValue", "ContentBasedDeduplication is only valid for FIFO queues"}
+	}
+	for k, v := range attributes {
+		q.attributes[k] = v
+	}
+	if v, ok := attributes["VisibilityTimeout"]; ok {
+		seconds, err := strconv.Atoi(v)
+		if err != nil {
+			return "", &apiError{"InvalidAttributeValue", "VisibilityTimeout must be a number of seconds"}
+		}
+		q.visibilityTimeout = time.Duration(seconds) * time.Second
+	}
+	s.queues[name] = q
+	return q.url, nil
+}
+
+// Messages returns copies of the messages in the queue in the order they were sent,
+// including the ones in flight.
+func (s *Server) Messages(queueName string) []Message {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	q, ok := s.queues[queueName]
+	if !ok {
+		return nil
+	}
+	messages := make([]Message, len(q.messages))
+	for i, m := range q.messages {
+		messages[i] = *m
+	}
+	return messages
+}
+
+// ExpireVisibility makes all messages of the queue in flight visible again, as if their visibility timeout passed.
+func (s *Server) ExpireVisibility(queueName string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if q, ok := s.queues[queueName]; ok {
+		for _, m := range q.messages {
+			m.visibleAt = time.Time{}
+		}
+	}
+}
+
+// apiError is an error returned to the client with its code.
+type apiError struct {
+	Code    string
+	Message string
+}
+
+func (e *apiError) Error() string { return e.Code + ": " + e.Message }
+
+// ServeHTTP routes the request to the SQS operation by its X-Amz-Target header.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	operation, ok := strings.CutPrefix(r.Header.Get("X-Amz-Target"), "AmazonSQS.")
+	if r.Method != http.MethodPost || !ok {
+		writeError(w, &apiError{"InvalidAction", "expected a POST with the X-Amz-Target header of SQS"})
+		return
+	}
+	var (
+		response any
+		err      error
+	)
+	switch operation {
+	case "CreateQueue":
+		response, err = s.createQueue(r)
+	case "GetQueueUrl":
+		response, err = s.getQueueURL(r)
+	case "GetQueueAttributes":
+		response, err = s.getQueueAttributes(r)
+	case "SendMessage":
+		response, err = s.sendMessage(r)
+	case "SendMessageBatch":
+		response, err = s.sendMessageBatch(r)
+	case "ReceiveMessage":
+		response, err = s.receiveMessage(r)
+	case "DeleteMessage":
+		response, err = s.deleteMessage(r)
+	case "ChangeMessageVisibility":
+		response, err = s.changeMessageVisibility(r)
+	default:
+		err = &apiError{"InvalidAction", "operation " + operation + " is not supported"}
+	}
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/x-amz-json-1.0")
+	_ = json.NewEncoder(w).Encode(response)
+}
+
+func writeError(w http.ResponseWriter, err error) {
+	apiErr, ok := err.(*apiError)
+	if !ok {
+		apiErr = &apiError{"InternalError", err.Error()}
+	}
+	status := http.StatusBadRequest
+	if apiErr.Code == "InternalError" {
+		status = http.StatusInternalServerError
+	}
+	w.Header().Set("Content-Type", "application/x-amz-json-1.0")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(map[string]string{
+		"__type":  "com.amazonaws.sqs#" + apiErr.Code,
+		"message": apiErr.Message,
+	})
+}
+
+func decode(r *http.Request, v any) error {
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		return &apiError{"MalformedInput", err.Error()}
+	}
+	return nil
+}
+
+// queueByURL returns the queue of the URL. The caller holds the lock.
+func (s *Server) queueByURL(queueURL string) (*queue, error) {
+	name := queueURL[strings.LastIndex(queueURL, "/")+1:]
+	q, ok := s.queues[name]
+	if !ok || queueURL == "" {
+		return nil, &apiError{"QueueDoesNotExist", "the queue " + queueURL + " does not exist"}
+	}
+	return q, nil
+}
+
+func (s *Server) createQueue(r *http.Request) (any, error) {
+	var in struct {
+		QueueName  string
+		Attributes map[string]string
+	}
+	if err := decode(r, &in); err != nil {
+		return nil, err
+	}
+	queueURL, err := s.CreateQueue(in.QueueName, in.Attributes)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]string{"QueueUrl": queueURL}, nil
+}
+
+func (s *Server) getQueueURL(r *http.Request) (any, error) {
+	var in struct{ QueueName string }
+	if err := decode(r, &in); err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	q, ok := s.queues[in.QueueName]
+	if !ok {
+		return nil, &apiError{"QueueDoesNotExist", "the queue " + in.QueueName + " does not exist"}
+	}
+	return map[string]string{"QueueUrl": q.url}, nil
+}
+
+func (s *Server) getQueueAttributes(r *http.Request) (any, error) {
+	var in struct{ QueueUrl string }
+	if err := decode(r, &in); err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	q, err := s.queueByURL(in.QueueUrl)
+	if err != nil {
+		return nil, err
+	}
+	attributes := map[string]string{
+		"QueueArn":                    "yrn:yc:ymq:ru-central1:" + accountID + ":" + q.name,
+		"VisibilityTimeout":           strconv.Itoa(int(q.visibilityTimeout / time.Second)),
+		"ApproximateNumberOfMessages": strconv.Itoa(len(q.messages)),
+		"FifoQueue":                   strconv.FormatBool(q.fifo),
+		"ContentBasedDeduplication":   strconv.FormatBool(q.contentDedup),
+	}
+	for k, v := range q.attributes {
+		if _, ok := attributes[k]; !ok {
+			attributes[k] = v
+		}
+	}
+	return map[string]any{"Attributes": attributes}, nil
+}
+
+// sendEntry is a message to send, of SendMessage or of an entry of SendMessageBatch.
+type sendEntry struct {
+	Id                     string
+	MessageBody            string
+	DelaySeconds           int32
+	MessageAttributes      map[string]Attribute
+	MessageGroupId         string
+	MessageDeduplicationId string
+}
+
+type sendResult struct {
+	Id                     string `json:",omitempty"`
+	MessageId              string
+	MD5OfMessageBody       string
+	MD5OfMessageAttributes string `json:",omitempty"`
+	SequenceNumber         string `json:",omitempty"`
+}
+
+func (s *Server) sendMessage(r *http.Request) (any, error) {
+	var in struct {
+		QueueUrl string
+		sendEntry
+	}
+	if err := decode(r, &in); err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	q, err := s.queueByURL(in.QueueUrl)
+	if err != nil {
+		return nil, err
+	}
+	return s.send(q, in.sendEntry)
+}
+
+func (s *Server) sendMessageBatch(r *http.Request) (any, error) {
+	var in struct {
+		QueueUrl string
+		Entries  []sendEntry
+	}
+	if err := decode(r, &in); err != nil {
+		return nil, err
+	}
+	if len(in.Entries) == 0 {
+		return nil, &apiError{"EmptyBatchRequest", "the batch has no entries"}
+	}
+	if len(in.Entries) > maxReceive {
+		return nil, &apiError{"TooManyEntriesInBatchRequest", "the batch has more than 10 entries"}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	q, err := s.queueByURL(in.QueueUrl)
+	if err != nil {
+		return nil, err
+	}
+	type failure struct {
+		Id          string
+		Code        string
+		Message     string
+		SenderFault bool
+	}
+	out := struct {
+		Successful []sendResult
+		Failed     []failure
+	}{Successful: []sendResult{}, Failed: []failure{}}
+	ids := map[string]bool{}
+	for _, entry := range in.Entries {
+		if ids[entry.Id] {
+			return nil, &apiError{"BatchEntryIdsNotDistinct", "the entry ID " + entry.Id + " is repeated"}
+		}
+		ids[entry.Id] = true
+		result, err := s.send(q, entry)
+		if err != nil {
+			apiErr := err.(*apiError)
+			out.Failed = append(out.Failed, failure{Id: entry.Id, Code: apiErr.Code, Message: apiErr.Message, SenderFault: true})
+			continue
+		}
+		result.Id = entry.Id
+		out.Successful = append(out.Successful, *result)
+	}
+	return out, nil
+}
+
+// send validates the message and appends it to the queue. The caller holds the lock.
+func (s *Server) send(q *queue, entry sendEntry) (*sendResult, error) {
+	if entry.MessageBody == "" {
+		return nil, &apiError{"MissingParameter", "the message body is empty"}
+	}
+	if q.fifo {
+		if entry.MessageGroupId == "" {
+			return nil, &apiError{"MissingParameter", "messages of FIFO queues need MessageGroupId"}
+		}
+		if entry.DelaySeconds != 0 {
+			return nil, &apiError{"InvalidParameterValue", "messages of FIFO queues cannot have DelaySeconds"}
+		}
+		if entry.MessageDeduplicationId == "" {
+			if !q.contentDedup {
+				return nil, &apiError{"MissingParameter",
+					"the queue has no content-based deduplication, messages need MessageDeduplicationId"}
+			}
+			sum := sha256.Sum256([]byte(entry.MessageBody))
+			entry.MessageDeduplicationId = hex.EncodeToString(sum[:])
+		}
+	} else if entry.MessageDeduplicationId != "" {
+		return nil, &apiError{"InvalidParameterValue", "MessageDeduplicationId is valid for FIFO queues only"}
+	}
+	for name, attr := range entry.MessageAttributes {
+		if err := validateAttribute(name, attr); err != nil {
+			return nil, err
+		}
+	}
+
+	now := time.Now()
+	if q.fifo {
+		if dup, ok := q.dedup[entry.MessageDeduplicationId]; ok && now.Before(dup.expires) {
+			// The duplicate is accepted as the original message, with the checksums of what was sent
+			result := resultOf(dup.message)
+			result.MD5OfMessageBody = md5Hex([]byte(entry.MessageBody))
+			result.MD5OfMessageAttributes = attributesMD5(entry.MessageAttributes)
+			return result, nil
+		}
+	}
+	s.nextID++
+	m := &Message{
+		ID:              fmt.Sprintf("%08d-0000-0000-0000-000000000000", s.nextID),
+		Body:            entry.MessageBody,
+		Attributes:      entry.MessageAttributes,
+		GroupID:         entry.MessageGroupId,
+		DeduplicationID: entry.MessageDeduplicationId,
+		SentAt:          now,
+		visibleAt:       now.Add(time.Duration(entry.DelaySeconds) * time.Second),
+	}
+	if q.fifo {
+		q.sequence++
+		m.SequenceNumber = fmt.Sprintf("%020d", q.sequence)
+		q.dedup[m.DeduplicationID] = dedupEntry{message: m, expires: now.Add(dedupWindow)}
+	}
+	q.messages = append(q.messages, m)
+	return resultOf(m), nil
+}
+
+func validateAttribute(name string, attr Attribute) error {
+	dataType, _, _ := strings.Cut(attr.DataType, ".")
+	switch dataType {
+	case "String":
+		if attr.StringValue == "" {
+			return &apiError{"InvalidParameterValue", "the String attribute " + name + " has no value"}
+		}
+	case "Number":
+		if _, err := strconv.ParseFloat(attr.StringValue, 64); err != nil {
+			return &apiError{"InvalidParameterValue", "the Number attribute " + name + " is not a number"}
+		}
+	case "Binary":
+		if len(attr.BinaryValue) == 0 {
+			return &apiError{"InvalidParameterValue", "the Binary attribute " + name + " has no value"}
+		}
+	default:
+		return &apiError{"InvalidParameterValue", "the attribute " + name + " has unknown type " + attr.DataType}
+	}
+	return nil
+}
+
+func resultOf(m *Message) *sendResult {
+	return &sendResult{
+		MessageId:              m.ID,
+		MD5OfMessageBody:       md5Hex([]byte(m.Body)),
+		MD5OfMessageAttributes: attributesMD5(m.Attributes),
+		SequenceNumber:         m.SequenceNumber,
+	}
+}
+
+func md5Hex(data []byte) string {
+	sum := md5.Sum(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// attributesMD5 returns the checksum of the message attributes the way SQS computes it,
+// or an empty string if there are none.
+func attributesMD5(attributes map[string]Attribute) string {
+	if len(attributes) == 0 {
+		return ""
+	}
+	names := make([]string, 0, len(attributes))
+	for name := range attributes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var buf []byte
+	appendValue := func(value []byte) {
+		buf = binary.BigEndian.AppendUint32(buf, uint32(len(value)))
+		buf = append(buf, value...)
+	}
+	for _, name := range names {
+		attr := attributes[name]
+		appendValue([]byte(name))
+		appendValue([]byte(attr.DataType))
+		if strings.HasPrefix(attr.DataType, "Binary") {
+			buf = append(buf, 2)
+			appendValue(attr.BinaryValue)
+		} else {
+			buf = append(buf, 1)
+			appendValue([]byte(attr.StringValue))
+		}
+	}
+	return md5Hex(buf)
+}
+
+// receivedMessage is a message of ReceiveMessage.
+type receivedMessage struct {
+	MessageId              string
+	ReceiptHandle          string
+	MD5OfBody              string
+	Body                   string
+	Attributes             map[string]string
+	MD5OfMessageAttributes string               `json:",omitempty"`
+	MessageAttributes      map[string]Attribute `json:",omitempty"`
+}
+
+func (s *Server) receiveMessage(r *http.Request) (any, error) {
+	var in struct {
+		QueueUrl            string
+		MaxNumberOfMessages int
+		VisibilityTimeout   *int
+		WaitTimeSeconds     int
+	}
+	if err := decode(r, &in); err != nil {
+		return nil, err
+	}
+	if in.MaxNumberOfMessages == 0 {
+		in.MaxNumberOfMessages = 1
+	}
+	if in.MaxNumberOfMessages < 1 || in.MaxNumberOfMessages > maxReceive {
+		return nil, &apiError{"InvalidParameterValue", "MaxNumberOfMessages must be from 1 to 10"}
+	}
+
+	deadline := time.Now().Add(time.Duration(in.WaitTimeSeconds) * time.Second)
+	for {
+		s.mu.Lock()
+		q, err := s.queueByURL(in.QueueUrl)
+		if err != nil {
+			s.mu.Unlock()
+			return nil, err
+		}
+		visibility := q.visibilityTimeout
+		if in.VisibilityTimeout != nil {
+			visibility = time.Duration(*in.VisibilityTimeout) * time.Second
+		}
+		messages := s.receive(q, in.MaxNumberOfMessages, visibility)
+		s.mu.Unlock()
+
+		if len(messages) > 0 || !time.Now().Before(deadline) {
+			return map[string]any{"Messages": messages}, nil
+		}
+		select {
+		case <-r.Context().Done():
+			return nil, r.Context().Err()
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+}
+
+// receive takes up to max visible messages and hides them for the visibility timeout. The caller holds the lock.
+// In FIFO queues the messages of a group are received in order, and not at all while one of them is in flight.
+func (s *Server) receive(q *queue, max int, visibility time.Duration) []receivedMessage {
+	now := time.Now()
+	blocked := map[string]bool{}
+	var messages []receivedMessage
+	for _, m := range q.messages {
+		if len(messages) == max {
+			break
+		}
+		visible := !now.Before(m.visibleAt)
+		if q.fifo {
+			if blocked[m.GroupID] {
+				continue
+			}
+			if !visible {
+				blocked[m.GroupID] = true
+				continue
+			}
+		} else if !visible {
+			continue
+		}
+
+		s.nextID++
+		m.ReceiveCount++
+		if m.FirstReceivedAt.IsZero() {
+			m.FirstReceivedAt = now
+		}
+		m.receiptHandle = fmt.Sprintf("%s/%d", m.ID, s.nextID)
+		m.visibleAt = now.Add(visibility)
+		messages = append(messages, receivedMessage{
+			MessageId:              m.ID,
+			ReceiptHandle:          m.receiptHandle,
+			MD5OfBody:              md5Hex([]byte(m.Body)),
+			Body:                   m.Body,
+			Attributes:             systemAttributes(m),
+			MD5OfMessageAttributes: attributesMD5(m.Attributes),
+			MessageAttributes:      m.Attributes,
+		})
+	}
+	return messages
+}
+
+// systemAttributes returns all system attributes of the message, whatever the request asks for.
+func systemAttributes(m *Message) map[string]string {
+	attributes := map[string]string{
+		"SentTimestamp":                    strconv.FormatInt(m.SentAt.UnixMilli(), 10),
+		"ApproximateReceiveCount":          strconv.Itoa(m.ReceiveCount),
+		"ApproximateFirstReceiveTimestamp": strconv.FormatInt(m.FirstReceivedAt.UnixMilli(), 10),
+	}
+	if m.GroupID != "" {
+		attributes["MessageGroupId"] = m.GroupID
+	}
+	if m.DeduplicationID != "" {
+		attributes["MessageDeduplicationId"] = m.DeduplicationID
+	}
+	if m.SequenceNumber != "" {
+		attributes["SequenceNumber"] = m.SequenceNumber
+	}
+	return attributes
+}
+
+// messageByHandle returns the index of the message with the receipt handle. The caller holds the lock.
+func messageByHandle(q *queue, handle string) (int, error) {
+	for i, m := range q.messages {
+		if m.receiptHandle == handle && handle != "" {
+			return i, nil
+		}
+	}
+	return 0, &apiError{"ReceiptHandleIsInvalid", "the receipt handle " + handle + " is not valid"}
+}
+
+func (s *Server) deleteMessage(r *http.Request) (any, error) {
+	var in struct {
+		QueueUrl      string
+		ReceiptHandle string
+	}
+	if err := decode(r, &in); err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	q, err := s.queueByURL(in.QueueUrl)
+	if err != nil {
+		return nil, err
+	}
+	i, err := messageByHandle(q, in.ReceiptHandle)
+	if err != nil {
+		return nil, err
+	}
+	q.messages = append(q.messages[:i], q.messages[i+1:]...)
+	return struct{}{}, nil
+}
+
+func (s *Server) changeMessageVisibility(r *http.Request) (any, error) {
+	var in struct {
+		QueueUrl          string
+		ReceiptHandle     string
+		VisibilityTimeout int
+	}
+	if err := decode(r, &in); err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	q, err := s.queueByURL(in.QueueUrl)
+	if err != nil {
+		return nil, err
+	}
+	i, err := messageByHandle(q, in.ReceiptHandle)
+	if err != nil {
+		return nil, err
+	}
+	q.messages[i].visibleAt = time.Now().Add(time.Duration(in.VisibilityTimeout) * time.Second)
+	return struct{}{}, nil
+}
@@ -0,0 +1,223 @@
+package sqslocal
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
+	"github.com/aws/smithy-go"
+)
+
+func newClient(t *testing.T, queues ...string) (*Server, *sqs.Client) {
+	t.Helper()
+	srv := NewServer(queues...)
+	t.Cleanup(srv.Close)
+	client := sqs.New(sqs.Options{
+		Region:       "ru-central1",
+		Credentials:  aws.AnonymousCredentials{},
+		BaseEndpoint: aws.String(srv.URL()),
+	})
+	return srv, client
+}
+
+func queueURL(t *testing.T, client *sqs.Client, name string) *string {
+	t.Helper()
+	out, err := client.GetQueueUrl(context.Background(), &sqs.GetQueueUrlInput{QueueName: aws.String(name)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return out.QueueUrl
+}
+
+func TestSendReceiveDelete(t *testing.T) {
+	ctx := context.Background()
+	_, client := newClient(t, "plain")
+	url := queueURL(t, client, "plain")
+
+	_, err := client.SendMessage(ctx, &sqs.SendMessageInput{
+		QueueUrl:    url,
+		MessageBody: aws.String("hello"),
+		MessageAttributes: map[string]types.MessageAttributeValue{
+			"Origin": {DataType: aws.String("String"), StringValue: aws.String("test")},
+			"Count":  {DataType: aws.String("Number"), StringValue: aws.String("3")},
+			"Raw":    {DataType: aws.String("Binary"), BinaryValue: []byte{1, 2, 3}},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	out, err := client.ReceiveMessage(ctx, &sqs.ReceiveMessageInput{QueueUrl: url, MaxNumberOfMessages: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(out.Messages) != 1 || aws.ToString(out.Messages[0].Body) != "hello" {
+		t.Fatalf("unexpected messages: %+v", out.Messages)
+	}
+	m := out.Messages[0]
+	if string(m.MessageAttributes["Raw"].BinaryValue) != "\x01\x02\x03" || m.Attributes["ApproximateReceiveCount"] != "1" {
+		t.Errorf("unexpected attributes: %v %v", m.MessageAttributes, m.Attributes)
+	}
+
+	// The message is in flight and not received again
+	again, err := client.ReceiveMessage(ctx, &sqs.ReceiveMessageInput{QueueUrl: url})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(again.Messages) != 0 {
+		t.Errorf("the message in flight is received again")
+	}
+
+	if _, err = client.DeleteMessage(ctx, &sqs.DeleteMessageInput{QueueUrl: url, ReceiptHandle: m.ReceiptHandle}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = client.DeleteMessage(ctx, &sqs.DeleteMessageInput{QueueUrl: url, ReceiptHandle: m.ReceiptHandle}); err == nil {
+		t.Errorf("deleting the message twice succeeded")
+	}
+}
+
+func TestChangeVisibility(t *testing.T) {
+	ctx := context.Background()
+	srv, client := newClient(t, "plain")
+	url := queueURL(t, client, "plain")
+
+	if _, err := client.SendMessage(ctx, &sqs.SendMessageInput{QueueUrl: url, MessageBody: aws.String("x")}); err != nil {
+		t.Fatal(err)
+	}
+	out, err := client.ReceiveMessage(ctx, &sqs.ReceiveMessageInput{QueueUrl: url})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = client.ChangeMessageVisibility(ctx, &sqs.ChangeMessageVisibilityInput{
+		QueueUrl:          url,
+		ReceiptHandle:     out.Messages[0].ReceiptHandle,
+		VisibilityTimeout: 0,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err = client.ReceiveMessage(ctx, &sqs.ReceiveMessageInput{QueueUrl: url})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(out.Messages) != 1 || out.Messages[0].Attributes["ApproximateReceiveCount"] != "2" {
+		t.Fatalf("the message is not visible again: %+v", out.Messages)
+	}
+	if srv.Messages("plain")[0].ReceiveCount != 2 {
+		t.Errorf("receive count is not tracked")
+	}
+}
+
+func TestLongPolling(t *testing.T) {
+	ctx := context.Background()
+	_, client := newClient(t, "plain")
+	url := queueURL(t, client, "plain")
+
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		_, _ = client.SendMessage(ctx, &sqs.SendMessageInput{QueueUrl: url, MessageBody: aws.String("late")})
+	}()
+	out, err := client.ReceiveMessage(ctx, &sqs.ReceiveMessageInput{QueueUrl: url, WaitTimeSeconds: 5})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(out.Messages) != 1 {
+		t.Fatalf("long polling returned %d messages", len(out.Messages))
+	}
+}
+
+func TestFIFOOrderAndDeduplication(t *testing.T) {
+	ctx := context.Background()
+	srv, client := newClient(t)
+	out, err := client.CreateQueue(ctx, &sqs.CreateQueueInput{
+		QueueName: aws.String("orders.fifo"),
+		Attributes: map[string]string{
+			"FifoQueue":                 "true",
+			"ContentBasedDeduplication": "true",
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	url := out.QueueUrl
+
+	send := func(body, group string) *sqs.SendMessageOutput {
+		t.Helper()
+		out, err := client.SendMessage(ctx, &sqs.SendMessageInput{
+			QueueUrl:       url,
+			MessageBody:    aws.String(body),
+			MessageGroupId: aws.String(group),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return out
+	}
+	first := send("a1", "a")
+	send("b1", "b")
+	send("a2", "a")
+	if dup := send("a1", "a"); aws.ToString(dup.MessageId) != aws.ToString(first.MessageId) {
+		t.Errorf("the duplicate got a new message ID")
+	}
+	if n := len(srv.Messages("orders.fifo")); n != 3 {
+		t.Fatalf("the queue has %d messages, expected 3", n)
+	}
+
+	// Only the first message of each group is received while it is in flight
+	received, err := client.ReceiveMessage(ctx, &sqs.ReceiveMessageInput{QueueUrl: url, MaxNumberOfMessages: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if aws.ToString(received.Messages[0].Body) != "a1" {
+		t.Fatalf("received %s first", aws.ToString(received.Messages[0].Body))
+	}
+	rest, err := client.ReceiveMessage(ctx, &sqs.ReceiveMessageInput{QueueUrl: url, MaxNumberOfMessages: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rest.Messages) != 1 || aws.ToString(rest.Messages[0].Body) != "b1" {
+		t.Fatalf("group a is received while a1 is in flight: %+v", rest.Messages)
+	}
+
+	_, err = client.SendMessage(ctx, &sqs.SendMessageInput{QueueUrl: url, MessageBody: aws.String("no group")})
+	var apiErr smithy.APIError
+	if !errors.As(err, &apiErr) || apiErr.ErrorCode() != "MissingParameter" {
+		t.Errorf("a message without a group is accepted: %v", err)
+	}
+}
+
+func TestDeliver(t *testing.T) {
+	ctx := context.Background()
+	srv, client := newClient(t, "plain")
+	url := queueURL(t, client, "plain")
+	for _, body := range []string{"1", "2", "3"} {
+		if _, err := client.SendMessage(ctx, &sqs.SendMessageInput{QueueUrl: url, MessageBody: aws.String(body)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	n, err := srv.Deliver("plain", 2, func([]byte) error { return errors.New("failed") })
+	if n != 2 || err == nil {
+		t.Fatalf("delivered %d, %v", n, err)
+	}
+	if len(srv.Messages("plain")) != 3 {
+		t.Errorf("messages of a failed delivery are deleted")
+	}
+
+	srv.ExpireVisibility("plain")
+	var payload []byte
+	n, err = srv.Deliver("plain", 10, func(p []byte) error { payload = p; return nil })
+	if n != 3 || err != nil {
+		t.Fatalf("delivered %d, %v", n, err)
+	}
+	if len(srv.Messages("plain")) != 0 {
+		t.Errorf("messages of a successful delivery are kept")
+	}
+	if len(payload) == 0 {
+		t.Errorf("empty payload")
+	}
+}
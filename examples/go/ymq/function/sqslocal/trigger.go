@@ -0,0 +1,102 @@
+package sqslocal
+
+import (
+	"encoding/json"
+	"strconv"
+	"time"
+)
+
+// EventType is the event type of the messages delivered by the message queue trigger.
+const EventType = "yandex.cloud.events.messagequeue.QueueMessage"
+
+type payload struct {
+	Messages []payloadMessage `json:"messages"`
+}
+
+type payloadMessage struct {
+	EventMetadata struct {
+		EventID   string    `json:"event_id"`
+		EventType string    `json:"event_type"`
+		CreatedAt time.Time `json:"created_at"`
+		CloudID   string    `json:"cloud_id"`
+		FolderID  string    `json:"folder_id"`
+	} `json:"event_metadata"`
+	Details struct {
+		QueueID string `json:"queue_id"`
+		Message struct {
+			MessageID              string                      `json:"message_id"`
+			ReceiptHandle          string                      `json:"receipt_handle,omitempty"`
+			MD5OfBody              string                      `json:"md5_of_body"`
+			Body                   string                      `json:"body"`
+			Attributes             map[string]string           `json:"attributes"`
+			MessageAttributes      map[string]payloadAttribute `json:"message_attributes"`
+			MD5OfMessageAttributes string                      `json:"md5_of_message_attributes"`
+		} `json:"message"`
+	} `json:"details"`
+}
+
+type payloadAttribute struct {
+	DataType    string `json:"data_type"`
+	StringValue string `json:"string_value,omitempty"`
+	BinaryValue []byte `json:"binary_value,omitempty"`
+}
+
+// Deliver receives up to batchSize messages of the queue and calls the function with them in the payload
+// of the message queue trigger. As the trigger does, it deletes the messages if the function succeeds,
+// and leaves them in flight until their visibility timeout passes if it fails.
+// With ReceiptHandles the function settles the messages itself, so Deliver leaves them to it either way.
+// It returns the number of delivered messages, 0 if the queue has no visible messages.
+func (s *Server) Deliver(queueName string, batchSize int, function func(payload []byte) error) (int, error) {
+	s.mu.Lock()
+	q, ok := s.queues[queueName]
+	if !ok {
+		s.mu.Unlock()
+		return 0, &apiError{"QueueDoesNotExist", "the queue " + queueName + " does not exist"}
+	}
+	received := s.receive(q, batchSize, q.visibilityTimeout)
+	var p payload
+	for _, m := range received {
+		var pm payloadMessage
+		s.nextID++
+		pm.EventMetadata.EventID = strconv.Itoa(s.nextID)
+		pm.EventMetadata.EventType = EventType
+		pm.EventMetadata.CreatedAt = time.Now().UTC()
+		pm.Details.QueueID = "yrn:yc:ymq:ru-central1:" + accountID + ":" + queueName
+		pm.Details.Message.MessageID = m.MessageId
+		if s.ReceiptHandles {
+			pm.Details.Message.ReceiptHandle = m.ReceiptHandle
+		}
+		pm.Details.Message.MD5OfBody = m.MD5OfBody
+		pm.Details.Message.Body = m.Body
+		pm.Details.Message.Attributes = m.Attributes
+		pm.Details.Message.MD5OfMessageAttributes = m.MD5OfMessageAttributes
+		if len(m.MessageAttributes) > 0 {
+			pm.Details.Message.MessageAttributes = map[string]payloadAttribute{}
+			for name, attr := range m.MessageAttributes {
+				pm.Details.Message.MessageAttributes[name] = payloadAttribute(attr)
+			}
+		}
+		p.Messages = append(p.Messages, pm)
+	}
+	s.mu.Unlock()
+	if len(received) == 0 {
+		return 0, nil
+	}
+
+	data, err := json.Marshal(p)
+	if err != nil {
+		return 0, err
+	}
+	if err = function(data); err != nil || s.ReceiptHandles {
+		return len(received), err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, m := range received {
+		if i, err := messageByHandle(q, m.ReceiptHandle); err == nil {
+			q.messages = append(q.messages[:i], q.messages[i+1:]...)
+		}
+	}
+	return len(received), nil
+}
@@ -44,8 +44,12 @@ resource "yandex_iam_service_account" "ymq_writer" {
 }
 
 resource "yandex_resourcemanager_folder_iam_binding" "ymq_writer" {
+  // The sender reads the replies from the reply queue, and the receiver deletes and hides the messages
+  // of the input queue itself if delete_processed is set. Both keep large bodies in the payload bucket
   for_each = toset([
     "ymq.writer",
+    "ymq.reader",
+    "storage.editor",
   ])
   role      = each.value
   folder_id = var.folder_id
@@ -10,13 +10,18 @@ resource "yandex_function" "ymq_sender" {
   runtime            = "golang123"
   entrypoint         = "sender.Sender"
   memory             = "128"
-  execution_timeout  = "10"
+  // The sender awaits replies for up to YMQ_AWAIT_TIMEOUT
+  execution_timeout  = "30"
   content {
     zip_filename = archive_file.function_files.output_path
   }
   service_account_id = yandex_iam_service_account.ymq_writer.id
   environment = {
     "YMQ_NAME" = yandex_message_queue.input_queue.name
+    "YMQ_GROUP_FIELD" = var.group_field
+    "YMQ_REPLY_QUEUE" = yandex_message_queue.reply_queue.name
+    "YMQ_AWAIT_TIMEOUT" = "20s"
+    "YMQ_PAYLOAD_BUCKET" = yandex_storage_bucket.payloads.bucket
     "AWS_ACCESS_KEY_ID" = yandex_iam_service_account_static_access_key.ymq_writer.access_key
     "AWS_SECRET_ACCESS_KEY" = yandex_iam_service_account_static_access_key.ymq_writer.secret_key
   }
@@ -46,6 +51,11 @@ resource "yandex_function" "ymq_receiver" {
   service_account_id = yandex_iam_service_account.ymq_writer.id
   environment = {
     "YMQ_NAME" = yandex_message_queue.response_queue.name
+    // Poison messages are moved to the dead-letter queue of the input queue
+    "YMQ_DLQ_NAME" = yandex_message_queue.example_deadletter_queue.name
+    "YMQ_DELETE_PROCESSED" = var.delete_processed
+    "YMQ_PAYLOAD_BUCKET" = yandex_storage_bucket.payloads.bucket
+    "YMQ_PAYLOAD_DELETE" = "true"
     "AWS_ACCESS_KEY_ID" = yandex_iam_service_account_static_access_key.ymq_writer.access_key
     "AWS_SECRET_ACCESS_KEY" = yandex_iam_service_account_static_access_key.ymq_writer.secret_key
   }
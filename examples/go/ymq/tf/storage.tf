@@ -0,0 +1,20 @@
+resource "random_uuid" "payload-bucket-name" {}
+
+// The bodies of messages too large for the queue. They expire with the messages pointing to them
+resource "yandex_storage_bucket" "payloads" {
+  access_key = yandex_iam_service_account_static_access_key.ymq_writer.access_key
+  secret_key = yandex_iam_service_account_static_access_key.ymq_writer.secret_key
+  bucket     = random_uuid.payload-bucket-name.result
+
+  lifecycle_rule {
+    id      = "expire-payloads"
+    enabled = true
+    prefix  = "ymq/"
+    expiration {
+      days = 14
+    }
+  }
+  depends_on = [
+    yandex_resourcemanager_folder_iam_binding.ymq_writer
+  ]
+}
@@ -11,3 +11,20 @@ variable "zone" {
   default = "ru-central1-a"
 }
 
+variable "fifo" {
+  description = "Create the input queue as a FIFO queue"
+  type        = bool
+  default     = false
+}
+
+variable "group_field" {
+  description = "The field of the message body the sender takes the message group of FIFO messages from"
+  type        = string
+  default     = "group"
+}
+
+variable "delete_processed" {
+  description = "Let the receiver delete processed messages and hide failed ones itself, so only failed messages are retried"
+  type        = bool
+  default     = false
+}
@@ -1,5 +1,9 @@
 resource "yandex_message_queue" "input_queue" {
-  name                       = "input_queue"
+  name                        = var.fifo ? "input_queue.fifo" : "input_queue"
+  fifo_queue                  = var.fifo
+  content_based_deduplication = var.fifo
+  // FIFO queues do not delay single messages, the whole queue delays them instead
+  delay_seconds               = var.fifo ? 30 : 0
   visibility_timeout_seconds = 600
   receive_wait_time_seconds  = 20
   message_retention_seconds  = 1209600
@@ -15,7 +19,9 @@ resource "yandex_message_queue" "input_queue" {
 }
 
 resource "yandex_message_queue" "example_deadletter_queue" {
-  name       = "ymq_deadletter_example"
+  // The dead-letter queue of a FIFO queue has to be a FIFO queue as well
+  name       = var.fifo ? "ymq_deadletter_example.fifo" : "ymq_deadletter_example"
+  fifo_queue = var.fifo
   access_key = yandex_iam_service_account_static_access_key.sa_ymq_creator.access_key
   secret_key = yandex_iam_service_account_static_access_key.sa_ymq_creator.secret_key
   depends_on = [
@@ -32,4 +38,16 @@ resource "yandex_message_queue" "response_queue" {
   depends_on = [
     yandex_resourcemanager_folder_iam_binding.sa_ymq_creator
   ]
-}
\ No newline at end of file
+}
+
+// The replies to the requests of the sender. Replies nobody awaits any longer expire in an hour
+resource "yandex_message_queue" "reply_queue" {
+  name                       = "reply_queue"
+  visibility_timeout_seconds = 30
+  message_retention_seconds  = 3600
+  access_key                 = yandex_iam_service_account_static_access_key.sa_ymq_creator.access_key
+  secret_key                 = yandex_iam_service_account_static_access_key.sa_ymq_creator.secret_key
+  depends_on = [
+    yandex_resourcemanager_folder_iam_binding.sa_ymq_creator
+  ]
+}
@@ -18,6 +18,7 @@ require (
 require (
 	github.com/go-test/deep v1.1.1
 	github.com/google/uuid v1.6.0
+	sls-async-result v0.0.0
 )
 
 require (
@@ -92,3 +93,5 @@ require (
 	google.golang.org/protobuf v1.36.8 // indirect
 	gopkg.in/check.v1 v1.0.0-20190902080502-41f04d3bba15 // indirect
 )
+
+replace sls-async-result => ./examples/go/async/asyncresult
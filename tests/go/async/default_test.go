@@ -3,19 +3,18 @@ package async
 import (
 	"context"
 	"encoding/json"
-	"fmt"
 	"os"
 	"testing"
+	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/credentials"
 	"github.com/aws/aws-sdk-go-v2/service/sqs"
-	"github.com/aws/aws-sdk-go-v2/service/sqs/types"
-	"github.com/go-resty/resty/v2"
-	"github.com/go-test/deep"
 	"github.com/gruntwork-io/terratest/modules/terraform"
 	"github.com/stretchr/testify/assert"
+
+	asyncresult "sls-async-result"
 )
 
 func TestGoAsyncExample(t *testing.T) {
@@ -37,24 +36,10 @@ func TestGoAsyncExample(t *testing.T) {
 	ymqName := terraform.Output(t, terraformOptions, "ymq_name")
 	accessKey := terraform.Output(t, terraformOptions, "ymq_reader_access_key")
 	secretKey := terraform.Output(t, terraformOptions, "ymq_reader_secret_key")
+	failureYmqName := terraform.Output(t, terraformOptions, "failure_ymq_name")
 
 	funcId := terraform.Output(t, terraformOptions, "function_id")
 
-	restClient := resty.New()
-
-	body := map[string]string{
-		"name": "test",
-	}
-
-	bodyBytes, err := json.Marshal(body)
-
-	httpResp, _ := restClient.R().
-		SetHeader("Content-Type", "application/json").
-		ForceContentType("application/json").
-		SetBody(bodyBytes).
-		Post(fmt.Sprintf("https://functions.yandexcloud.net/%s?integration=async", funcId))
-	assert.Equal(t, 202, httpResp.StatusCode(), "Status code should be 202")
-
 	customResolver := aws.EndpointResolverWithOptionsFunc(func(service, region string, options ...interface{}) (aws.Endpoint, error) {
 		return aws.Endpoint{
 			URL:           "https://message-queue.api.cloud.yandex.net",
@@ -72,52 +57,40 @@ func TestGoAsyncExample(t *testing.T) {
 	}
 	ymqClient := sqs.NewFromConfig(cfg)
 
-	gQInput := &sqs.GetQueueUrlInput{
-		QueueName: &ymqName,
+	queueURL := func(name string) string {
+		urlRes, err := ymqClient.GetQueueUrl(ctx, &sqs.GetQueueUrlInput{QueueName: &name})
+		if err != nil {
+			t.Fatalf("Got an error getting the queue URL: %s", err)
+		}
+		return *urlRes.QueueUrl
 	}
 
-	urlRes, err := ymqClient.GetQueueUrl(ctx, gQInput)
+	client := asyncresult.NewClient(ymqClient, queueURL(ymqName), queueURL(failureYmqName))
 
-	if err != nil {
-		t.Errorf("Got an error getting the queue URL: %s", err)
+	body := map[string]string{
+		"name": "test",
 	}
 
-	resp, err := ymqClient.ReceiveMessage(context.TODO(), &sqs.ReceiveMessageInput{
-		QueueUrl:            urlRes.QueueUrl,
-		MaxNumberOfMessages: 1,
-		MessageAttributeNames: []string{
-			"All",
-		},
-		AttributeNames: []types.QueueAttributeName{
-			types.QueueAttributeNameAll,
-		},
-		WaitTimeSeconds: 20,
-	})
+	bodyBytes, err := json.Marshal(body)
 	if err != nil {
-		fmt.Println("Got an error receiving the message:")
-		fmt.Println(err)
-		return
-	}
-
-	// delete message from queue
-	dmInput := &sqs.DeleteMessageInput{
-		QueueUrl:      urlRes.QueueUrl,
-		ReceiptHandle: resp.Messages[0].ReceiptHandle,
+		t.Fatal(err)
 	}
-	_, err = ymqClient.DeleteMessage(context.Background(), dmInput)
 
-	print(resp)
-
-	result := make(map[string]interface{})
-	err = json.Unmarshal([]byte(*resp.Messages[0].Body), &result)
+	awaitCtx, cancel := context.WithTimeout(ctx, time.Minute)
+	defer cancel()
+	result, err := client.Call(awaitCtx, funcId, bodyBytes)
 	if err != nil {
-		return
+		t.Fatalf("Got an error calling the function: %s", err)
 	}
-	expected := map[string]interface{}{
-		"result": "success",
-		"name":   "test",
+
+	var response struct {
+		Name   string `json:"name"`
+		Result string `json:"result"`
 	}
-	if diff := deep.Equal(expected, result); diff != nil {
-		t.Error(diff)
+	if err = result.Decode(&response); err != nil {
+		t.Fatal(err)
 	}
+	assert.NotEmpty(t, result.RequestID, "Result should carry the request ID")
+	assert.Equal(t, "success", response.Result)
+	assert.Equal(t, "test", response.Name)
 }
@@ -58,7 +58,7 @@ func TestGoStorageExample(t *testing.T) {
 		})
 		_, _ = s3Client.DeleteObject(ctx, &s3.DeleteObjectInput{
 			Bucket: aws.String(bucket),
-			Key:    aws.String("thumbnails/star.png"),
+			Key:    aws.String("thumbnail/100x100/star.png"),
 		})
 		_, _ = s3Client.DeleteObject(ctx, &s3.DeleteObjectInput{
 			Bucket: aws.String(bucketForFunction),
@@ -98,7 +98,7 @@ func TestGoStorageExample(t *testing.T) {
 
 	_, err = s3Client.GetObject(ctx, &s3.GetObjectInput{
 		Bucket: aws.String(bucket),
-		Key:    aws.String("thumbnail/star.png"),
+		Key:    aws.String("thumbnail/100x100/star.png"),
 	})
 
 	if err != nil {